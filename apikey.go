@@ -0,0 +1,234 @@
+package streamnative
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const secretAPIKeyType = "streamnative_apikey"
+
+// defaultAPIKeyTTL is the lifetime of API keys for roles without apikey_ttl.
+const defaultAPIKeyTTL = 720 * time.Hour
+
+// apiKeyNameUnsafe matches the characters of a role name that cannot appear
+// in an API key name.
+var apiKeyNameUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func (b *backend) pathAPIKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "apikey/" + framework.MatchAllRegex("name"),
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the API key. Defaults to, and may not exceed, the role's apikey_ttl.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleAPIKeyRead,
+				Summary:  "Create an API key for the role's service account.",
+			},
+		},
+
+		HelpSynopsis:    "Create a long-lived StreamNative API key for a role.",
+		HelpDescription: "Creates an API key for the role's service account with `snctl create apikey` and returns it as a lease. Revoking the lease deletes the API key.",
+	}
+}
+
+// secretAPIKey is the lease wrapping every API key created by the backend.
+func (b *backend) secretAPIKey() *framework.Secret {
+	return &framework.Secret{
+		Type: secretAPIKeyType,
+		Fields: map[string]*framework.FieldSchema{
+			"api_key": {
+				Type:        framework.TypeString,
+				Description: "StreamNative API key",
+			},
+		},
+
+		Revoke: b.handleAPIKeyRevoke,
+	}
+}
+
+func (b *backend) handleAPIKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	if conf.Mode == modeOAuth2 {
+		return logical.ErrorResponse("API keys are created with snctl and are not supported in oauth2 mode"), nil
+	}
+
+	name := data.Get("name").(string)
+	role, err := b.readRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("No role named '%s'", name), nil
+	}
+	resolved, _, err := b.withOrgAccountKey(ctx, req.Storage, role)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return missingKeyResponse(role), nil
+	}
+	role = resolved
+	conf = conf.forAccount(role)
+	if invalidResponse := validateKeyData(role); invalidResponse != nil {
+		return invalidResponse, nil
+	}
+
+	maxTTL := storedSeconds(role, "apikey_ttl")
+	if maxTTL <= 0 {
+		maxTTL = defaultAPIKeyTTL
+	}
+	ttl := maxTTL
+	if requested, ok := data.GetOk("ttl"); ok && requested.(int) > 0 {
+		ttl = time.Duration(requested.(int)) * time.Second
+		if ttl > maxTTL {
+			return logical.ErrorResponse("ttl exceeds the role's apikey_ttl of %s", maxTTL), nil
+		}
+	}
+
+	keyName, err := newAPIKeyName(name)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	apiKey, err := b.createAPIKey(ctx, conf, role, keyName, expiresAt)
+	if err != nil {
+		return nil, mintErrorResponse(err)
+	}
+	b.Logger().Info("Created API key", "role", name, "key_name", keyName, "expires_at", expiresAt.UTC().Format(time.RFC3339))
+
+	resp := b.Secret(secretAPIKeyType).Response(map[string]interface{}{
+		"api_key":    apiKey,
+		"key_name":   keyName,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	}, map[string]interface{}{
+		"role":         name,
+		"organization": role["organization"],
+		"key_name":     keyName,
+	})
+	resp.Secret.TTL = ttl
+	return resp, nil
+}
+
+// handleAPIKeyRevoke deletes the lease's API key with snctl, activating the
+// service account of the role that created it.
+func (b *backend) handleAPIKeyRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name, _ := req.Secret.InternalData["role"].(string)
+	keyName, _ := req.Secret.InternalData["key_name"].(string)
+	if name == "" || keyName == "" {
+		return nil, fmt.Errorf("lease is missing its role or API key name")
+	}
+	role, err := b.readRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role '%s' no longer exists, so API key '%s' cannot be deleted; delete it with snctl", name, keyName)
+	}
+	resolved, _, err := b.withOrgAccountKey(ctx, req.Storage, role)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return nil, fmt.Errorf("role '%s' has no key to delete API key '%s' with", name, keyName)
+	}
+	role = resolved
+	// Delete the key in the organization it was created in.
+	if org, ok := req.Secret.InternalData["organization"].(string); ok {
+		role["organization"] = org
+	}
+	if err := b.deleteAPIKey(ctx, b.config().forAccount(role), role, keyName); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("deleting API key '%s' failed: {{err}}", keyName), err)
+	}
+	b.Logger().Info("Deleted API key", "role", name, "key_name", keyName)
+	return nil, nil
+}
+
+// newAPIKeyName returns a unique API key name identifying the role.
+func newAPIKeyName(role string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errwrap.Wrapf("generating API key name failed: {{err}}", err)
+	}
+	prefix := strings.Trim(apiKeyNameUnsafe.ReplaceAllString(strings.ToLower(role), "-"), "-")
+	return fmt.Sprintf("vault-%s-%s", prefix, hex.EncodeToString(suffix)), nil
+}
+
+// serviceAccountName is the name of the key file's service account, the
+// local part of its client_email.
+func serviceAccountName(keyFile string) (string, error) {
+	var key serviceAccountKey
+	if err := jsonutil.DecodeJSON([]byte(keyFile), &key); err != nil {
+		return "", errwrap.Wrapf("key-file is not valid JSON: {{err}}", err)
+	}
+	name, _, ok := strings.Cut(key.ClientEmail, "@")
+	if !ok || name == "" {
+		return "", fmt.Errorf("key-file has no client_email naming its service account")
+	}
+	return name, nil
+}
+
+// createAPIKey runs `snctl create apikey` for the account's service account
+// and returns the new key.
+func (b *backend) createAPIKey(ctx context.Context, conf *snctlConfig, data map[string]interface{}, keyName string, expiresAt time.Time) (string, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+	release, err := b.limiter.acquire(waitCtx, conf.MaxConcurrentTokens)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var out []byte
+	err = b.withServiceAccount(ctx, conf, data, func(home string, keyFile string, _ string, _ []byte) error {
+		// The key is created for the service account of the key file in use.
+		serviceAccount, err := serviceAccountName(keyFile)
+		if err != nil {
+			return err
+		}
+		// Creation is not idempotent, so it is never retried with the same
+		// key file.
+		out, err = b.runSnctl(ctx, conf, home, nil, "create apikey",
+			"-n", data["organization"].(string), "create", "apikey", keyName,
+			"--service-account-name", serviceAccount,
+			"--expiration-time", expiresAt.UTC().Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		b.Logger().Error("Failed to run `snctl create apikey`", "error", err, "out_bytes", len(out), "out_fingerprint", fingerprint(out))
+		return "", err
+	}
+	apiKey := parseTokenOutput(out)
+	if apiKey == "" {
+		return "", fmt.Errorf("`snctl create apikey` printed no API key")
+	}
+	return apiKey, nil
+}
+
+// deleteAPIKey runs `snctl delete apikey` as the account's service account.
+func (b *backend) deleteAPIKey(ctx context.Context, conf *snctlConfig, data map[string]interface{}, keyName string) error {
+	return b.withServiceAccount(ctx, conf, data, func(home string, _ string, _ string, _ []byte) error {
+		_, err := b.runSnctlWithRetry(ctx, conf, home, nil, "delete apikey",
+			"-n", data["organization"].(string), "delete", "apikey", keyName)
+		return err
+	})
+}
@@ -0,0 +1,114 @@
+package streamnative
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestAPIKeyLease(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("create apikey", snctltest.Response{Stdout: "test-api-key\n"})
+	tb.snctl.On("delete apikey", snctltest.Response{})
+	tb.writeRole(t, "keyed", map[string]interface{}{"apikey_ttl": "48h"})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "apikey/keyed", map[string]interface{}{"ttl": "24h"})
+	if resp.Data["api_key"] != "test-api-key" {
+		t.Fatalf("expected the created API key, got %v", resp.Data["api_key"])
+	}
+	if resp.Secret == nil || resp.Secret.TTL != 24*time.Hour {
+		t.Fatalf("expected a lease of the requested ttl, got %v", resp.Secret)
+	}
+	keyName, _ := resp.Data["key_name"].(string)
+	if !strings.HasPrefix(keyName, "vault-keyed-") {
+		t.Fatalf("expected a key name identifying the role, got %q", keyName)
+	}
+	created := tb.snctl.Calls("create apikey")
+	if len(created) != 1 {
+		t.Fatalf("expected one create, got %d", len(created))
+	}
+	if got := argAfter(created[0].Args, "--service-account-name"); got != "sa" {
+		t.Fatalf("expected the key file's service account, got %q", got)
+	}
+	if got := argAfter(created[0].Args, "-n"); got != "test-org" {
+		t.Fatalf("expected the role's organization, got %q", got)
+	}
+
+	revoked, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   tb.storage,
+		Secret:    resp.Secret,
+	})
+	if msg := errorText(revoked, err); msg != "" {
+		t.Fatalf("revoking failed: %s", msg)
+	}
+	deleted := tb.snctl.Calls("delete apikey")
+	if len(deleted) != 1 {
+		t.Fatalf("expected revocation to delete the key, got %d deletes", len(deleted))
+	}
+	if args := strings.Join(deleted[0].Args, " "); !strings.Contains(args, "delete apikey "+keyName) {
+		t.Fatalf("expected the lease's key to be deleted, got %q", args)
+	}
+}
+
+func TestAPIKeyTTLLimited(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("create apikey", snctltest.Response{Stdout: "test-api-key\n"})
+	tb.writeRole(t, "keyed", map[string]interface{}{"apikey_ttl": "1h"})
+
+	resp, err := tb.request(t, logical.ReadOperation, "apikey/keyed", map[string]interface{}{"ttl": "2h"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "apikey_ttl") {
+		t.Fatalf("expected a ttl over apikey_ttl to be refused, got %q", msg)
+	}
+	if calls := tb.snctl.Calls("create apikey"); len(calls) != 0 {
+		t.Fatalf("expected no key to be created, got %d", len(calls))
+	}
+}
+
+func TestAPIKeyFailover(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("create apikey", snctltest.Response{Stdout: "test-api-key\n"})
+	tb.snctl.On("delete apikey", snctltest.Response{})
+	tb.writeRole(t, "failover", map[string]interface{}{
+		"key-file": nil,
+		"key_files": []interface{}{
+			testKeyFileFor("revoked-client"),
+			strings.ReplaceAll(testKeyFileFor("new-client"), `"sa@`, `"new-sa@`),
+		},
+	})
+	rejectActivation(tb, "revoked-client")
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "apikey/failover", nil)
+	if resp.Data["api_key"] != "test-api-key" {
+		t.Fatalf("expected the created API key, got %v", resp.Data["api_key"])
+	}
+	created := tb.snctl.Calls("create apikey")
+	if len(created) != 1 || argAfter(created[0].Args, "--service-account-name") != "new-sa" {
+		t.Fatalf("expected the key to be created for the second key file's service account, got %v", created)
+	}
+	revoked, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   tb.storage,
+		Secret:    resp.Secret,
+	})
+	if msg := errorText(revoked, err); msg != "" {
+		t.Fatalf("revoking failed: %s", msg)
+	}
+	if deleted := tb.snctl.Calls("delete apikey"); len(deleted) != 1 {
+		t.Fatalf("expected revocation to delete the key, got %d deletes", len(deleted))
+	}
+
+	// When no key file is usable, the read fails without creating a key.
+	rejectActivation(tb, "new-client")
+	resp, err = tb.request(t, logical.ReadOperation, "apikey/failover", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "invalid_client") {
+		t.Fatalf("expected the rejected key files to fail the read, got %q", msg)
+	}
+	if created := tb.snctl.Calls("create apikey"); len(created) != 1 {
+		t.Fatalf("expected no further key to be created, got %d creates", len(created))
+	}
+}
@@ -2,25 +2,80 @@ package streamnative
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // backend wraps the backend framework and adds a map for storing key value pairs
 type backend struct {
 	*framework.Backend
+
+	conf        atomic.Pointer[snctlConfig]
+	initialized atomic.Bool
+	breaker     circuitBreaker
+	limiter     concurrencyLimiter
+	cache       *tokenCache
+
+	// closed is set by cleanup when the mount is torn down.
+	closed atomic.Bool
+
+	// managedHomeLogged is set once the fallback to a plugin-managed HOME
+	// has been logged.
+	managedHomeLogged atomic.Bool
+	// mountID names this mount's plugin-managed HOME, so that mounts sharing
+	// the plugin process never share or remove one another's.
+	mountID string
+
+	// runner runs snctl. Tests replace it to fake snctl.
+	runner commandRunner
+
+	// view is the mount's storage, from which invalidate reloads the config.
+	view logical.Storage
+
+	// lastSweep and lastRotationCheck are when the periodic tasks last ran.
+	// Vault runs the periodic function serially, so they need no lock.
+	lastSweep         time.Time
+	lastRotationCheck time.Time
+
+	// snctlLock serializes use of the shared snctl config directory.
+	snctlLock sync.Mutex
+	// snctlReadyPath is the shared snctl config directory last found to be
+	// initialized and writable. Guarded by snctlLock.
+	snctlReadyPath string
+
+	// configLock serializes config/snctl writes and deletes, so that one
+	// write's read-merge-store cannot drop the fields of a concurrent one and
+	// the snapshot swapped in last matches what was stored last.
+	configLock sync.Mutex
+
+	// writeLocks serialize writes to the same account, so that a guarded
+	// write's existence check still holds when it stores.
+	writeLocks []*locksutil.LockEntry
 }
 
 var _ logical.Factory = Factory
@@ -47,35 +102,163 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	b.view = conf.StorageView
+	b.mountID = conf.BackendUUID
+	if b.mountID == "" {
+		if b.mountID, err = uuid.GenerateUUID(); err != nil {
+			return nil, errwrap.Wrapf("generating the mount's ID failed: {{err}}", err)
+		}
+	}
 
 	return b, nil
 }
 
 func newBackend() (*backend, error) {
-	b := &backend{}
+	b := &backend{
+		cache:      newTokenCache(),
+		runner:     runCommand,
+		writeLocks: locksutil.CreateLocks(),
+	}
+	conf, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	b.setConfig(conf)
 
 	b.Backend = &framework.Backend{
 		Help:        strings.TrimSpace(helpText),
 		BackendType: logical.TypeLogical,
+		PathsSpecial: &logical.Paths{
+			// Accounts at other paths are seal wrapped per entry.
+			SealWrapStorage: []string{configStorageKey, rolePrefix, orgAccountPrefix, framework.WALPrefix},
+		},
 		Paths: framework.PathAppend(
+			[]*framework.Path{
+				b.pathReady(),
+				b.pathStats(),
+				b.pathTidyCache(),
+				b.pathCapabilities(),
+				b.pathSchema(),
+				b.pathConfig(),
+				b.pathInfo(),
+				b.pathHealth(),
+				b.pathRotate(),
+				b.pathCreds(),
+				b.pathValidate(),
+				b.pathBatchToken(),
+				b.pathAPIKey(),
+				b.pathCluster(),
+			},
+			b.pathRoles(),
+			b.pathOrgAccounts(),
 			b.paths(),
 		),
+		Secrets: []*framework.Secret{
+			b.secretToken(),
+			b.secretAPIKey(),
+		},
+		InitializeFunc:    b.initialize,
+		Clean:             b.cleanup,
+		Invalidate:        b.invalidate,
+		PeriodicFunc:      b.periodic,
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
+		RunningVersion:    Version,
 	}
 
 	return b, nil
 }
 
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if err := b.loadConfig(ctx, req.Storage); err != nil {
+		return err
+	}
+	conf := b.config()
+	// Key files left behind by a previous process that was killed mid-read
+	// are removed now rather than at the first sweep. Like the sweep, this
+	// spares files younger than temp_file_max_age, which may belong to
+	// another plugin process sharing the directory.
+	b.removeStaleTempFiles(conf)
+	b.warnShadowedAccounts(ctx, req.Storage)
+	if conf.EagerInit && conf.Mode == modeSnctl {
+		b.eagerInit(ctx, conf)
+	}
+	b.initialized.Store(true)
+	return nil
+}
+
+// reservedPathResponse refuses a catch-all request for a key the plugin keeps
+// its own state under, such as a write-ahead log entry.
+func reservedPathResponse(path string) *logical.Response {
+	return logical.ErrorResponse("'%s' is reserved for the plugin's own storage and cannot hold an account", path)
+}
+
+// warnShadowedAccounts logs a warning for each account stored by the
+// catch-all path at a key that another path now serves, such as health or
+// creds/<name>, as such accounts can no longer be read.
+func (b *backend) warnShadowedAccounts(ctx context.Context, s logical.Storage) {
+	keys, err := logical.CollectKeys(ctx, s)
+	if err != nil {
+		b.Logger().Warn("Listing storage for shadowed accounts failed", "error", err)
+		return
+	}
+	// The catch-all path that serves accounts is routed last.
+	catchAll := b.Paths[len(b.Paths)-1]
+	for _, key := range keys {
+		if isInternalKey(key) {
+			continue
+		}
+		if route := b.Route(key); route != nil && route != catchAll {
+			b.Logger().Warn("An account is stored at a path the plugin now serves itself and cannot be read; move it to a role", "path", key)
+		}
+	}
+}
+
+// cleanup releases the backend's resources when the mount is disabled or the
+// plugin reloads: it flushes the token cache, stops the periodic sweep and
+// removes this mount's plugin-managed HOME if it used one.
+func (b *backend) cleanup(ctx context.Context) {
+	b.closed.Store(true)
+	flushed := b.cache.flush()
+
+	b.snctlLock.Lock()
+	defer b.snctlLock.Unlock()
+	b.snctlReadyPath = ""
+	if b.managedHomeLogged.Load() {
+		dir := b.managedHome(b.config())
+		if err := os.RemoveAll(dir); err != nil {
+			b.Logger().Warn("Removing the plugin-managed HOME failed", "path", dir, "error", err)
+		}
+	}
+	b.Logger().Debug("Backend cleaned up", "flushed_tokens", flushed)
+}
+
+// invalidate reloads the config when another node changes it, so that
+// standbys and performance replicas serve reads with the current config.
+func (b *backend) invalidate(ctx context.Context, key string) {
+	if key != configStorageKey || b.view == nil {
+		return
+	}
+	if err := b.loadConfig(ctx, b.view); err != nil {
+		b.Logger().Error("Reloading the invalidated config failed", "error", err)
+	}
+}
+
 func (b *backend) paths() []*framework.Path {
 	return []*framework.Path{
 		{
 			Pattern: framework.MatchAllRegex("path"),
 
-			Fields: map[string]*framework.FieldSchema{
+			// Account fields are not declared here; handleWrite checks them
+			// against accountSchema itself.
+			TakesArbitraryInput: true,
+
+			Fields: withTokenRequestFields(map[string]*framework.FieldSchema{
 				"path": {
 					Type:        framework.TypeString,
 					Description: "Specifies the path of the secret.",
 				},
-			},
+			}),
 
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
@@ -93,6 +276,10 @@ func (b *backend) paths() []*framework.Path {
 					Callback: b.handleDelete,
 					Summary:  "Deletes the secret at the specified location.",
 				},
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleList,
+					Summary:  "List the service account paths under a prefix.",
+				},
 			},
 
 			ExistenceCheck: b.handleExistenceCheck,
@@ -100,8 +287,166 @@ func (b *backend) paths() []*framework.Path {
 	}
 }
 
+// readParamFields are the parameters accepted when reading a token. An account
+// may store defaults for any of them under default_params.
+func readParamFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Maximum age of a cached token to accept. Overrides the account's stored ttl.",
+		},
+		"format": {
+			Type:        framework.TypeString,
+			Description: "Set to 'pulsar_client' to also return a Pulsar client configuration using the token.",
+		},
+	}
+}
+
+// tokenRequestFields are all the parameters accepted when reading a token:
+// the read parameters plus those which only make sense per request.
+func tokenRequestFields() map[string]*framework.FieldSchema {
+	fields := readParamFields()
+	fields["binding"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Base64url SHA-256 thumbprint of the client's proof-of-possession key or certificate. The minted token is bound to it.",
+	}
+	fields["organization"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Organization to mint the token in, overriding the stored organization.",
+	}
+	fields["cluster"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Cluster to mint the token for, overriding the stored cluster.",
+	}
+	fields["audience"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Audience to request, overriding the stored audience.",
+	}
+	fields["subject"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Subject to mint the token for instead of the service account's. The account's allowed_subjects must include it, if set.",
+	}
+	fields["scopes"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Space or comma separated scopes to request. The account's allowed_scopes must include each, if set. Defaults to the account's default scopes.",
+	}
+	fields["superuser"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Mint a superuser (broker admin) token. The account must set allow_superuser.",
+	}
+	fields["include_claims"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Return the token's decoded claims. The signature is not verified.",
+	}
+	fields["issuer_url"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "HTTPS URL of the issuer to request the token from, overriding the stored issuer_url and the key file's.",
+	}
+	fields["no_cache"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Mint a fresh token instead of serving a cached one. The new token replaces the cached one.",
+	}
+	return fields
+}
+
+func withTokenRequestFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	for k, v := range tokenRequestFields() {
+		fields[k] = v
+	}
+	return fields
+}
+
+// tokenRequestParams returns the token request parameters in raw, dropping
+// anything else, such as fields captured from the path.
+func tokenRequestParams(raw map[string]interface{}) map[string]interface{} {
+	schema := tokenRequestFields()
+	params := make(map[string]interface{})
+	for k, v := range raw {
+		if _, ok := schema[k]; ok {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// parseDefaultParams validates an account's default_params against the same
+// rules applied to read-time parameters. The value may be an object or a JSON
+// encoded string.
+func parseDefaultParams(raw interface{}) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		params = v
+	case string:
+		if err := jsonutil.DecodeJSON([]byte(v), &params); err != nil {
+			return nil, errwrap.Wrapf("default_params is not a JSON object: {{err}}", err)
+		}
+	default:
+		return nil, fmt.Errorf("default_params is not an object")
+	}
+
+	schema := readParamFields()
+	for k := range params {
+		if _, ok := schema[k]; !ok {
+			return nil, fmt.Errorf("default_params contains unknown parameter '%s'", k)
+		}
+	}
+	fd := &framework.FieldData{Raw: params, Schema: schema}
+	if err := fd.Validate(); err != nil {
+		return nil, errwrap.Wrapf("default_params is invalid: {{err}}", err)
+	}
+	if err := validateFormat(fd.Get("format").(string)); err != nil {
+		return nil, errwrap.Wrapf("default_params is invalid: {{err}}", err)
+	}
+	return params, nil
+}
+
+// resolveReadParams merges the account's default_params with the parameters
+// supplied on the read, the latter taking precedence.
+func resolveReadParams(data map[string]interface{}, reqData map[string]interface{}) *framework.FieldData {
+	schema := readParamFields()
+	raw := make(map[string]interface{})
+	if defaults, ok := data["default_params"].(map[string]interface{}); ok {
+		for k, v := range defaults {
+			raw[k] = v
+		}
+	}
+	for k, v := range reqData {
+		if _, ok := schema[k]; ok {
+			raw[k] = v
+		}
+	}
+	return &framework.FieldData{Raw: raw, Schema: schema}
+}
+
+// cacheTtl returns the maximum age of a cached token to accept, preferring the
+// read parameter over the account's stored ttl. Zero means no limit besides
+// the token's own expiry.
+func cacheTtl(data map[string]interface{}, params *framework.FieldData) (time.Duration, error) {
+	if ttl, ok := params.GetOk("ttl"); ok {
+		return time.Duration(ttl.(int)) * time.Second, nil
+	}
+	var ttl64 int64
+	switch ttl := data["ttl"].(type) {
+	case nil:
+	case json.Number:
+		var err error
+		if ttl64, err = ttl.Int64(); err != nil {
+			return 0, errwrap.Wrapf("stored ttl is not an integer: {{err}}", err)
+		}
+	case int64:
+		ttl64 = ttl
+	default:
+		return 0, fmt.Errorf("stored ttl is not an integer: %v", ttl)
+	}
+	return time.Duration(ttl64) * time.Second, nil
+}
+
+// handleExistenceCheck reports whether an account is stored at the path,
+// using the same storage key as the write and delete handlers. Roles have
+// their own check, as they are stored under rolePrefix.
 func (b *backend) handleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
-	out, err := req.Storage.Get(ctx, req.Path)
+	out, err := req.Storage.Get(ctx, data.Get("path").(string))
 	if err != nil {
 		return false, errwrap.Wrapf("existence check failed: {{err}}", err)
 	}
@@ -109,73 +454,111 @@ func (b *backend) handleExistenceCheck(ctx context.Context, req *logical.Request
 	return out != nil, nil
 }
 
-func (b *backend) readCachedToken(data map[string]interface{}, path string) *string {
-	// TTL in whole seconds
-	ttl, hasTtl := data["ttl"]
-	if hasTtl {
-		cachedAt, hasCachedAt := data["cachedAt"]
-		if hasCachedAt {
-			token, hasToken := data["cachedToken"]
-			if hasToken {
-				ttl64, err := ttl.(json.Number).Int64()
-				if err != nil {
-					panic("ttl is not integer")
-				}
-				cachedAt64, err := cachedAt.(json.Number).Int64()
-				if err != nil {
-					panic("cachedAt is not integer")
-				}
-				now := time.Now().UnixMilli()
-				expiresAt := cachedAt64 + (ttl64 * 1000)
-				if now < expiresAt {
-					tokenStr := token.(string)
-					return &tokenStr
-				}
+// parseStringList accepts a list of strings or a comma separated string,
+// dropping empty entries.
+func parseStringList(raw interface{}) ([]string, error) {
+	var items []string
+	switch v := raw.(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []string:
+		items = v
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must contain only strings")
 			}
+			items = append(items, s)
 		}
+	default:
+		return nil, fmt.Errorf("must be a list of strings or a comma separated string")
 	}
-
-	return nil
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
 }
 
-func (b *backend) saveCachedToken(ctx context.Context, req *logical.Request, path string,
-	data map[string]interface{}, token string) error {
-
-	_, hasTtl := data["ttl"]
+// storedSeconds reads a duration stored as whole seconds.
+func storedSeconds(data map[string]interface{}, key string) time.Duration {
+	var seconds int64
+	switch v := data[key].(type) {
+	case json.Number:
+		seconds, _ = v.Int64()
+	case int64:
+		seconds = v
+	}
+	return time.Duration(seconds) * time.Second
+}
 
-	// If no ttl, do not cache tokens.
-	if !hasTtl {
+// storedStringList reads a list stored by parseStringList.
+func storedStringList(data map[string]interface{}, key string) []string {
+	raw, ok := data[key]
+	if !ok {
 		return nil
 	}
+	list, _ := parseStringList(raw)
+	return list
+}
 
-	data["cachedAt"] = time.Now().UnixMilli()
-	data["cachedToken"] = token
+// internalPrefixes are the storage prefixes of entries other than the
+// accounts stored by the catch-all path: organization defaults, roles and the
+// write-ahead log.
+var internalPrefixes = []string{orgAccountPrefix, rolePrefix, framework.WALPrefix}
 
-	buf, err := json.Marshal(data)
-	if err == nil {
-		// Store kv pairs in map at specified path
-		ent := &logical.StorageEntry{
-			Key:   path,
-			Value: buf,
+// isInternalKey reports whether key is the mount config or under one of
+// internalPrefixes.
+func isInternalKey(key string) bool {
+	if key == configStorageKey {
+		return true
+	}
+	for _, prefix := range internalPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
 		}
-		err = req.Storage.Put(ctx, ent)
-		if err == nil {
-			b.Logger().Debug("Token cache saved", "path", path)
-		} else {
-			b.Logger().Error("Saving to storage failed", "error", err)
+	}
+	return false
+}
+
+// internalConfigDir is the directory of the mount config and organization
+// defaults, hidden from the root listing along with internalPrefixes.
+const internalConfigDir = "config/"
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
-	} else {
-		b.Logger().Error("JSON encoding failed", "error", err)
 	}
+	return false
+}
 
-	return err
+// accountFreeze returns the account's freeze schedule. The schedule was
+// validated on write, so a schedule failing to parse is treated as absent.
+func accountFreeze(data map[string]interface{}) *freezeSchedule {
+	rawWindows, hasWindows := data["freeze_windows"]
+	if !hasWindows {
+		return nil
+	}
+	windows, err := splitFreezeWindows(rawWindows)
+	if err != nil {
+		return nil
+	}
+	timezone, _ := data["freeze_timezone"].(string)
+	sched, err := parseFreezeSchedule(windows, timezone)
+	if err != nil {
+		return nil
+	}
+	return sched
 }
 
 func validateKeyData(data map[string]interface{}) *logical.Response {
-	keyFileBytes := data["key-file"]
 	org := data["organization"]
-	cluster := data["cluster"]
-	if keyFileBytes == nil {
+	if len(accountKeyFiles(data)) == 0 {
 		resp := logical.ErrorResponse("No 'key-file' set")
 		return resp
 	}
@@ -183,211 +566,1387 @@ func validateKeyData(data map[string]interface{}) *logical.Response {
 		resp := logical.ErrorResponse("No 'organization' set")
 		return resp
 	}
-	if cluster == nil {
-		resp := logical.ErrorResponse("No 'cluster' set")
-		return resp
-	}
 	return nil
 }
 
-func (b *backend) readNewToken(ctx context.Context, req *logical.Request, path string, data map[string]interface{}) (*string, error) {
-	b.Logger().Debug("Reading new token")
-
-	if err := b.requireSnctlConfig(); err != nil {
-		b.Logger().Error("Initializing snctl config failed", "error", err)
-		return nil, err
+// migrateDefaultCluster moves the cluster of an account stored before
+// version 2 to default_cluster. It reports whether the account was changed.
+func migrateDefaultCluster(data map[string]interface{}) bool {
+	cluster, hasCluster := data["cluster"]
+	if !hasCluster {
+		return false
 	}
-
-	keyFileBytes := data["key-file"]
-	org := data["organization"]
-	cluster := data["cluster"]
-
-	// TempFile is always created with 0600 permissions
-	tmpKeyFile, err := ioutil.TempFile(os.TempDir(), "snio-key-*.json")
-	if err != nil {
-		b.Logger().Error("Failed to open temp file", "error", err)
-		return nil, err
+	delete(data, "cluster")
+	if _, hasDefault := data["default_cluster"]; !hasDefault {
+		data["default_cluster"] = cluster
 	}
-	defer os.Remove(tmpKeyFile.Name())
-	ioutil.WriteFile(tmpKeyFile.Name(), []byte(keyFileBytes.(string)), 0600)
+	return true
+}
 
-	if err := b.activateServiceAccount(tmpKeyFile.Name()); err != nil {
-		b.Logger().Error("Activating service account failed", "error", err)
-		return nil, err
+// parseScopes splits space or comma separated scopes, dropping duplicates and
+// sorting them, so that the same scopes requested in any order share a cached
+// token.
+func parseScopes(raw string) []string {
+	var scopes []string
+	for _, scope := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
 	}
+	sort.Strings(scopes)
+	return scopes
+}
 
-	cmd := exec.Command(GetSnctl(), "-n", org.(string), "auth", "get-token", cluster.(string), "-f", tmpKeyFile.Name())
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		b.Logger().Error("Failed to run `snctl auth get-token`", "error", err, "out", out)
-		return nil, err
+// accountKeyFiles returns the account's key files in the order they are
+// tried: key_files when set, otherwise the single key-file.
+func accountKeyFiles(data map[string]interface{}) []string {
+	if keyFiles, ok := data["key_files"].([]string); ok {
+		// As normalized by parseKeyFiles, before being stored.
+		return keyFiles
 	}
-	token := string(out)
-
-	err = b.saveCachedToken(ctx, req, path, data, token)
+	if rawKeyFiles, ok := data["key_files"].([]interface{}); ok {
+		keyFiles := make([]string, 0, len(rawKeyFiles))
+		for _, raw := range rawKeyFiles {
+			if keyFile, ok := raw.(string); ok {
+				keyFiles = append(keyFiles, keyFile)
+			}
+		}
+		return keyFiles
+	}
+	if keyFile, ok := data["key-file"].(string); ok {
+		return []string{keyFile}
+	}
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// parseKeyFiles accepts key_files as a list, or a JSON array, of key files,
+// each either a JSON object or its encoding as a string.
+func parseKeyFiles(raw interface{}) ([]string, error) {
+	var items []interface{}
+	switch v := raw.(type) {
+	case string:
+		if err := jsonutil.DecodeJSON([]byte(v), &items); err != nil {
+			return nil, errwrap.Wrapf("'key_files' is not a JSON array: {{err}}", err)
+		}
+	case []string:
+		for _, item := range v {
+			items = append(items, item)
+		}
+	case []interface{}:
+		if len(v) == 1 {
+			// A JSON array given where a list was expected, as from the CLI.
+			if s, ok := v[0].(string); ok && strings.HasPrefix(strings.TrimSpace(s), "[") {
+				return parseKeyFiles(s)
+			}
+		}
+		items = v
+	default:
+		return nil, fmt.Errorf("'key_files' must be a list of key files")
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("'key_files' must not be empty")
 	}
 
-	return &token, nil
+	keyFiles := make([]string, 0, len(items))
+	for i, item := range items {
+		var keyFile string
+		switch v := item.(type) {
+		case string:
+			keyFile = v
+		case map[string]interface{}:
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return nil, errwrap.Wrapf("json encoding failed: {{err}}", err)
+			}
+			keyFile = string(buf)
+		default:
+			return nil, fmt.Errorf("'key_files' entry %d is not a key file", i)
+		}
+		if err := validateKeyFile(keyFile); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("'key_files' entry %d: {{err}}", i), err)
+		}
+		keyFiles = append(keyFiles, keyFile)
+	}
+	return keyFiles, nil
 }
 
-func (b *backend) handleRead(ctx context.Context, req *logical.Request, fieldData *framework.FieldData) (*logical.Response, error) {
-	path := fieldData.Get("path").(string)
+// accountVersion is the format version of newly stored accounts. Accounts
+// stored before versioning have no version field and are read as version 0,
+// which has the same layout as version 1. Version 2 stores default_cluster in
+// place of cluster.
+const accountVersion = 2
 
-	// Decode the data
-	var data map[string]interface{}
-	ent, err := req.Storage.Get(ctx, path)
-	if err != nil {
-		b.Logger().Error("Reading from storage failed", "error", err)
-		return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+// storedAccountVersion returns the format version of a stored account.
+func storedAccountVersion(data map[string]interface{}) (int64, error) {
+	raw, ok := data["version"]
+	if !ok {
+		return 0, nil
 	}
-
-	secretBytes := ent.Value
-	if secretBytes == nil {
-		resp := logical.ErrorResponse("No value at %v%v", req.MountPoint, path)
-		return resp, nil
+	version, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("version is not a number")
 	}
+	return version.Int64()
+}
 
-	if err := jsonutil.DecodeJSON(secretBytes, &data); err != nil {
-		b.Logger().Error("JSON decoding failed", "error", err)
-		return nil, errwrap.Wrapf("json decoding failed: {{err}}", err)
+// accountSchema describes the fields an account write accepts.
+func accountSchema() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"key-file": {
+			Type:        framework.TypeString,
+			Description: "The service account key file, as exported by `snctl auth export-service-account`.",
+			Required:    true,
+		},
+		"key_files": {
+			Type:        framework.TypeSlice,
+			Description: "Service account key files to try in order until one mints a token. Use instead of key-file.",
+		},
+		"key_file_base64": {
+			Type:        framework.TypeString,
+			Description: "The service account key file, base64 encoded. Use instead of key-file.",
+		},
+		"organization": {
+			Type:        framework.TypeString,
+			Description: "The StreamNative organization the service account belongs to.",
+			Required:    true,
+		},
+		"default_cluster": {
+			Type:        framework.TypeString,
+			Description: "The Pulsar cluster tokens are minted for when a read does not name one. Without it, reads must pass cluster.",
+		},
+		"cluster": {
+			Type:        framework.TypeString,
+			Description: "Deprecated: use default_cluster, which it is stored as.",
+			Deprecated:  true,
+		},
+		"allowed_clusters": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Clusters the account may mint tokens for. Empty allows every cluster.",
+		},
+		"allowed_organizations": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Organizations other than the stored one that reads may request. Empty allows only the stored organization.",
+		},
+		"allowed_subjects": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Subjects reads may request tokens for. Empty allows every subject.",
+		},
+		"allowed_scopes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Space or comma separated scopes reads may request. Empty allows any scopes.",
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Maximum age of a cached token to serve, as a duration such as 10m or a number of seconds.",
+		},
+		"default_params": {
+			Type:        framework.TypeMap,
+			Description: "Defaults for the read parameters, overridden by those supplied on a read.",
+		},
+		"validate_token": {
+			Type:        framework.TypeBool,
+			Description: "Check that a minted token is a well-formed, unexpired JWT.",
+		},
+		"expected_audience": {
+			Type:        framework.TypeString,
+			Description: "Audience a minted token must carry. Implies validate_token.",
+		},
+		"freeze_windows": {
+			Type:        framework.TypeStringSlice,
+			Description: "Windows, such as 'Sat,Sun 00:00-23:59', during which no new tokens are minted.",
+		},
+		"freeze_timezone": {
+			Type:        framework.TypeString,
+			Description: "IANA time zone the freeze windows are in. Defaults to UTC.",
+		},
+		"audience": {
+			Type:        framework.TypeString,
+			Description: "Audience to request instead of the cluster's. Reads may override it.",
+		},
+		"rotation_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How often the key should be rotated. Only tracked and reported; keys are never rotated automatically.",
+		},
+		"allow_superuser": {
+			Type:        framework.TypeBool,
+			Description: "Allow reads to request superuser tokens.",
+		},
+		"allow_batch": {
+			Type:        framework.TypeBool,
+			Description: "Allow batch-token to mint tokens for this role. Policies granting batch-token are not checked against creds/<name>, so only set it on roles every batch-token caller may use.",
+		},
+		"issuer_url": {
+			Type:        framework.TypeString,
+			Description: "HTTPS URL of the issuer to use instead of the key file's. Reads may override it.",
+		},
+		"request_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Bounds each snctl invocation or token request for this account, overriding the mount's request_timeout.",
+		},
+		"apikey_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Lifetime of API keys created with apikey/<name>, and the most a read may request. Defaults to 720h.",
+		},
 	}
+}
 
-	if invalidResponse := validateKeyData(data); invalidResponse != nil {
-		return invalidResponse, nil
+// validateWriteData checks an account before it is stored, so that problems
+// surface on write rather than on the first read.
+func validateWriteData(data map[string]interface{}) *logical.Response {
+	for _, field := range []string{"organization", "cluster", "default_cluster"} {
+		value, present := data[field]
+		if !present && field != "organization" {
+			continue
+		}
+		if value, ok := value.(string); !ok || strings.TrimSpace(value) == "" {
+			return logical.ErrorResponse("'%s' must be a non-empty string", field)
+		}
 	}
-
-	token := b.readCachedToken(data, path)
-
-	if token == nil {
-		token, err = b.readNewToken(ctx, req, path, data)
-		if err != nil {
-			return nil, err
+	if cluster, ok := data["default_cluster"].(string); ok {
+		if allowed := storedStringList(data, "allowed_clusters"); len(allowed) > 0 && !containsString(allowed, cluster) {
+			return logical.ErrorResponse("default_cluster '%s' is not in allowed_clusters", cluster)
 		}
 	}
 
-	outData := map[string]interface{}{
-		"token": *token,
+	for _, field := range []string{"audience", "issuer_url"} {
+		if value, ok := data[field]; ok {
+			if _, ok := value.(string); !ok {
+				return logical.ErrorResponse("'%s' must be a string", field)
+			}
+		}
 	}
-
-	// Generate the response
-	resp := &logical.Response{
-		Data: outData,
+	if issuer, ok := data["issuer_url"].(string); ok && issuer != "" {
+		if err := validateIssuerURL(issuer); err != nil {
+			return logical.ErrorResponse(err.Error())
+		}
 	}
 
-	return resp, nil
+	rawKeyFile, hasKeyFile := data["key-file"]
+	if !hasKeyFile {
+		return nil
+	}
+	keyFile, ok := rawKeyFile.(string)
+	if !ok {
+		return logical.ErrorResponse("'key-file' must be a string")
+	}
+	if err := validateKeyFile(keyFile); err != nil {
+		return logical.ErrorResponse(err.Error())
+	}
+	return nil
 }
 
-func (b *backend) initializeSnctlConfig() error {
-	b.Logger().Info("Initializing snctl config")
-	cmd := exec.Command(GetSnctl(), "config", "init")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		b.Logger().Error("Failed to run `snctl config init`", "error", err, "out", out)
+// validateKeyFile checks that keyFile is a JSON service account key with all
+// the fields needed to mint tokens.
+func validateKeyFile(keyFile string) error {
+	var key map[string]interface{}
+	if err := jsonutil.DecodeJSON([]byte(keyFile), &key); err != nil {
+		return errwrap.Wrapf("'key-file' is not valid JSON: {{err}}", err)
 	}
-	return err
+	var missing []string
+	for _, field := range []string{"type", "client_id", "client_secret", "issuer_url"} {
+		if value, ok := key[field].(string); !ok || value == "" {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("'key-file' is missing required fields: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
-// Initialize once if config dir does not exist.
-// snctl config init
-func (b *backend) requireSnctlConfig() error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return errwrap.Wrapf("No user HOME directory: {{err}}", err)
-	}
-	path := home + "/.snctl"
-	// TODO: Enter Mutex here
-	_, err = os.ReadDir(path)
-	if err != nil {
-		// Clear error and attempt to initialize
-		err = b.initializeSnctlConfig()
+// validateIssuerURL checks that issuer is an absolute https URL.
+func validateIssuerURL(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("'issuer_url' must be an https URL, got '%s'", issuer)
 	}
-	// Return remaining error, if any.
-	return err
+	return nil
 }
 
-func (b *backend) activateServiceAccount(secretKey string) error {
-	// Set a dummy oauth key. The dummy key is overwritten with per-request data.
-	// snctl auth activate-service-account --key-file ~/service-account-key.json
-	cmd := exec.Command(GetSnctl(), "auth", "activate-service-account", "--key-file", secretKey)
-	out, err := cmd.CombinedOutput()
+// withIssuerURL returns keyFile with its issuer_url replaced by issuer.
+func withIssuerURL(keyFile string, issuer string) (string, error) {
+	var key map[string]interface{}
+	if err := jsonutil.DecodeJSON([]byte(keyFile), &key); err != nil {
+		return "", errwrap.Wrapf("'key-file' is not valid JSON: {{err}}", err)
+	}
+	key["issuer_url"] = issuer
+	buf, err := json.Marshal(key)
 	if err != nil {
-		b.Logger().Error("Failed to run `snctl auth activate-service-account`", "error", err, "out", out)
+		return "", errwrap.Wrapf("json encoding failed: {{err}}", err)
 	}
-	return err
+	return string(buf), nil
 }
 
-func (b *backend) handleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	path := data.Get("path").(string)
-
-	if len(req.Data) == 0 {
-		b.Logger().Info("Clearing service account", "path", path)
-		// clear the key file
-		err := req.Storage.Delete(ctx, path)
-		if err != nil {
-			b.Logger().Error("Deleting from storage failed", "error", err)
-			return nil, errwrap.Wrapf("Deleting from storage failed: {{err}}", err)
+// readNewTokenFailover mints a token with each of keyFiles in turn, returning
+// the first token minted, or the last error if every key file fails.
+func (b *backend) readNewTokenFailover(ctx context.Context, conf *snctlConfig, data map[string]interface{}, keyFiles []string, binding string) (*string, error) {
+	var err error
+	for i, keyFile := range keyFiles {
+		// Each attempt sees only its own key file, so that activation does
+		// not fail over through key_files again.
+		attempt := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			attempt[k] = v
 		}
-		return nil, nil
-	}
-
-	stringTtl, hasTtl := req.Data["ttl"]
-	if hasTtl {
-		var ttl64 int64 = 0
-		var err error = nil
-		switch stringTtl.(type) {
-		case int:
-			ttl64 = int64(stringTtl.(int))
-		case int64:
-			ttl64 = stringTtl.(int64)
-		case json.Number:
-			ttl64, err = stringTtl.(json.Number).Int64()
-		case float64:
-			ttl64 = int64(stringTtl.(float64))
-		case string:
-			ttl32, err2 := strconv.Atoi(stringTtl.(string))
-			if err2 == nil {
-				ttl64 = int64(ttl32)
-			} else {
-				err = err2
-			}
-		default:
-			return nil, fmt.Errorf("ttl is not a scalar: {{type}}", reflect.TypeOf(stringTtl).Name())
+		delete(attempt, "key_files")
+		attempt["key-file"] = keyFile
+		var token *string
+		if token, err = b.readNewToken(ctx, conf, attempt, binding); err == nil {
+			b.Logger().Debug("Minted token", "key_index", i)
+			return token, nil
 		}
-		if err != nil {
-			return nil, errwrap.Wrapf("ttl is not an integer: {{err}}", err)
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(keyFiles) {
+			b.Logger().Warn("Minting with key file failed, trying the next", "key_index", i, "error", err)
 		}
-		req.Data["ttl"] = ttl64
 	}
+	return nil, err
+}
 
-	// Example key file
-	// {"type":"sn_service_account","client_id":"...","client_secret":"...","client_email":"...","issuer_url":"https://auth.streamnative.cloud"}
+func (b *backend) readNewToken(ctx context.Context, conf *snctlConfig, data map[string]interface{}, binding string) (*string, error) {
+	b.Logger().Debug("Reading new token", "mode", conf.Mode)
 
-	// JSON encode the data
-	buf, err := json.Marshal(req.Data)
+	// Wait for a slot no longer than a single snctl invocation may take.
+	waitCtx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+	release, err := b.limiter.acquire(waitCtx, conf.MaxConcurrentTokens)
+	cancel()
 	if err != nil {
-		return nil, errwrap.Wrapf("json encoding failed: {{err}}", err)
+		metrics.IncrCounter([]string{"streamnative", "get_token", "throttled"}, 1)
+		return nil, err
 	}
+	defer release()
 
-	b.Logger().Info("Saving service account")
-	// Store kv pairs in map at specified path
-	ent := &logical.StorageEntry{
-		Key:   path,
-		Value: buf,
+	var token string
+	switch conf.Mode {
+	case modeOAuth2:
+		token, err = b.readOAuth2Token(ctx, conf, data)
+	default:
+		token, err = b.readSnctlToken(ctx, conf, data, binding)
 	}
-	err = req.Storage.Put(ctx, ent)
 	if err != nil {
-		b.Logger().Error("Putting to storage failed", "error", err)
-		return nil, errwrap.Wrapf("Putting to storage failed: {{err}}", err)
+		return nil, err
 	}
 
-	return nil, nil
-}
+	if validate, _ := data["validate_token"].(bool); validate || data["expected_audience"] != nil {
+		audience, _ := data["expected_audience"].(string)
+		if err := validateToken(token, audience); err != nil {
+			b.Logger().Error("Minted token failed validation", "error", err)
+			return nil, errwrap.Wrapf("Minted token failed validation: {{err}}", err)
+		}
+	}
+
+	return &token, nil
+}
+
+// readSnctlToken mints a token by activating the service account with snctl
+// and running `snctl auth get-token`.
+func (b *backend) readSnctlToken(ctx context.Context, conf *snctlConfig, data map[string]interface{}, binding string) (string, error) {
+	var out []byte
+	err := b.withServiceAccount(ctx, conf, data, func(home string, _ string, keyFilePath string, stdin []byte) error {
+		args := expandGetTokenArgs(conf.GetTokenArgs, data["organization"].(string), data["cluster"].(string), keyFilePath)
+		if binding != "" {
+			args = append(args, conf.BindingFlag, binding)
+		}
+		if audience, _ := data["audience"].(string); audience != "" {
+			args = append(args, conf.AudienceFlag, audience)
+		}
+		if superuser, _ := data["superuser"].(bool); superuser {
+			args = append(args, conf.SuperuserFlag)
+		}
+		if subject, _ := data["subject"].(string); subject != "" {
+			args = append(args, conf.SubjectFlag, subject)
+		}
+		if scopes, _ := data["scopes"].([]string); len(scopes) > 0 {
+			args = append(args, conf.ScopeFlag, strings.Join(scopes, " "))
+		}
+		spanCtx, span := tracer.Start(ctx, "snctl.get_token", accountAttributes(data))
+		var err error
+		out, err = b.runSnctlWithRetry(spanCtx, conf, home, stdin, "auth get-token", args...)
+		endSpan(span, err)
+		if err != nil {
+			// The output may echo credentials, so only describe it.
+			b.Logger().Error("Failed to run `snctl auth get-token`", "error", err, "out_bytes", len(out), "out_fingerprint", fingerprint(out))
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	token := parseTokenOutput(out)
+	if conf.TokenJSONPath != "" {
+		if token, err = extractJSONToken(out, conf.TokenJSONPath); err != nil {
+			b.Logger().Error("Extracting token from `snctl auth get-token` output failed", "error", err)
+			return "", err
+		}
+		token = strings.TrimSpace(token)
+	}
+	if token == "" {
+		// snctl has been seen to exit successfully without printing a token
+		// while StreamNative is degraded, so this is reported as transient.
+		b.Logger().Error("`snctl auth get-token` succeeded without printing a token", "out_bytes", len(out))
+		return "", classify(ErrTransient, fmt.Errorf("snctl returned an empty token"))
+	}
+
+	return token, nil
+}
+
+// withServiceAccount activates the account's service account with snctl and
+// runs fn while it is active. Like token reads, it tries each of the account's
+// key files in turn until fn succeeds, returning the last error if none does.
+// fn receives the HOME to run snctl under, the key file, and the key file path
+// and stdin to pass it.
+func (b *backend) withServiceAccount(ctx context.Context, conf *snctlConfig, data map[string]interface{},
+	fn func(home string, keyFile string, keyFilePath string, stdin []byte) error) error {
+	keyFiles := accountKeyFiles(data)
+	if len(keyFiles) == 0 {
+		return classify(ErrInvalidRequest, fmt.Errorf("No 'key-file' set"))
+	}
+
+	var home string
+	var temporary bool
+	var err error
+	spanCtx, span := tracer.Start(ctx, "snctl.require_config", accountAttributes(data))
+	if conf.IsolateHome {
+		// A private HOME per request shares nothing with other requests, so
+		// no lock is needed.
+		home, err = b.temporarySnctlHome(spanCtx, conf)
+		temporary = true
+	} else {
+		// snctl keeps a single active service account in its config
+		// directory, so the config check, activation and fn must not
+		// interleave with another request's.
+		b.snctlLock.Lock()
+		defer b.snctlLock.Unlock()
+
+		home, temporary, err = b.requireSnctlConfig(spanCtx, conf)
+	}
+	endSpan(span, err)
+	if err != nil {
+		b.Logger().Error("Initializing snctl config failed", "error", err)
+		return err
+	}
+	if temporary {
+		defer liveTempFiles.remove(home)
+	}
+
+	for i, keyFile := range keyFiles {
+		if err = b.withKeyFile(ctx, conf, data, home, keyFile, fn); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(keyFiles) {
+			b.Logger().Warn("Running snctl with key file failed, trying the next", "key_index", i, "error", err)
+		}
+	}
+	return err
+}
+
+// withKeyFile activates the service account of keyFile under home and runs fn
+// while it is active. The caller holds snctlLock unless home is private.
+func (b *backend) withKeyFile(ctx context.Context, conf *snctlConfig, data map[string]interface{}, home string, keyFile string,
+	fn func(home string, keyFile string, keyFilePath string, stdin []byte) error) error {
+	keyFilePath, stdin := stdinKeyFile, []byte(keyFile)
+	if !conf.KeyFileStdin {
+		// TempFile is always created with 0600 permissions
+		tmpKeyFile, err := os.CreateTemp(conf.TempDir, "snio-key-*.json")
+		if err != nil {
+			b.Logger().Error("Failed to open temp file", "error", err)
+			return errwrap.Wrapf("Creating temporary key file failed: {{err}}", err)
+		}
+		liveTempFiles.add(tmpKeyFile.Name())
+		defer liveTempFiles.remove(tmpKeyFile.Name())
+		_, err = tmpKeyFile.Write([]byte(keyFile))
+		if closeErr := tmpKeyFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			b.Logger().Error("Failed to write temp key file", "error", err)
+			return errwrap.Wrapf("Writing temporary key file failed: {{err}}", err)
+		}
+		keyFilePath, stdin = tmpKeyFile.Name(), nil
+	}
+
+	spanCtx, span := tracer.Start(ctx, "snctl.activate_service_account", accountAttributes(data))
+	err := b.activateServiceAccount(spanCtx, conf, home, keyFilePath, stdin)
+	endSpan(span, err)
+	if err != nil {
+		b.Logger().Error("Activating service account failed", "error", err)
+		if !conf.IsolateHome {
+			// The shared config may have gone missing; check it again on
+			// the next request. snctlLock is held.
+			b.snctlReadyPath = ""
+		}
+		return err
+	}
+
+	return fn(home, keyFile, keyFilePath, stdin)
+}
+
+// legacyPathWarning is added to responses of the catch-all account path,
+// which roles/<name> and creds/<name> replace.
+const legacyPathWarning = "Accounts at arbitrary paths are deprecated; store them at roles/<name> and read tokens from creds/<name>"
+
+func (b *backend) handleRead(ctx context.Context, req *logical.Request, fieldData *framework.FieldData) (*logical.Response, error) {
+	path := fieldData.Get("path").(string)
+	if isInternalKey(path) {
+		return reservedPathResponse(path), nil
+	}
+	resp, err := b.issueToken(ctx, req, path, tokenRequestParams(req.Data))
+	if err != nil {
+		return readErrorResponse(req, err)
+	}
+	if resp != nil {
+		resp.AddWarning(legacyPathWarning)
+	}
+	return resp, nil
+}
+
+// issueToken returns a leased token for the account stored at path, serving
+// it from the cache when possible. reqParams holds the token request
+// parameters supplied by the caller.
+func (b *backend) issueToken(ctx context.Context, req *logical.Request, path string,
+	reqParams map[string]interface{}) (*logical.Response, error) {
+	start := time.Now()
+	outcome := &readOutcome{}
+	ctx, span := tracer.Start(ctx, "streamnative.issue_token")
+	resp, err := b.issueTokenResponse(ctx, req, path, reqParams, outcome)
+	endSpan(span, responseError(resp, err))
+	b.logReadOutcome(path, outcome, time.Since(start), resp, err)
+	return resp, err
+}
+
+// readOutcome collects what a token read did, for the summary logged when it
+// ends.
+type readOutcome struct {
+	org      string
+	cluster  string
+	cacheHit bool
+}
+
+// logReadOutcome logs a single line summarizing a token read. It carries no
+// secrets: neither the token nor the key file is logged.
+func (b *backend) logReadOutcome(path string, outcome *readOutcome, elapsed time.Duration, resp *logical.Response, err error) {
+	fields := []interface{}{
+		"path", path,
+		"org", outcome.org,
+		"cluster", outcome.cluster,
+		"duration_ms", elapsed.Milliseconds(),
+	}
+	switch {
+	case err != nil:
+		b.Logger().Error("Token read finished", append(fields, "outcome", "error", "error_class", errorClass(err), "error", err)...)
+	case resp != nil && resp.IsError():
+		b.Logger().Info("Token read finished", append(fields, "outcome", "error", "error_class", "invalid_request", "error", resp.Error())...)
+	case outcome.cacheHit:
+		b.Logger().Info("Token read finished", append(fields, "outcome", "cache_hit")...)
+	default:
+		b.Logger().Info("Token read finished", append(fields, "outcome", "success")...)
+	}
+}
+
+// issueTokenResponse implements issueToken within its tracing span.
+func (b *backend) issueTokenResponse(ctx context.Context, req *logical.Request, path string,
+	reqParams map[string]interface{}, outcome *readOutcome) (*logical.Response, error) {
+	conf := b.config()
+
+	// Decode the data
+	var data map[string]interface{}
+	ent, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Reading from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+
+	if ent == nil || ent.Value == nil {
+		resp := logical.ErrorResponse("No value at %v%v", req.MountPoint, path)
+		return resp, nil
+	}
+	secretBytes := ent.Value
+
+	if err := jsonutil.DecodeJSON(secretBytes, &data); err != nil {
+		b.Logger().Error("JSON decoding failed", "error", err)
+		return nil, errwrap.Wrapf("json decoding failed: {{err}}", err)
+	}
+
+	if version, err := storedAccountVersion(data); err != nil || version > accountVersion {
+		b.Logger().Error("Unsupported account format", "path", path, "version", data["version"])
+		return nil, fmt.Errorf("account at %s has unsupported format version %v", path, data["version"])
+	}
+	migrateDefaultCluster(data)
+	conf = conf.forAccount(data)
+
+	// Accounts without a key of their own use their organization's default,
+	// resolved by the stored organization before any override. Key files
+	// stay encrypted until a token has to be minted.
+	resolved, usesOrgKey, err := b.withStoredOrgAccountKey(ctx, req.Storage, data)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return missingKeyResponse(data), nil
+	}
+	var orgKeyPath string
+	if usesOrgKey {
+		orgKeyPath = orgAccountStorageKey(data["organization"].(string))
+	}
+	data = resolved
+
+	if invalidResponse := validateKeyData(data); invalidResponse != nil {
+		return invalidResponse, nil
+	}
+
+	params := resolveReadParams(data, reqParams)
+	if err := params.Validate(); err != nil {
+		return logical.ErrorResponse("Invalid read parameters: %v", err), nil
+	}
+
+	overrides := &framework.FieldData{Raw: reqParams, Schema: tokenRequestFields()}
+	if err := overrides.Validate(); err != nil {
+		return logical.ErrorResponse("Invalid read parameters: %v", err), nil
+	}
+	storedOrg := fmt.Sprint(data["organization"])
+	if cluster, ok := data["default_cluster"]; ok {
+		data["cluster"] = cluster
+	}
+	for _, field := range []string{"organization", "cluster"} {
+		if value, ok := overrides.GetOk(field); ok {
+			if strings.TrimSpace(value.(string)) == "" {
+				return logical.ErrorResponse("'%s' must be a non-empty string", field), nil
+			}
+			data[field] = value
+		}
+	}
+	if _, ok := data["cluster"].(string); !ok {
+		return logical.ErrorResponse("No 'cluster' given and the account has no default_cluster"), nil
+	}
+	// Only the stored organization may be used unless others are allowed.
+	if org := fmt.Sprint(data["organization"]); org != storedOrg && !containsString(storedStringList(data, "allowed_organizations"), org) {
+		return logical.ErrorResponse("Organization '%s' is not in this account's allowed_organizations", org), nil
+	}
+	for _, field := range []string{"audience", "issuer_url"} {
+		if value, ok := overrides.GetOk(field); ok && value.(string) != "" {
+			data[field] = value
+		}
+	}
+	issuer, _ := data["issuer_url"].(string)
+	if issuer != "" {
+		if err := validateIssuerURL(issuer); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	audience, _ := data["audience"].(string)
+	if audience != "" && conf.Mode != modeOAuth2 && conf.AudienceFlag == "" {
+		return logical.ErrorResponse("Custom audiences are not supported; set audience_flag on config/snctl to the get-token audience flag"), nil
+	}
+
+	outcome.org, outcome.cluster = fmt.Sprint(data["organization"]), fmt.Sprint(data["cluster"])
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("streamnative.organization", fmt.Sprint(data["organization"])),
+		attribute.String("streamnative.cluster", fmt.Sprint(data["cluster"])),
+	)
+
+	if allowed := storedStringList(data, "allowed_clusters"); len(allowed) > 0 && !containsString(allowed, fmt.Sprint(data["cluster"])) {
+		return logical.ErrorResponse("Cluster '%s' is not in this account's allowed_clusters", data["cluster"]), nil
+	}
+
+	binding := overrides.Get("binding").(string)
+	if binding != "" {
+		if conf.Mode == modeOAuth2 {
+			return logical.ErrorResponse("Token binding is not supported in oauth2 mode"), nil
+		}
+		if conf.BindingFlag == "" {
+			return logical.ErrorResponse("Token binding is not supported; set SNCTL_BINDING_FLAG to the issuer's binding flag"), nil
+		}
+		if err := validateBinding(binding); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	format := params.Get("format").(string)
+	if err := validateFormat(format); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	superuser := overrides.Get("superuser").(bool)
+	if superuser {
+		if allowed, _ := data["allow_superuser"].(bool); !allowed {
+			return logical.ErrorResponse("This account does not allow superuser tokens"), nil
+		}
+		if conf.Mode == modeOAuth2 {
+			return logical.ErrorResponse("Superuser tokens are not supported in oauth2 mode"), nil
+		}
+		if conf.SuperuserFlag == "" {
+			return logical.ErrorResponse("Superuser tokens are not supported; set superuser_flag on config/snctl to the get-token superuser flag"), nil
+		}
+	}
+	data["superuser"] = superuser
+
+	subject := overrides.Get("subject").(string)
+	if subject != "" {
+		if allowed := storedStringList(data, "allowed_subjects"); len(allowed) > 0 && !containsString(allowed, subject) {
+			return logical.ErrorResponse("Subject '%s' is not in this account's allowed_subjects", subject), nil
+		}
+		if conf.Mode == modeOAuth2 {
+			return logical.ErrorResponse("Subject tokens are not supported in oauth2 mode"), nil
+		}
+		if conf.SubjectFlag == "" {
+			return logical.ErrorResponse("Subject tokens are not supported; set subject_flag on config/snctl to the get-token subject flag"), nil
+		}
+	}
+	data["subject"] = subject
+
+	scopes := parseScopes(overrides.Get("scopes").(string))
+	if len(scopes) > 0 {
+		if allowed := storedStringList(data, "allowed_scopes"); len(allowed) > 0 {
+			for _, scope := range scopes {
+				if !containsString(allowed, scope) {
+					return logical.ErrorResponse("Scope '%s' is not in this account's allowed_scopes", scope), nil
+				}
+			}
+		}
+		if conf.Mode != modeOAuth2 && conf.ScopeFlag == "" {
+			return logical.ErrorResponse("Scoped tokens are not supported; set scope_flag on config/snctl to the get-token scope flag"), nil
+		}
+	}
+	data["scopes"] = scopes
+
+	metricLabels := []metrics.Label{{Name: "cluster", Value: fmt.Sprint(data["cluster"])}}
+
+	// Bound tokens belong to a single client and are never cached.
+	// The key files are those stored, so encrypted ones identify their key
+	// without being decrypted.
+	cacheKey := tokenCacheKey(fmt.Sprint(data["organization"]), fmt.Sprint(data["cluster"]), strings.Join(accountKeyFiles(data), "\n"), issuer, audience, strconv.FormatBool(superuser), subject, strings.Join(scopes, " "))
+	noCache := overrides.Get("no_cache").(bool)
+	var token *string
+	if binding == "" && !noCache {
+		maxAge, err := cacheTtl(data, params)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := b.cache.get(cacheKey, maxAge, conf.RefreshSkew); ok {
+			metrics.IncrCounterWithLabels([]string{"streamnative", "get_token", "cache_hit"}, 1, metricLabels)
+			token = &cached
+			outcome.cacheHit = true
+		}
+	}
+
+	if token == nil {
+		if window, frozen := conf.Freeze.active(time.Now()); frozen {
+			return logical.ErrorResponse("Token issuance is frozen during window '%s'", window), nil
+		}
+		if window, frozen := accountFreeze(data).active(time.Now()); frozen {
+			return logical.ErrorResponse("Token issuance for this account is frozen during window '%s'", window), nil
+		}
+	}
+
+	var keyFiles []string
+	if token == nil {
+		if err := decryptKeyFiles(ctx, conf, data); err != nil {
+			b.Logger().Error("Decrypting the account's key files failed", "path", path, "error", err)
+			return nil, err
+		}
+		keyFiles = accountKeyFiles(data)
+		if issuer != "" {
+			for i, keyFile := range keyFiles {
+				if keyFiles[i], err = withIssuerURL(keyFile, issuer); err != nil {
+					return logical.ErrorResponse(err.Error()), nil
+				}
+			}
+		}
+	}
+
+	var warnings []string
+	if token == nil && !b.breaker.allow(conf.BreakerThreshold, conf.BreakerCooldown) {
+		metrics.IncrCounter([]string{"streamnative", "breaker", "rejected"}, 1)
+		if conf.BreakerServeStale && binding == "" && !noCache {
+			if stale, ok := b.cache.stale(cacheKey); ok {
+				token = &stale
+				outcome.cacheHit = true
+			}
+		}
+		if token == nil {
+			return nil, logical.CodedError(http.StatusServiceUnavailable,
+				"token generation is temporarily disabled after repeated snctl failures")
+		}
+		warnings = append(warnings, "Serving a previously cached token while token generation is failing")
+	}
+
+	if token == nil {
+		start := time.Now()
+		token, err = b.readNewTokenFailover(ctx, conf, data, keyFiles, binding)
+		metrics.MeasureSinceWithLabels([]string{"streamnative", "get_token", "duration"}, start, metricLabels)
+		// A missing organization or cluster is the caller's mistake, not a
+		// sign that token generation is failing, but a probe it ended must
+		// still give way to the next.
+		if errors.Is(err, ErrInvalidRequest) {
+			b.breaker.release()
+		} else {
+			b.breaker.record(err, conf.BreakerThreshold)
+		}
+		if err != nil {
+			metrics.IncrCounterWithLabels([]string{"streamnative", "get_token", "error"}, 1, metricLabels)
+			return nil, mintErrorResponse(err)
+		}
+		metrics.IncrCounterWithLabels([]string{"streamnative", "get_token", "success"}, 1, metricLabels)
+		if binding == "" {
+			b.cache.put(path, cacheKey, *token)
+			if orgKeyPath != "" {
+				// Changing the organization default drops the token too.
+				b.cache.track(orgKeyPath, cacheKey)
+			}
+		}
+	}
+
+	// request_id correlates the token with this read in audit logs; neither
+	// it nor role reveals anything about the credentials.
+	requestID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errwrap.Wrapf("generating request_id failed: {{err}}", err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("streamnative.request_id", requestID))
+	outData := map[string]interface{}{
+		conf.TokenField: *token,
+		"role":          strings.TrimPrefix(path, rolePrefix),
+		"request_id":    requestID,
+	}
+	if binding != "" {
+		outData["binding"] = binding
+	}
+	var leaseTtl time.Duration
+	// tokenExp bounds renewals of the lease; zero when the token has no exp.
+	var tokenExp int64
+	claims, claimsErr := decodeJwtClaims(*token)
+	if claimsErr == nil {
+		if exp, ok := numericClaim(claims, "exp"); ok {
+			tokenExp = exp.Unix()
+			leaseTtl = time.Until(exp)
+			outData["expires_at"] = exp.UTC().Format(time.RFC3339)
+			outData["ttl_seconds"] = int64(leaseTtl.Seconds())
+			// End the lease a skew before the token, so clients renew
+			// before their token is rejected. Shorter tokens keep theirs.
+			if leaseTtl > conf.RefreshSkew {
+				leaseTtl -= conf.RefreshSkew
+			}
+		}
+		if iat, ok := numericClaim(claims, "iat"); ok {
+			outData["issued_at"] = iat.UTC().Format(time.RFC3339)
+		}
+	}
+	// data holds the token under fixed keys whatever token_field is, so that
+	// Vault Agent templates can rely on .Data.data.token across versions.
+	expiresAt, _ := outData["expires_at"].(string)
+	outData["data"] = map[string]interface{}{
+		"token":        *token,
+		"organization": fmt.Sprint(data["organization"]),
+		"cluster":      fmt.Sprint(data["cluster"]),
+		"expires_at":   expiresAt,
+	}
+	if overrides.Get("include_claims").(bool) {
+		if claimsErr != nil {
+			outData["claims"] = nil
+			warnings = append(warnings, fmt.Sprintf("Token claims could not be decoded: %v", claimsErr))
+		} else {
+			outData["claims"] = claims
+		}
+	}
+	if conf.AuditClaims && claimsErr == nil {
+		if sub, ok := claims["sub"].(string); ok {
+			outData["token_subject"] = sub
+		}
+		if aud := audienceClaim(claims); aud != nil {
+			outData["token_audience"] = aud
+		}
+	}
+
+	if format == formatPulsarClient {
+		if serviceURL, ok := pulsarServiceURL(conf, data); ok {
+			outData["pulsar_client"] = pulsarClientConfig(serviceURL, *token)
+		} else {
+			warnings = append(warnings, "The Pulsar client configuration was omitted because the service URL is unknown; set service_url_template on config/snctl")
+		}
+	}
+
+	// The lease ends with the token, within the mount's limits. Without an
+	// exp the mount default applies.
+	if sys := b.System(); sys != nil {
+		if leaseTtl <= 0 {
+			leaseTtl = sys.DefaultLeaseTTL()
+		}
+		if maxTtl := sys.MaxLeaseTTL(); maxTtl > 0 && leaseTtl > maxTtl {
+			leaseTtl = maxTtl
+			warnings = append(warnings, fmt.Sprintf("Lease TTL clamped to the mount's max_lease_ttl of %s; the token itself remains valid until expires_at", maxTtl))
+		}
+	}
+	outData["lease_ttl_seconds"] = int64(leaseTtl.Seconds())
+
+	// Generate the response
+	resp := b.Secret(secretTokenType).Response(outData, map[string]interface{}{
+		"path":      path,
+		"token_exp": tokenExp,
+	})
+	resp.Secret.TTL = leaseTtl
+	resp.Warnings = warnings
+
+	// Wrap the token by default; a wrap TTL requested by the client wins.
+	if conf.MinWrapTTL > 0 && (req.WrapInfo == nil || req.WrapInfo.TTL == 0) {
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{TTL: conf.MinWrapTTL}
+	}
+
+	return resp, nil
+}
+
+// validateJSONPath checks that path is a dotted path of non-empty segments,
+// such as "token" or "data.access_token".
+func validateJSONPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			return fmt.Errorf("'%s' has an empty path segment", path)
+		}
+	}
+	return nil
+}
+
+// extractJSONToken decodes out as JSON and returns the string at the dotted
+// path. Numeric segments index into arrays.
+func extractJSONToken(out []byte, path string) (string, error) {
+	var value interface{}
+	if err := jsonutil.DecodeJSON(out, &value); err != nil {
+		return "", errwrap.Wrapf("token output is not JSON: {{err}}", err)
+	}
+	resolved := ""
+	for _, segment := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return "", fmt.Errorf("token JSON path '%s' does not resolve: no field '%s' under '%s'", path, segment, resolved)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("token JSON path '%s' does not resolve: no index '%s' under '%s'", path, segment, resolved)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("token JSON path '%s' does not resolve: '%s' is not an object or array", path, resolved)
+		}
+		resolved = strings.TrimPrefix(resolved+"."+segment, ".")
+	}
+	token, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("token JSON path '%s' does not resolve to a string", path)
+	}
+	return token, nil
+}
+
+// validateBinding checks that binding is a base64url encoded SHA-256 digest,
+// the form of both a DPoP JWK thumbprint and an x5t#S256 certificate hash.
+func validateBinding(binding string) error {
+	digest, err := base64.RawURLEncoding.DecodeString(binding)
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("binding must be an unpadded base64url SHA-256 thumbprint")
+	}
+	return nil
+}
+
+func (b *backend) handleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if isInternalKey(path) {
+		return reservedPathResponse(path), nil
+	}
+
+	b.cache.invalidate(path)
+
+	operation := ""
+	if rawOperation, ok := req.Data[writeOperationField]; ok {
+		var err error
+		if operation, err = parseWriteOperation(rawOperation); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		delete(req.Data, writeOperationField)
+		if len(req.Data) == 0 {
+			return logical.ErrorResponse("operation cannot be given when clearing an account"), nil
+		}
+	}
+
+	if len(req.Data) == 0 {
+		b.Logger().Info("Clearing service account", "path", path)
+		// clear the key file
+		err := req.Storage.Delete(ctx, path)
+		if err != nil {
+			b.Logger().Error("Deleting from storage failed", "error", err)
+			return nil, errwrap.Wrapf("Deleting from storage failed: {{err}}", err)
+		}
+		return &logical.Response{Warnings: []string{legacyPathWarning}}, nil
+	}
+
+	allowUnknown := false
+	if rawAllow, ok := req.Data["allow_unknown_fields"]; ok {
+		var err error
+		if allowUnknown, err = parseutil.ParseBool(rawAllow); err != nil {
+			return logical.ErrorResponse("allow_unknown_fields is not a boolean: %v", err), nil
+		}
+		delete(req.Data, "allow_unknown_fields")
+	}
+	if !allowUnknown {
+		schema := accountSchema()
+		var unknown []string
+		for field := range req.Data {
+			if _, ok := schema[field]; !ok {
+				unknown = append(unknown, field)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return logical.ErrorResponse("Unknown fields: %s. Set allow_unknown_fields=true to store them anyway",
+				strings.Join(unknown, ", ")), nil
+		}
+	}
+
+	resp, err := b.storeAccountChecked(ctx, req.Storage, path, operation, req.Data)
+	if err != nil {
+		return resp, err
+	}
+	if resp == nil {
+		resp = &logical.Response{}
+	}
+	resp.AddWarning(legacyPathWarning)
+	return resp, nil
+}
+
+// writeOperationField is the write parameter restricting a write to creating
+// a new account or to updating an existing one.
+const writeOperationField = "operation"
+
+const (
+	writeCreateOnly = "create_only"
+	writeUpdateOnly = "update_only"
+)
+
+// parseWriteOperation checks the operation parameter of a write.
+func parseWriteOperation(raw interface{}) (string, error) {
+	operation, ok := raw.(string)
+	if !ok || (operation != "" && operation != writeCreateOnly && operation != writeUpdateOnly) {
+		return "", fmt.Errorf("operation must be '%s' or '%s'", writeCreateOnly, writeUpdateOnly)
+	}
+	return operation, nil
+}
+
+// storeAccountChecked stores account at path as storeAccount does, after
+// checking operation against whether an account is already stored there. The
+// check and the write hold the path's write lock, so of two concurrent
+// create_only writes only one succeeds.
+func (b *backend) storeAccountChecked(ctx context.Context, s logical.Storage, path string, operation string, account map[string]interface{}) (*logical.Response, error) {
+	lock := locksutil.LockForKey(b.writeLocks, path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if operation != "" {
+		ent, err := s.Get(ctx, path)
+		if err != nil {
+			return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+		}
+		if operation == writeCreateOnly && ent != nil {
+			return nil, logical.CodedError(http.StatusConflict,
+				fmt.Sprintf("an account is already stored at '%s'; operation=create_only does not replace it", path))
+		}
+		if operation == writeUpdateOnly && ent == nil {
+			return nil, logical.CodedError(http.StatusConflict,
+				fmt.Sprintf("no account is stored at '%s'; operation=update_only does not create one", path))
+		}
+	}
+	return b.storeAccount(ctx, s, path, account)
+}
+
+// lastRotation returns when the key material of the account at path was last
+// replaced: unchanged from the stored account keeps its time, otherwise now.
+func (b *backend) lastRotation(ctx context.Context, s logical.Storage, path string, keyFiles []string) (string, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	ent, err := s.Get(ctx, path)
+	if err != nil {
+		return "", errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	if ent == nil {
+		return now, nil
+	}
+	var prev map[string]interface{}
+	if err := jsonutil.DecodeJSON(ent.Value, &prev); err != nil {
+		return now, nil
+	}
+	if err := decryptKeyFiles(ctx, b.config(), prev); err != nil {
+		return now, nil
+	}
+	last, ok := prev["last_rotation"].(string)
+	if !ok || strings.Join(accountKeyFiles(prev), "\n") != strings.Join(keyFiles, "\n") {
+		return now, nil
+	}
+	return last, nil
+}
+
+// validateAccess checks that the account's issuer is reachable, then mints a
+// throwaway token to confirm that its organization and cluster exist and its
+// key can access them.
+func (b *backend) validateAccess(ctx context.Context, conf *snctlConfig, account map[string]interface{}) *logical.Response {
+	for _, issuer := range accountIssuers(account) {
+		if err := checkIssuerReachable(ctx, conf, issuer); err != nil {
+			return logical.ErrorResponse(err.Error())
+		}
+	}
+	// Minting overwrites key-file, so work on a copy.
+	data := make(map[string]interface{}, len(account))
+	for k, v := range account {
+		data[k] = v
+	}
+	// Without a default cluster, check the first allowed one.
+	cluster, _ := account["default_cluster"].(string)
+	if allowed := storedStringList(account, "allowed_clusters"); cluster == "" && len(allowed) > 0 {
+		cluster = allowed[0]
+	}
+	if cluster == "" {
+		return logical.ErrorResponse("Validating the account on write requires default_cluster or allowed_clusters")
+	}
+	data["cluster"] = cluster
+	if _, err := b.readNewTokenFailover(ctx, conf, data, accountKeyFiles(account), ""); err != nil {
+		return logical.ErrorResponse("Organization '%s' or cluster '%s' was not found or is not accessible with this key: %v",
+			account["organization"], cluster, err)
+	}
+	return nil
+}
+
+// storeAccount normalizes and validates an account, then stores it at path.
+func (b *backend) storeAccount(ctx context.Context, s logical.Storage, path string, account map[string]interface{}) (*logical.Response, error) {
+	var warnings []string
+	if cluster, hasCluster := account["cluster"]; hasCluster {
+		if defaultCluster, hasDefault := account["default_cluster"]; hasDefault && defaultCluster != cluster {
+			return logical.ErrorResponse("'cluster' is deprecated and differs from 'default_cluster'; set only default_cluster"), nil
+		}
+		migrateDefaultCluster(account)
+		warnings = append(warnings, "'cluster' is deprecated and was stored as default_cluster; set default_cluster instead")
+	}
+
+	if rawKeyFiles, hasKeyFiles := account["key_files"]; hasKeyFiles {
+		_, hasKeyFile := account["key-file"]
+		_, hasEncoded := account["key_file_base64"]
+		if hasKeyFile || hasEncoded {
+			return logical.ErrorResponse("'key_files' cannot be combined with 'key-file' or 'key_file_base64'"), nil
+		}
+		keyFiles, err := parseKeyFiles(rawKeyFiles)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		account["key_files"] = keyFiles
+	}
+
+	if rawEncoded, hasEncoded := account["key_file_base64"]; hasEncoded {
+		if _, hasKeyFile := account["key-file"]; hasKeyFile {
+			return logical.ErrorResponse("'key-file' and 'key_file_base64' are mutually exclusive"), nil
+		}
+		encoded, ok := rawEncoded.(string)
+		if !ok {
+			return logical.ErrorResponse("'key_file_base64' must be a string"), nil
+		}
+		keyFile, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return logical.ErrorResponse("'key_file_base64' is not valid base64: %v", err), nil
+		}
+		delete(account, "key_file_base64")
+		account["key-file"] = string(keyFile)
+	}
+
+	if rawTtl, hasTtl := account["ttl"]; hasTtl {
+		ttl, err := parseutil.ParseDurationSecond(rawTtl)
+		if err != nil || ttl < 0 {
+			return logical.ErrorResponse("ttl is not a valid duration"), nil
+		}
+		account["ttl"] = int64(ttl / time.Second)
+	}
+
+	if rawValidate, hasValidate := account["validate_token"]; hasValidate {
+		validate, err := parseutil.ParseBool(rawValidate)
+		if err != nil {
+			return logical.ErrorResponse("validate_token is not a boolean: %v", err), nil
+		}
+		account["validate_token"] = validate
+	}
+	if rawPeriod, hasPeriod := account["rotation_period"]; hasPeriod {
+		period, err := parseRotationPeriod(rawPeriod)
+		if err != nil || period < 0 {
+			return logical.ErrorResponse("rotation_period is not a valid duration"), nil
+		}
+		account["rotation_period"] = period
+	}
+	if rawTimeout, hasTimeout := account["request_timeout"]; hasTimeout {
+		timeout, err := parseutil.ParseDurationSecond(rawTimeout)
+		if err != nil || timeout < 0 {
+			return logical.ErrorResponse("request_timeout is not a valid duration"), nil
+		}
+		account["request_timeout"] = int64(timeout / time.Second)
+	}
+	if rawTTL, hasTTL := account["apikey_ttl"]; hasTTL {
+		ttl, err := parseutil.ParseDurationSecond(rawTTL)
+		if err != nil || ttl < 0 {
+			return logical.ErrorResponse("apikey_ttl is not a valid duration"), nil
+		}
+		account["apikey_ttl"] = int64(ttl / time.Second)
+	}
+
+	for _, field := range []string{"allow_superuser", "allow_batch"} {
+		if rawAllow, hasAllow := account[field]; hasAllow {
+			allow, err := parseutil.ParseBool(rawAllow)
+			if err != nil {
+				return logical.ErrorResponse("%s is not a boolean: %v", field, err), nil
+			}
+			account[field] = allow
+		}
+	}
+	if audience, hasAudience := account["expected_audience"]; hasAudience {
+		if _, ok := audience.(string); !ok {
+			return logical.ErrorResponse("expected_audience is not a string"), nil
+		}
+	}
+
+	if rawWindows, hasWindows := account["freeze_windows"]; hasWindows {
+		windows, err := splitFreezeWindows(rawWindows)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		timezone, _ := account["freeze_timezone"].(string)
+		if _, err := parseFreezeSchedule(windows, timezone); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		account["freeze_windows"] = windows
+	} else if _, hasTimezone := account["freeze_timezone"]; hasTimezone {
+		return logical.ErrorResponse("freeze_timezone requires freeze_windows"), nil
+	}
+
+	if rawClusters, hasClusters := account["allowed_clusters"]; hasClusters {
+		clusters, err := parseStringList(rawClusters)
+		if err != nil {
+			return logical.ErrorResponse("allowed_clusters: %v", err), nil
+		}
+		account["allowed_clusters"] = clusters
+	}
+	if rawOrgs, hasOrgs := account["allowed_organizations"]; hasOrgs {
+		orgs, err := parseStringList(rawOrgs)
+		if err != nil {
+			return logical.ErrorResponse("allowed_organizations: %v", err), nil
+		}
+		account["allowed_organizations"] = orgs
+	}
+	if rawSubjects, hasSubjects := account["allowed_subjects"]; hasSubjects {
+		subjects, err := parseStringList(rawSubjects)
+		if err != nil {
+			return logical.ErrorResponse("allowed_subjects: %v", err), nil
+		}
+		account["allowed_subjects"] = subjects
+	}
+	if rawScopes, hasScopes := account["allowed_scopes"]; hasScopes {
+		scopes, err := parseStringList(rawScopes)
+		if err != nil {
+			return logical.ErrorResponse("allowed_scopes: %v", err), nil
+		}
+		account["allowed_scopes"] = parseScopes(strings.Join(scopes, " "))
+	}
+
+	if rawDefaults, hasDefaults := account["default_params"]; hasDefaults {
+		defaults, err := parseDefaultParams(rawDefaults)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		account["default_params"] = defaults
+	}
+
+	// Example key file
+	// {"type":"sn_service_account","client_id":"...","client_secret":"...","client_email":"...","issuer_url":"https://auth.streamnative.cloud"}
+	if invalidResponse := validateWriteData(account); invalidResponse != nil {
+		return invalidResponse, nil
+	}
+
+	if conf := b.config(); conf.ValidateOnWrite {
+		resolved, _, err := b.withOrgAccountKey(ctx, s, account)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == nil {
+			return missingKeyResponse(account), nil
+		}
+		if invalidResponse := b.validateAccess(ctx, conf, resolved); invalidResponse != nil {
+			return invalidResponse, nil
+		}
+	}
+
+	lastRotation, err := b.lastRotation(ctx, s, path, accountKeyFiles(account))
+	if err != nil {
+		return nil, err
+	}
+	account["last_rotation"] = lastRotation
+	account["version"] = accountVersion
+	if err := encryptKeyFiles(ctx, b.config(), account); err != nil {
+		return nil, err
+	}
+
+	// JSON encode the data
+	buf, err := json.Marshal(account)
+	if err != nil {
+		return nil, errwrap.Wrapf("json encoding failed: {{err}}", err)
+	}
+	if limit := b.config().MaxEntryBytes; limit > 0 && len(buf) > limit {
+		return logical.ErrorResponse("The account is %d bytes when stored, more than max_entry_bytes of %d", len(buf), limit), nil
+	}
+
+	b.Logger().Info("Saving service account")
+	// Store kv pairs in map at specified path
+	err = b.putAccount(ctx, s, path, buf)
+	if err != nil {
+		b.Logger().Error("Putting to storage failed", "error", err)
+		return nil, errwrap.Wrapf("Putting to storage failed: {{err}}", err)
+	}
+
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+	return nil, nil
+}
+
+// handleList returns the names stored under a prefix. Only the names are
+// returned, never the stored key material.
+func (b *backend) handleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix := data.Get("path").(string)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	keys, err := req.Storage.List(ctx, prefix)
+	if err != nil {
+		b.Logger().Error("Listing storage failed", "error", err)
+		return nil, errwrap.Wrapf("Listing storage failed: {{err}}", err)
+	}
+
+	accounts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if isInternalKey(prefix+key) || prefix+key == internalConfigDir {
+			continue
+		}
+		accounts = append(accounts, key)
+	}
+	return logical.ListResponse(accounts), nil
+}
 
 func (b *backend) handleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	path := data.Get("path").(string)
+	if isInternalKey(path) {
+		return reservedPathResponse(path), nil
+	}
+
+	b.cache.invalidate(path)
 
 	// Remove entry for specified path
 	err := req.Storage.Delete(ctx, path)
@@ -401,4 +1960,22 @@ func (b *backend) handleDelete(ctx context.Context, req *logical.Request, data *
 
 const helpText = `
 The StreamNative backend generates Pulsar JWTs on-demand using the StreamNative API.
+
+The backend emits these metrics, labelled with the cluster:
+
+  streamnative.get_token.duration   time taken to mint a new token
+  streamnative.get_token.success    tokens minted
+  streamnative.get_token.error      failed token generations
+  streamnative.get_token.cache_hit  tokens served from the cache
+
+Tokens, key files and the output of snctl commands handling them are never
+logged; log lines carry only their length and a SHA-256 fingerprint. snctl's
+standard error is kept apart from its output: failures report a trimmed
+excerpt of it with tokens and client secrets masked.
+
+snctl stores the active service account in a single config directory, so
+token generation is serialized: each mount runs at most one snctl
+activate/get-token sequence at a time. Cached tokens are served without
+waiting. With isolate_home set in config/snctl, each token generation
+runs snctl under its own temporary HOME instead and is not serialized.
 `
@@ -0,0 +1,1253 @@
+package streamnative
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testKeyFile is a service account key file whose secret tests look for in
+// logs and storage.
+const testKeyFile = `{"type":"sn_service_account","client_id":"test-client","client_secret":"test-client-secret","client_email":"sa@test-org.auth.streamnative.cloud","issuer_url":"https://auth.streamnative.cloud"}`
+
+// testKeyFileFor returns a key file for another client, so that tests can
+// tell accounts apart by their key.
+func testKeyFileFor(clientID string) string {
+	return strings.ReplaceAll(testKeyFile, "test-client", clientID)
+}
+
+// testJWT returns an unsigned compact JWT with the claims, expiring in an
+// hour unless the claims set exp.
+func testJWT(t testing.TB, claims map[string]interface{}) string {
+	t.Helper()
+	payload := map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(buf) + "." + enc.EncodeToString([]byte("sig"))
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers, for capturing
+// logs.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testBackend is an initialized backend running a fake snctl, with its
+// storage and captured logs.
+type testBackend struct {
+	*backend
+	storage logical.Storage
+	snctl   *snctltest.Fake
+	logs    *syncBuffer
+}
+
+// getTestBackend returns a backend whose snctl config directory and temporary
+// directory are private to the test, and whose get-token prints a JWT.
+func getTestBackend(t testing.TB) *testBackend {
+	t.Helper()
+	t.Setenv("SNCTL_PATH", "snctl")
+	t.Setenv("SNCTL_CONFIG_DIR", t.TempDir())
+	t.Setenv("SNCTL_TEMP_DIR", t.TempDir())
+
+	logs := &syncBuffer{}
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{
+		StorageView: storage,
+		System:      logical.TestSystemView(),
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Output: logs,
+			Level:  hclog.Trace,
+		}),
+	}
+	lb, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := lb.(*backend)
+	fake := snctltest.New()
+	fake.On("auth get-token", snctltest.Response{Stdout: testJWT(t, nil)})
+	b.runner = fake.Run
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Cleanup(context.Background()) })
+	return &testBackend{backend: b, storage: storage, snctl: fake, logs: logs}
+}
+
+// setEnv sets a plugin environment variable and reloads the config from it.
+func (tb *testBackend) setEnv(t *testing.T, name string, value string) {
+	t.Helper()
+	t.Setenv(name, value)
+	if err := tb.loadConfig(context.Background(), tb.storage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// request runs an operation the way Vault routes it: an update of a path
+// that does not exist yet becomes a create.
+func (tb *testBackend) request(t *testing.T, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req := &logical.Request{
+		Operation:  op,
+		Path:       path,
+		Data:       data,
+		Storage:    tb.storage,
+		MountPoint: "snio/",
+	}
+	if op == logical.UpdateOperation {
+		found, exists, err := tb.HandleExistenceCheck(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found && !exists {
+			req.Operation = logical.CreateOperation
+		}
+	}
+	return tb.HandleRequest(context.Background(), req)
+}
+
+// mustRequest runs an operation that must succeed.
+func (tb *testBackend) mustRequest(t *testing.T, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := tb.request(t, op, path, data)
+	if err != nil {
+		t.Fatalf("%s %s: %v", op, path, err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("%s %s: %v", op, path, resp.Error())
+	}
+	return resp
+}
+
+// writeRole stores a role for organization test-org and cluster
+// test-cluster, with extra fields.
+func (tb *testBackend) writeRole(t *testing.T, name string, extra map[string]interface{}) {
+	t.Helper()
+	data := map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "roles/"+name, data)
+}
+
+// errorText returns the failure of a request, whether returned as an error
+// or as an error response.
+func errorText(resp *logical.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if resp != nil && resp.IsError() {
+		return resp.Error().Error()
+	}
+	if _, data := rawResponse(resp); data != nil {
+		msg, _ := data["error"].(string)
+		return msg
+	}
+	return ""
+}
+
+// rawResponse decodes a response built with logical.RespondWithStatusCode,
+// returning its status and data. data is nil if resp is not such a response.
+func rawResponse(resp *logical.Response) (int, map[string]interface{}) {
+	if resp == nil {
+		return 0, nil
+	}
+	body, ok := resp.Data[logical.HTTPRawBody].(string)
+	if !ok {
+		return 0, nil
+	}
+	var decoded struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		return 0, nil
+	}
+	status, _ := resp.Data[logical.HTTPStatusCode].(int)
+	return status, decoded.Data
+}
+
+func TestDefaultParamsFormat(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_SERVICE_URL_TEMPLATE", "pulsar+ssl://{cluster}.{organization}.example.com:6651")
+	if err := tb.loadConfig(context.Background(), tb.storage); err != nil {
+		t.Fatal(err)
+	}
+	tb.writeRole(t, "defaults", map[string]interface{}{
+		"default_params": map[string]interface{}{"format": "pulsar_client"},
+	})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/defaults", nil)
+	if _, ok := resp.Data["pulsar_client"]; !ok {
+		t.Fatalf("the default format was not applied: %v", resp.Data)
+	}
+
+	resp = tb.mustRequest(t, logical.ReadOperation, "creds/defaults", map[string]interface{}{"format": ""})
+	if _, ok := resp.Data["pulsar_client"]; ok {
+		t.Fatalf("the read's format did not override the default: %v", resp.Data)
+	}
+}
+
+func TestDefaultParamsValidated(t *testing.T) {
+	tb := getTestBackend(t)
+	resp, err := tb.request(t, logical.UpdateOperation, "roles/invalid", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+		"default_params":  map[string]interface{}{"format": "xml"},
+	})
+	if msg := errorText(resp, err); !strings.Contains(msg, "format") {
+		t.Fatalf("expected an invalid format to be rejected, got %q", msg)
+	}
+}
+
+func TestInvalidTokenNotCached(t *testing.T) {
+	for name, token := range map[string]string{
+		"malformed": "not-a-jwt",
+		"expired":   testJWT(t, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			tb.writeRole(t, "validated", map[string]interface{}{"validate_token": true})
+			tb.snctl.On("auth get-token", snctltest.Response{Stdout: token})
+			resp, err := tb.request(t, logical.ReadOperation, "creds/validated", nil)
+			if msg := errorText(resp, err); !strings.Contains(msg, "failed validation") {
+				t.Fatalf("expected a validation failure, got %q", msg)
+			}
+			if resp != nil && resp.Data["token"] == token {
+				t.Fatal("the invalid token was returned")
+			}
+
+			before := len(tb.snctl.Calls("auth get-token"))
+			tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, nil)})
+			tb.mustRequest(t, logical.ReadOperation, "creds/validated", nil)
+			if after := len(tb.snctl.Calls("auth get-token")); after != before+1 {
+				t.Fatal("the invalid token was served from the cache")
+			}
+		})
+	}
+}
+
+func TestBindingForwarded(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_BINDING_FLAG", "--cnf-jkt")
+	tb.writeRole(t, "bound", nil)
+	thumbprint := base64.RawURLEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/bound", map[string]interface{}{"binding": thumbprint})
+	if resp.Data["binding"] != thumbprint {
+		t.Fatalf("expected the binding to be echoed, got %v", resp.Data["binding"])
+	}
+	calls := tb.snctl.Calls("auth get-token")
+	if len(calls) != 1 {
+		t.Fatalf("expected one get-token, got %d", len(calls))
+	}
+	if args := strings.Join(calls[0].Args, " "); !strings.Contains(args, "--cnf-jkt "+thumbprint) {
+		t.Fatalf("the binding was not forwarded: %s", args)
+	}
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/bound", map[string]interface{}{"binding": "not-a-thumbprint"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "binding must be") {
+		t.Fatalf("expected an invalid binding to be rejected, got %q", msg)
+	}
+}
+
+func TestAuditClaims(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{
+		"sub": "sa@test-org.auth.streamnative.cloud",
+		"aud": "urn:sn:pulsar:test-org:test-cluster",
+	})})
+	tb.writeRole(t, "audited", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/audited", map[string]interface{}{"no_cache": true})
+	if _, ok := resp.Data["token_subject"]; ok {
+		t.Fatalf("claims were added without SNCTL_AUDIT_CLAIMS: %v", resp.Data)
+	}
+
+	tb.setEnv(t, "SNCTL_AUDIT_CLAIMS", "true")
+	resp = tb.mustRequest(t, logical.ReadOperation, "creds/audited", map[string]interface{}{"no_cache": true})
+	if resp.Data["token_subject"] != "sa@test-org.auth.streamnative.cloud" {
+		t.Fatalf("unexpected token_subject %v", resp.Data["token_subject"])
+	}
+	if aud := resp.Data["token_audience"]; !reflect.DeepEqual(aud, []string{"urn:sn:pulsar:test-org:test-cluster"}) {
+		t.Fatalf("unexpected token_audience %v", aud)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "traced", nil)
+
+	first := tb.mustRequest(t, logical.ReadOperation, "creds/traced", nil)
+	second := tb.mustRequest(t, logical.ReadOperation, "creds/traced", nil)
+	for _, resp := range []*logical.Response{first, second} {
+		if resp.Data["role"] != "traced" {
+			t.Fatalf("expected the role name, got %v", resp.Data["role"])
+		}
+		id, _ := resp.Data["request_id"].(string)
+		if _, err := uuid.ParseUUID(id); err != nil {
+			t.Fatalf("request_id %q is not a UUID: %v", id, err)
+		}
+	}
+	if first.Data["request_id"] == second.Data["request_id"] {
+		t.Fatal("expected each read, even a cached one, to have its own request_id")
+	}
+}
+
+func TestReadOutcomeLogged(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "logged", nil)
+	summaries := func() []string {
+		var lines []string
+		for _, line := range strings.Split(tb.logs.String(), "\n") {
+			if strings.Contains(line, "Token read finished") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	token := tb.mustRequest(t, logical.ReadOperation, "creds/logged", nil).Data["token"].(string)
+	tb.mustRequest(t, logical.ReadOperation, "creds/logged", nil)
+	lines := summaries()
+	if len(lines) != 2 {
+		t.Fatalf("expected one summary per read, got %d:\n%s", len(lines), tb.logs.String())
+	}
+	for _, field := range []string{"[INFO]", "path=roles/logged", "org=test-org", "cluster=test-cluster", "duration_ms=", "outcome=success"} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("expected %q in the summary %q", field, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], "outcome=cache_hit") {
+		t.Errorf("expected the second read to be a cache hit: %q", lines[1])
+	}
+
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "error: 401 Unauthorized", ExitCode: 1})
+	tb.request(t, logical.ReadOperation, "creds/logged", map[string]interface{}{"no_cache": true})
+	lines = summaries()
+	if last := lines[len(lines)-1]; !strings.Contains(last, "[ERROR]") || !strings.Contains(last, "outcome=error") || !strings.Contains(last, "error_class=invalid_credential") {
+		t.Errorf("expected a classified error summary, got %q", last)
+	}
+	if logs := tb.logs.String(); strings.Contains(logs, token) || strings.Contains(logs, "test-client-secret") {
+		t.Error("the token or key file was logged")
+	}
+}
+
+func TestTemplateData(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "templated", nil)
+	// The template documented in the README.
+	tmpl := template.Must(template.New("agent").Parse(
+		"PULSAR_AUTH_TOKEN={{ .Data.data.token }}\nPULSAR_TOKEN_EXPIRES_AT={{ .Data.data.expires_at }}\n"))
+	render := func(resp *logical.Response) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, map[string]interface{}{"Data": resp.Data}); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	for _, field := range []string{"token", "access_token"} {
+		tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"token_field": field})
+		resp := tb.mustRequest(t, logical.ReadOperation, "creds/templated", nil)
+		data, _ := resp.Data["data"].(map[string]interface{})
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, []string{"cluster", "expires_at", "organization", "token"}) {
+			t.Fatalf("token_field %s: unexpected data keys %v", field, keys)
+		}
+		want := fmt.Sprintf("PULSAR_AUTH_TOKEN=%s\nPULSAR_TOKEN_EXPIRES_AT=%s\n", resp.Data[field], resp.Data["expires_at"])
+		if got := render(resp); got != want || resp.Data["expires_at"] == nil {
+			t.Fatalf("token_field %s: the template rendered %q, expected %q", field, got, want)
+		}
+	}
+
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{"exp": nil})})
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/templated", map[string]interface{}{"no_cache": true})
+	if data := resp.Data["data"].(map[string]interface{}); data["expires_at"] != "" {
+		t.Fatalf("expected an empty expires_at without exp, got %v", data["expires_at"])
+	}
+}
+
+func TestExtractJSONToken(t *testing.T) {
+	for _, tc := range []struct {
+		out  string
+		path string
+	}{
+		{`{"token": "t"}`, "token"},
+		{`{"accessToken": "t", "expiresIn": 3600}`, "accessToken"},
+		{`{"data": {"access_token": "t"}}`, "data.access_token"},
+		{`{"tokens": [{"value": "other"}, {"value": "t"}]}`, "tokens.1.value"},
+	} {
+		token, err := extractJSONToken([]byte(tc.out), tc.path)
+		if err != nil || token != "t" {
+			t.Errorf("%s in %s: got %q, %v", tc.path, tc.out, token, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		out  string
+		path string
+		err  string
+	}{
+		{`{"token": "t"}`, "access_token", "no field 'access_token'"},
+		{`{"data": {"token": "t"}}`, "data.access_token", "under 'data'"},
+		{`{"tokens": ["t"]}`, "tokens.1", "no index '1'"},
+		{`{"token": 42}`, "token", "does not resolve to a string"},
+		{`token`, "token", "not JSON"},
+	} {
+		if _, err := extractJSONToken([]byte(tc.out), tc.path); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s in %s: expected an error containing %q, got %v", tc.path, tc.out, tc.err, err)
+		}
+	}
+}
+
+func TestTokenJSONPathRead(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_TOKEN_JSON_PATH", "data.accessToken")
+	tb.writeRole(t, "json", nil)
+	token := testJWT(t, nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: `{"data": {"accessToken": "` + token + `"}}`})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/json", nil)
+	if resp.Data["token"] != token {
+		t.Fatalf("expected the token at data.accessToken, got %v", resp.Data["token"])
+	}
+}
+
+func TestTokenJSONPathConfig(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_TOKEN_JSON_PATH", "token")
+	for _, tc := range []struct {
+		path   string
+		sample interface{}
+		err    string
+	}{
+		{"data.access_token", nil, "token_json_path requires token_json_sample"},
+		{"data..token", `{"data": {"token": "t"}}`, "has an empty path segment"},
+		{"data.access_token", `{"data": {"accessToken": "t"}}`, "no field 'access_token' under 'data'"},
+		{"data.access_token", `{"data": {"access_token": 42}}`, "does not resolve to a string"},
+		{"data.access_token", `eyJ`, "not JSON"},
+	} {
+		data := map[string]interface{}{"token_json_path": tc.path}
+		if tc.sample != nil {
+			data["token_json_sample"] = tc.sample
+		}
+		resp, err := tb.request(t, logical.UpdateOperation, "config/snctl", data)
+		if msg := errorText(resp, err); !strings.Contains(msg, tc.err) {
+			t.Errorf("%s in %v: expected an error containing %q, got %q", tc.path, tc.sample, tc.err, msg)
+		}
+	}
+	if path := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil).Data["token_json_path"]; path != "token" {
+		t.Fatalf("expected rejected writes to keep the environment's path, got %v", path)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"token_json_path":   "data.tokens.0.access_token",
+		"token_json_sample": `{"data": {"tokens": [{"access_token": "eyJ"}]}}`,
+	})
+	if path := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil).Data["token_json_path"]; path != "data.tokens.0.access_token" {
+		t.Fatalf("expected the stored path, got %v", path)
+	}
+	tb.writeRole(t, "json", nil)
+	token := testJWT(t, nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: `{"data": {"tokens": [{"access_token": "` + token + `"}]}}`})
+	if resp := tb.mustRequest(t, logical.ReadOperation, "creds/json", nil); resp.Data["token"] != token {
+		t.Fatalf("expected the token at the stored path, got %v", resp.Data["token"])
+	}
+	if stored := tb.storedAccount(t, configStorageKey); stored["token_json_sample"] != nil {
+		t.Fatal("the sample was stored")
+	}
+}
+
+func TestReadUnwrittenPath(t *testing.T) {
+	tb := getTestBackend(t)
+	resp, err := tb.request(t, logical.ReadOperation, "never/written", nil)
+	if err != nil {
+		t.Fatalf("expected an error response, got %v", err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "No value at snio/never/written") {
+		t.Fatalf("expected 'No value at snio/never/written', got %v", resp)
+	}
+}
+
+func TestLegacyPathWarning(t *testing.T) {
+	tb := getTestBackend(t)
+	data := map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	}
+	hasWarning := func(resp *logical.Response) bool {
+		if resp == nil {
+			return false
+		}
+		for _, w := range resp.Warnings {
+			if w == legacyPathWarning {
+				return true
+			}
+		}
+		return false
+	}
+
+	if resp := tb.mustRequest(t, logical.UpdateOperation, "legacy", data); !hasWarning(resp) {
+		t.Fatalf("expected a legacy write to warn, got %v", resp.Warnings)
+	}
+	resp := tb.mustRequest(t, logical.ReadOperation, "legacy", nil)
+	if !hasWarning(resp) {
+		t.Fatalf("expected a legacy read to warn, got %v", resp.Warnings)
+	}
+	if resp.Data["token"] == nil {
+		t.Fatal("expected the legacy read to still issue a token")
+	}
+
+	if resp := tb.mustRequest(t, logical.UpdateOperation, "roles/current", data); hasWarning(resp) {
+		t.Fatalf("expected no warning writing roles/, got %v", resp.Warnings)
+	}
+	if resp := tb.mustRequest(t, logical.ReadOperation, "creds/current", nil); hasWarning(resp) {
+		t.Fatalf("expected no warning reading creds/, got %v", resp.Warnings)
+	}
+}
+
+func TestWriteValidatesAccount(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "accounts/valid", map[string]interface{}{
+		"key-file":     testKeyFile,
+		"organization": "test-org",
+		"cluster":      "test-cluster",
+	})
+
+	for name, tc := range map[string]struct {
+		data map[string]interface{}
+		err  string
+	}{
+		"truncated key file": {
+			data: map[string]interface{}{
+				"key-file":     testKeyFile[:len(testKeyFile)/2],
+				"organization": "test-org",
+				"cluster":      "test-cluster",
+			},
+			err: "key-file",
+		},
+		"incomplete key file": {
+			data: map[string]interface{}{
+				"key-file":     `{"type":"sn_service_account","client_id":"test-client"}`,
+				"organization": "test-org",
+				"cluster":      "test-cluster",
+			},
+			err: "client_secret, issuer_url",
+		},
+		"missing organization": {
+			data: map[string]interface{}{
+				"key-file": testKeyFile,
+				"cluster":  "test-cluster",
+			},
+			err: "organization",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := tb.request(t, logical.UpdateOperation, "accounts/invalid", tc.data)
+			if msg := errorText(resp, err); !strings.Contains(msg, tc.err) {
+				t.Fatalf("expected an error mentioning %q, got %q", tc.err, msg)
+			}
+			if entry, _ := tb.storage.Get(context.Background(), "accounts/invalid"); entry != nil {
+				t.Fatal("the invalid account was stored")
+			}
+		})
+	}
+}
+
+func TestMaxEntryBytes(t *testing.T) {
+	tb := getTestBackend(t)
+	oversized := strings.Replace(testKeyFile, `"type"`, `"padding":"`+strings.Repeat("x", 64<<10)+`","type"`, 1)
+
+	for _, path := range []string{"oversized", "roles/oversized"} {
+		resp, err := tb.request(t, logical.UpdateOperation, path, testAccount(oversized))
+		if msg := errorText(resp, err); !strings.Contains(msg, "max_entry_bytes") {
+			t.Fatalf("%s: expected the oversized write to be rejected, got %q", path, msg)
+		}
+		if entry, err := tb.storage.Get(context.Background(), path); err != nil || entry != nil {
+			t.Fatalf("%s: the oversized entry was stored", path)
+		}
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "normal", testAccount(testKeyFile))
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"max_entry_bytes": 128 << 10})
+	tb.mustRequest(t, logical.UpdateOperation, "oversized", testAccount(oversized))
+}
+
+func TestListAccounts(t *testing.T) {
+	tb := getTestBackend(t)
+	for _, path := range []string{"alpha", "team/beta", "gamma"} {
+		tb.mustRequest(t, logical.UpdateOperation, path, map[string]interface{}{
+			"key-file":     testKeyFile,
+			"organization": "test-org",
+			"cluster":      "test-cluster",
+		})
+	}
+	// The plugin's own entries are stored alongside the accounts but never
+	// listed with them.
+	tb.writeRole(t, "reader", nil)
+	tb.mustRequest(t, logical.UpdateOperation, "config/account/test-org", map[string]interface{}{
+		"key-file": testKeyFile,
+	})
+	ctx := context.Background()
+	if err := tb.storage.Put(ctx, &logical.StorageEntry{Key: configStorageKey, Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := framework.PutWAL(ctx, tb.storage, walKindAccountWrite, &accountWAL{Path: "alpha"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(prefix string) []string {
+		resp := tb.mustRequest(t, logical.ListOperation, prefix, nil)
+		keys, _ := resp.Data["keys"].([]string)
+		return keys
+	}
+
+	if keys := list(""); !reflect.DeepEqual(keys, []string{"alpha", "gamma", "team/"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if keys := list("team/"); !reflect.DeepEqual(keys, []string{"beta"}) {
+		t.Fatalf("unexpected keys under team/: %v", keys)
+	}
+	resp := tb.mustRequest(t, logical.ListOperation, "", nil)
+	if strings.Contains(fmt.Sprint(resp.Data), "test-client-secret") {
+		t.Fatal("the listing leaked a key file")
+	}
+
+	tb.mustRequest(t, logical.DeleteOperation, "alpha", nil)
+	if keys := list(""); !reflect.DeepEqual(keys, []string{"gamma", "team/"}) {
+		t.Fatalf("expected the deleted account to be unlisted, got %v", keys)
+	}
+}
+
+// lastGetToken returns the arguments of the latest get-token invocation.
+func (tb *testBackend) lastGetToken(t *testing.T) string {
+	t.Helper()
+	calls := tb.snctl.Calls("auth get-token")
+	if len(calls) == 0 {
+		t.Fatal("get-token was not run")
+	}
+	return strings.Join(calls[len(calls)-1].Args, " ")
+}
+
+func TestReadOverrides(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "account", map[string]interface{}{
+		"key-file":              testKeyFile,
+		"organization":          "test-org",
+		"cluster":               "test-cluster",
+		"allowed_organizations": "other-org",
+	})
+
+	tb.mustRequest(t, logical.ReadOperation, "account", nil)
+	if args := tb.lastGetToken(t); !strings.Contains(args, "-n test-org auth get-token test-cluster") {
+		t.Fatalf("expected the stored organization and cluster, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.ReadOperation, "account", map[string]interface{}{"organization": "other-org", "cluster": "other-cluster"})
+	if args := tb.lastGetToken(t); !strings.Contains(args, "-n other-org auth get-token other-cluster") {
+		t.Fatalf("expected the overrides, got %s", args)
+	}
+
+	resp, err := tb.request(t, logical.ReadOperation, "account", map[string]interface{}{"cluster": " "})
+	if errorText(resp, err) == "" {
+		t.Fatal("expected an empty cluster override to be rejected")
+	}
+}
+
+func TestAllowedClusters(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "restricted", map[string]interface{}{"allowed_clusters": "test-cluster,other-cluster"})
+	tb.writeRole(t, "open", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/restricted", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/restricted", map[string]interface{}{"cluster": "other-cluster"})
+	resp, err := tb.request(t, logical.ReadOperation, "creds/restricted", map[string]interface{}{"cluster": "forbidden-cluster"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "allowed_clusters") {
+		t.Fatalf("expected the disallowed cluster to be rejected, got %q", msg)
+	}
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/open", map[string]interface{}{"cluster": "any-cluster"})
+}
+
+func TestAllowedOrganizations(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "tenant", map[string]interface{}{"allowed_organizations": "test-org,other-org"})
+	tb.writeRole(t, "restrictive", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/tenant", map[string]interface{}{"organization": "other-org"})
+	if args := tb.lastGetToken(t); !strings.Contains(args, "-n other-org ") {
+		t.Fatalf("expected the allowed organization, got %s", args)
+	}
+	resp, err := tb.request(t, logical.ReadOperation, "creds/tenant", map[string]interface{}{"organization": "foreign-org"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "allowed_organizations") {
+		t.Fatalf("expected the disallowed organization to be rejected, got %q", msg)
+	}
+
+	// Without a list only the stored organization may be requested.
+	tb.mustRequest(t, logical.ReadOperation, "creds/restrictive", map[string]interface{}{"organization": "test-org"})
+	resp, err = tb.request(t, logical.ReadOperation, "creds/restrictive", map[string]interface{}{"organization": "other-org"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "allowed_organizations") {
+		t.Fatalf("expected another organization to be rejected by default, got %q", msg)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tb := getTestBackend(t)
+	account := func(extra map[string]interface{}) map[string]interface{} {
+		data := map[string]interface{}{"key-file": testKeyFile, "cluster": "test-cluster"}
+		for k, v := range extra {
+			data[k] = v
+		}
+		return data
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "clean", account(map[string]interface{}{"organization": "test-org"}))
+
+	resp, err := tb.request(t, logical.UpdateOperation, "typo", account(map[string]interface{}{
+		"organization": "test-org",
+		"clsuter":      "test-cluster",
+	}))
+	if msg := errorText(resp, err); !strings.Contains(msg, "Unknown fields: clsuter") {
+		t.Fatalf("expected the misspelled field to be named, got %q", msg)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "typo", account(map[string]interface{}{
+		"organization":         "test-org",
+		"clsuter":              "test-cluster",
+		"allow_unknown_fields": true,
+	}))
+}
+
+// storedAccount returns the account stored at path.
+func (tb *testBackend) storedAccount(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	entry, err := tb.storage.Get(context.Background(), path)
+	if err != nil || entry == nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	var account map[string]interface{}
+	if err := json.Unmarshal(entry.Value, &account); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestAudienceAndIssuer(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"key_file_stdin": true,
+		"audience_flag":  "--audience",
+	})
+	tb.mustRequest(t, logical.UpdateOperation, "regional", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+		"audience":        "stored-audience",
+		"issuer_url":      "https://eu.auth.example.com",
+	})
+	issuer := func() string {
+		calls := tb.snctl.Calls("activate-service-account")
+		var key map[string]interface{}
+		if err := json.Unmarshal(calls[len(calls)-1].Stdin, &key); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(key["issuer_url"])
+	}
+
+	tb.mustRequest(t, logical.ReadOperation, "regional", nil)
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, "--audience stored-audience") {
+		t.Fatalf("expected the stored audience, got %s", args)
+	}
+	if got := issuer(); got != "https://eu.auth.example.com" {
+		t.Fatalf("expected the stored issuer in the key file, got %s", got)
+	}
+
+	tb.mustRequest(t, logical.ReadOperation, "regional", map[string]interface{}{
+		"audience":   "read-audience",
+		"issuer_url": "https://us.auth.example.com",
+	})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, "--audience read-audience") {
+		t.Fatalf("expected the audience override, got %s", args)
+	}
+	if got := issuer(); got != "https://us.auth.example.com" {
+		t.Fatalf("expected the issuer override in the key file, got %s", got)
+	}
+
+	for _, url := range []string{"http://auth.example.com", "auth.example.com", "https://"} {
+		resp, err := tb.request(t, logical.ReadOperation, "regional", map[string]interface{}{"issuer_url": url})
+		if msg := errorText(resp, err); !strings.Contains(msg, "https URL") {
+			t.Errorf("expected the read with issuer_url %s to be rejected, got %q", url, msg)
+		}
+		resp, err = tb.request(t, logical.UpdateOperation, "insecure", map[string]interface{}{
+			"key-file":        testKeyFile,
+			"organization":    "test-org",
+			"default_cluster": "test-cluster",
+			"issuer_url":      url,
+		})
+		if msg := errorText(resp, err); !strings.Contains(msg, "https URL") {
+			t.Errorf("expected the write with issuer_url %s to be rejected, got %q", url, msg)
+		}
+	}
+}
+
+func TestAudienceFlag(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "aud", nil)
+	read := func() (*logical.Response, error) {
+		return tb.request(t, logical.ReadOperation, "creds/aud", map[string]interface{}{"audience": "custom"})
+	}
+
+	if msg := errorText(read()); !strings.Contains(msg, "audience_flag") {
+		t.Fatalf("expected the audience to be refused without a flag, got %q", msg)
+	}
+
+	tb.setEnv(t, "SNCTL_AUDIENCE_FLAG", "--aud")
+	if msg := errorText(read()); msg != "" {
+		t.Fatal(msg)
+	}
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, "--aud custom") {
+		t.Fatalf("expected the flag from the environment, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"audience_flag": "--audience"})
+	tb.mustRequest(t, logical.UpdateOperation, "tidy/cache", nil)
+	if msg := errorText(read()); msg != "" {
+		t.Fatal(msg)
+	}
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, "--audience custom") {
+		t.Fatalf("expected the configured flag to take precedence, got %s", args)
+	}
+	conf := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil).Data
+	if conf["audience_flag"] != "--audience" || conf["sources"].(map[string]string)["audience_flag"] != "storage" {
+		t.Fatalf("unexpected config %v", conf)
+	}
+
+	resp, err := tb.request(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"audience_flag": "--audience x"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "audience_flag") {
+		t.Fatalf("expected a malformed flag to be rejected, got %q", msg)
+	}
+}
+
+// sealRecordingStorage records which entries were put with SealWrap set.
+type sealRecordingStorage struct {
+	logical.Storage
+	mu     sync.Mutex
+	sealed map[string]bool
+}
+
+func (s *sealRecordingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s.mu.Lock()
+	s.sealed[entry.Key] = entry.SealWrap
+	s.mu.Unlock()
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestAccountsSealWrapped(t *testing.T) {
+	tb := getTestBackend(t)
+	storage := &sealRecordingStorage{Storage: tb.storage, sealed: map[string]bool{}}
+	tb.storage = storage
+	tb.mustRequest(t, logical.UpdateOperation, "team/account", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	tb.writeRole(t, "sealed", nil)
+	tb.mustRequest(t, logical.UpdateOperation, "rotate/team/account", map[string]interface{}{"key-file": testKeyFileFor("new-client")})
+
+	for _, key := range []string{"team/account", "roles/sealed"} {
+		if !storage.sealed[key] {
+			t.Errorf("%s was stored without seal wrapping", key)
+		}
+	}
+	if !containsString(tb.PathsSpecial.SealWrapStorage, rolePrefix) {
+		t.Errorf("roles/ is not marked for seal wrapping: %v", tb.PathsSpecial.SealWrapStorage)
+	}
+	// Reads decrypt the entry transparently.
+	tb.mustRequest(t, logical.ReadOperation, "team/account", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/sealed", nil)
+}
+
+func TestStoredFormatVersions(t *testing.T) {
+	tb := getTestBackend(t)
+	ctx := context.Background()
+	keyFile, _ := json.Marshal(testKeyFile)
+	for path, value := range map[string]string{
+		"legacy": `{"key-file":` + string(keyFile) + `,"organization":"test-org","cluster":"test-cluster"}`,
+		"v1":     `{"key-file":` + string(keyFile) + `,"organization":"test-org","cluster":"test-cluster","version":1}`,
+		"future": `{"key-file":` + string(keyFile) + `,"organization":"test-org","default_cluster":"test-cluster","version":99}`,
+	} {
+		if err := tb.storage.Put(ctx, &logical.StorageEntry{Key: path, Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{"legacy", "v1"} {
+		tb.mustRequest(t, logical.ReadOperation, path, nil)
+		if args := tb.lastGetToken(t); !strings.Contains(args, "-n test-org auth get-token test-cluster") {
+			t.Fatalf("expected %s to be read with its organization and cluster, got %s", path, args)
+		}
+	}
+	resp, err := tb.request(t, logical.ReadOperation, "future", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "unsupported format version") {
+		t.Fatalf("expected an unknown version to be refused, got %q", msg)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "current", testAccount(testKeyFile))
+	if version := tb.storedAccount(t, "current")["version"]; version != float64(accountVersion) {
+		t.Fatalf("expected new writes to store version %d, got %v", accountVersion, version)
+	}
+}
+
+func TestDefaultCluster(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "multi", map[string]interface{}{"allowed_clusters": "test-cluster,other-cluster"})
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/multi", nil)
+	if args := tb.lastGetToken(t); !strings.Contains(args, "get-token test-cluster") {
+		t.Fatalf("expected the default cluster, got %s", args)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/multi", map[string]interface{}{"cluster": "other-cluster"})
+	if args := tb.lastGetToken(t); !strings.Contains(args, "get-token other-cluster") {
+		t.Fatalf("expected the cluster override, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "roles/undefaulted", map[string]interface{}{
+		"key-file":         testKeyFile,
+		"organization":     "test-org",
+		"allowed_clusters": "test-cluster",
+	})
+	resp, err := tb.request(t, logical.ReadOperation, "creds/undefaulted", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "no default_cluster") {
+		t.Fatalf("expected a read without a cluster to be refused, got %q", msg)
+	}
+
+	// The deprecated cluster field is stored as default_cluster.
+	resp = tb.mustRequest(t, logical.UpdateOperation, "migrated", map[string]interface{}{
+		"key-file":     testKeyFile,
+		"organization": "test-org",
+		"cluster":      "test-cluster",
+	})
+	if len(resp.Warnings) == 0 || !strings.Contains(resp.Warnings[0], "deprecated") {
+		t.Fatalf("expected a deprecation warning, got %v", resp.Warnings)
+	}
+	if account := tb.storedAccount(t, "migrated"); account["default_cluster"] != "test-cluster" || account["cluster"] != nil {
+		t.Fatalf("expected cluster to be stored as default_cluster, got %v", account)
+	}
+	resp, err = tb.request(t, logical.UpdateOperation, "conflicting", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"cluster":         "test-cluster",
+		"default_cluster": "other-cluster",
+	})
+	if msg := errorText(resp, err); !strings.Contains(msg, "differs from 'default_cluster'") {
+		t.Fatalf("expected conflicting clusters to be refused, got %q", msg)
+	}
+}
+
+func TestKeyFileBase64(t *testing.T) {
+	tb := getTestBackend(t)
+	encoded := base64.StdEncoding.EncodeToString([]byte(testKeyFile))
+	tb.mustRequest(t, logical.UpdateOperation, "encoded", map[string]interface{}{
+		"key_file_base64": encoded,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	if got := tb.storedAccount(t, "encoded")["key-file"]; got != testKeyFile {
+		t.Fatalf("expected the decoded key file to be stored, got %v", got)
+	}
+	activateInHome(t, tb)
+	resp := tb.mustRequest(t, logical.ReadOperation, "encoded", nil)
+	if claims, err := decodeJwtClaims(resp.Data["token"].(string)); err != nil || claims["sub"] != "test-client" {
+		t.Fatalf("expected a token minted with the decoded key, got %v, %v", claims, err)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"mutually exclusive": {"key-file": testKeyFile, "key_file_base64": encoded},
+		"not valid base64":   {"key_file_base64": "not base64!"},
+		"client_secret":      {"key_file_base64": base64.StdEncoding.EncodeToString([]byte(`{"type":"sn_service_account"}`))},
+	} {
+		data["organization"] = "test-org"
+		data["default_cluster"] = "test-cluster"
+		resp, err := tb.request(t, logical.UpdateOperation, "rejected", data)
+		if msg := errorText(resp, err); !strings.Contains(msg, name) {
+			t.Errorf("expected an error containing %q, got %q", name, msg)
+		}
+	}
+}
+
+func TestKeyFilesFailover(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"key_file_stdin": true})
+	tb.mustRequest(t, logical.UpdateOperation, "failover", map[string]interface{}{
+		"key_files":       []interface{}{testKeyFileFor("revoked-client"), testKeyFileFor("new-client")},
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	activateInHome(t, tb)
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if cmd.Stdin != nil {
+			keyFile, err := io.ReadAll(cmd.Stdin)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(string(keyFile), "revoked-client") {
+				io.WriteString(cmd.Stderr, "error: invalid_client")
+				return errors.New("exit status 1")
+			}
+			cmd.Stdin = bytes.NewReader(keyFile)
+		}
+		return run(cmd)
+	}
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "failover", nil)
+	if claims, err := decodeJwtClaims(resp.Data["token"].(string)); err != nil || claims["sub"] != "new-client" {
+		t.Fatalf("expected a token minted with the second key, got %v, %v", claims, err)
+	}
+	if logs := tb.logs.String(); !strings.Contains(logs, "key_index=1") || strings.Contains(logs, "test-client-secret") {
+		t.Fatalf("expected the succeeding key's index to be logged without its secret:\n%s", logs)
+	}
+}
+
+// rejectActivation makes activating a key file of any of the clients fail as
+// a rejected credential does.
+func rejectActivation(tb *testBackend, clientIDs ...string) {
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if strings.Contains(strings.Join(cmd.Args, " "), "activate-service-account") {
+			var keyFile []byte
+			var err error
+			if cmd.Stdin != nil {
+				keyFile, err = io.ReadAll(cmd.Stdin)
+				cmd.Stdin = bytes.NewReader(keyFile)
+			} else {
+				keyFile, err = os.ReadFile(argAfter(cmd.Args, "--key-file"))
+			}
+			if err != nil {
+				return err
+			}
+			for _, clientID := range clientIDs {
+				if strings.Contains(string(keyFile), `"client_id":"`+clientID+`"`) {
+					io.WriteString(cmd.Stderr, "error: invalid_client")
+					return errors.New("exit status 1")
+				}
+			}
+		}
+		return run(cmd)
+	}
+}
+
+func TestSuperuserTokens(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "admin", map[string]interface{}{"allow_superuser": true})
+	tb.writeRole(t, "regular", nil)
+	superuser := map[string]interface{}{"superuser": true}
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/admin", superuser)
+	if msg := errorText(resp, err); !strings.Contains(msg, "superuser_flag") {
+		t.Fatalf("expected superuser tokens to be refused without a flag, got %q", msg)
+	}
+
+	tb.setEnv(t, "SNCTL_SUPERUSER_FLAG", "--admin")
+	tb.mustRequest(t, logical.ReadOperation, "creds/admin", map[string]interface{}{"superuser": true})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --admin") {
+		t.Fatalf("expected the flag from the environment, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"superuser_flag": "--superuser"})
+	tb.mustRequest(t, logical.UpdateOperation, "tidy/cache", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/admin", map[string]interface{}{"superuser": true})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --superuser") {
+		t.Fatalf("expected the configured flag, got %s", args)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/admin", nil)
+	if args := tb.lastGetToken(t); strings.Contains(args, "--superuser") {
+		t.Fatalf("a regular read was served a superuser token: %s", args)
+	}
+
+	resp, err = tb.request(t, logical.ReadOperation, "creds/regular", map[string]interface{}{"superuser": true})
+	if msg := errorText(resp, err); !strings.Contains(msg, "does not allow superuser tokens") {
+		t.Fatalf("expected the account without allow_superuser to be refused, got %q", msg)
+	}
+	if logs := tb.logs.String(); strings.Contains(logs, "--superuser") || strings.Contains(logs, "--admin") {
+		t.Fatalf("the superuser flag was logged:\n%s", logs)
+	}
+}
+
+func TestSubjectTokens(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "impersonating", map[string]interface{}{"allowed_subjects": "reader,writer"})
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/impersonating", nil)
+	if args := tb.lastGetToken(t); strings.Contains(args, "reader") {
+		t.Fatalf("expected the account's own subject by default, got %s", args)
+	}
+	resp, err := tb.request(t, logical.ReadOperation, "creds/impersonating", map[string]interface{}{"subject": "reader"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "subject_flag") {
+		t.Fatalf("expected subject tokens to be refused without a flag, got %q", msg)
+	}
+
+	tb.setEnv(t, "SNCTL_SUBJECT_FLAG", "--as")
+	tb.mustRequest(t, logical.ReadOperation, "creds/impersonating", map[string]interface{}{"subject": "reader"})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --as reader") {
+		t.Fatalf("expected the flag from the environment, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"subject_flag": "--subject"})
+	tb.mustRequest(t, logical.ReadOperation, "creds/impersonating", map[string]interface{}{"subject": "writer"})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --subject writer") {
+		t.Fatalf("expected the configured flag, got %s", args)
+	}
+
+	resp, err = tb.request(t, logical.ReadOperation, "creds/impersonating", map[string]interface{}{"subject": "admin"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "allowed_subjects") {
+		t.Fatalf("expected the disallowed subject to be rejected, got %q", msg)
+	}
+	resp, err = tb.request(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"subject_flag": "--subject reader"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "subject_flag") {
+		t.Fatalf("expected an invalid flag to be rejected, got %q", msg)
+	}
+}
+
+func TestScopedTokens(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "scoped", map[string]interface{}{"allowed_scopes": "read,write,admin"})
+	tb.writeRole(t, "unrestricted", nil)
+
+	// Without scopes the token carries the account's defaults.
+	tb.mustRequest(t, logical.ReadOperation, "creds/scoped", nil)
+	if args := tb.lastGetToken(t); strings.Contains(args, "read") {
+		t.Fatalf("expected no scopes by default, got %s", args)
+	}
+	resp, err := tb.request(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "read"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "scope_flag") {
+		t.Fatalf("expected scoped tokens to be refused without a flag, got %q", msg)
+	}
+
+	tb.setEnv(t, "SNCTL_SCOPE_FLAG", "--scopes")
+	tb.mustRequest(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "write read"})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --scopes read write") {
+		t.Fatalf("expected the flag from the environment with the sorted subset, got %s", args)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"scope_flag": "--scope"})
+	tb.mustRequest(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "admin,read,admin"})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --scope admin read") {
+		t.Fatalf("expected the configured flag, got %s", args)
+	}
+	if resp := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil); resp.Data["scope_flag"] != "--scope" {
+		t.Fatalf("expected scope_flag to be reported, got %v", resp.Data["scope_flag"])
+	}
+
+	minted := len(tb.snctl.Calls("auth get-token"))
+	resp, err = tb.request(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "read delete"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "Scope 'delete' is not in this account's allowed_scopes") {
+		t.Fatalf("expected the out-of-list scope to be rejected, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != minted {
+		t.Fatal("the rejected read ran snctl")
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/unrestricted", map[string]interface{}{"scopes": "delete"})
+	if args := tb.lastGetToken(t); !strings.HasSuffix(args, " --scope delete") {
+		t.Fatalf("expected any scope without allowed_scopes, got %s", args)
+	}
+
+	resp, err = tb.request(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"scope_flag": "--scope read"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "scope_flag") {
+		t.Fatalf("expected an invalid flag to be rejected, got %q", msg)
+	}
+}
+
+func TestValidateOnWrite(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	issuer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{}`)
+	}))
+	defer issuer.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Certificate().Raw})
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"validate_on_write": true,
+		"ca_cert":           string(caCert),
+	})
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if args := strings.Join(cmd.Args, " "); strings.Contains(args, "get-token unknown-cluster") {
+			io.WriteString(cmd.Stderr, "error: cluster unknown-cluster not found")
+			return errors.New("exit status 1")
+		}
+		return run(cmd)
+	}
+	keyFile := strings.ReplaceAll(testKeyFile, "https://auth.streamnative.cloud", issuer.URL)
+	write := func(cluster string) (*logical.Response, error) {
+		return tb.request(t, logical.UpdateOperation, "validated", map[string]interface{}{
+			"key-file":        keyFile,
+			"organization":    "test-org",
+			"default_cluster": cluster,
+		})
+	}
+
+	if msg := errorText(write("test-cluster")); msg != "" {
+		t.Fatalf("expected the known cluster to be accepted, got %q", msg)
+	}
+	msg := errorText(write("unknown-cluster"))
+	if !strings.Contains(msg, "cluster 'unknown-cluster' was not found") {
+		t.Fatalf("expected the unknown cluster to be named, got %q", msg)
+	}
+	if tb.storedAccount(t, "validated")["default_cluster"] != "test-cluster" {
+		t.Fatal("the rejected write was stored")
+	}
+}
@@ -0,0 +1,83 @@
+package streamnative
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker fast-fails token minting after a run of consecutive failures.
+// Once the cooldown elapses a single probe is let through (half-open); its
+// outcome either closes the circuit or re-opens it for another cooldown.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a mint may be attempted. A threshold of zero disables
+// the breaker.
+func (cb *circuitBreaker) allow(threshold int, cooldown time.Duration) bool {
+	if threshold <= 0 {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// A probe is already in flight.
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a mint attempt.
+func (cb *circuitBreaker) record(err error, threshold int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.state == breakerHalfOpen || (threshold > 0 && cb.failures >= threshold) {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// release ends a probe whose outcome says nothing about token generation,
+// such as a read for a cluster that does not exist, so that the next mint
+// attempt probes instead. The circuit stays open until a probe succeeds.
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == breakerHalfOpen {
+		cb.state = breakerOpen
+	}
+}
+
+// status returns the breaker state, consecutive failure count, and the time
+// the circuit last opened.
+func (cb *circuitBreaker) status() (string, int, time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	state := cb.state
+	if state == "" {
+		state = breakerClosed
+	}
+	return state, cb.failures, cb.openedAt
+}
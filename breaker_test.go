@@ -0,0 +1,143 @@
+package streamnative
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var cb circuitBreaker
+	const threshold, cooldown = 3, 20 * time.Millisecond
+	failure := errors.New("issuer unavailable")
+
+	for i := 0; i < threshold; i++ {
+		if !cb.allow(threshold, cooldown) {
+			t.Fatalf("the breaker opened after %d failures", i)
+		}
+		cb.record(failure, threshold)
+	}
+	if cb.allow(threshold, cooldown) {
+		t.Fatal("sustained failures did not open the breaker")
+	}
+
+	time.Sleep(cooldown)
+	if !cb.allow(threshold, cooldown) {
+		t.Fatal("the breaker did not let a probe through after the cooldown")
+	}
+	if cb.allow(threshold, cooldown) {
+		t.Fatal("the breaker let a second probe through while half-open")
+	}
+	cb.record(nil, threshold)
+	if state, failures, _ := cb.status(); state != breakerClosed || failures != 0 {
+		t.Fatalf("expected the breaker to recover, got %s with %d failures", state, failures)
+	}
+}
+
+func TestCircuitBreakerReads(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	t.Setenv("SNCTL_BREAKER_COOLDOWN", "50ms")
+	tb.setEnv(t, "SNCTL_BREAKER_THRESHOLD", "2")
+	tb.writeRole(t, "breaker", nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "connection refused", ExitCode: 1})
+
+	for i := 0; i < 2; i++ {
+		resp, err := tb.request(t, logical.ReadOperation, "creds/breaker", map[string]interface{}{"no_cache": true})
+		if errorText(resp, err) == "" {
+			t.Fatal("expected the read to fail")
+		}
+	}
+	resp := tb.mustRequest(t, logical.ReadOperation, "stats", nil)
+	if state := resp.Data["circuit_breaker"].(map[string]interface{})["state"]; state != breakerOpen {
+		t.Fatalf("expected the breaker to be open, got %v", state)
+	}
+	before := len(tb.snctl.Calls("auth get-token"))
+	if resp, err := tb.request(t, logical.ReadOperation, "creds/breaker", nil); errorText(resp, err) == "" {
+		t.Fatal("expected the open breaker to fail the read")
+	}
+	if after := len(tb.snctl.Calls("auth get-token")); after != before {
+		t.Fatal("the open breaker ran get-token")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, nil)})
+	tb.mustRequest(t, logical.ReadOperation, "creds/breaker", nil)
+	resp = tb.mustRequest(t, logical.ReadOperation, "stats", nil)
+	if state := resp.Data["circuit_breaker"].(map[string]interface{})["state"]; state != breakerClosed {
+		t.Fatalf("expected the breaker to close after the cooldown, got %v", state)
+	}
+}
+
+func TestCircuitBreakerInvalidProbe(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	t.Setenv("SNCTL_BREAKER_COOLDOWN", "50ms")
+	tb.setEnv(t, "SNCTL_BREAKER_THRESHOLD", "1")
+	tb.writeRole(t, "breaker", nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "connection refused", ExitCode: 1})
+	if resp, err := tb.request(t, logical.ReadOperation, "creds/breaker", nil); errorText(resp, err) == "" {
+		t.Fatal("expected the read to fail")
+	}
+
+	// A probe for a missing cluster neither closes nor wedges the breaker.
+	time.Sleep(50 * time.Millisecond)
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "error: cluster test-cluster not found", ExitCode: 1})
+	req := &logical.Request{Operation: logical.ReadOperation, Path: "creds/breaker", Storage: tb.storage}
+	resp, err := tb.HandleRequest(context.Background(), req)
+	if status := responseStatus(req, resp, err); status != http.StatusBadRequest {
+		t.Fatalf("expected the missing cluster to be rejected with 400, got %d", status)
+	}
+	resp = tb.mustRequest(t, logical.ReadOperation, "stats", nil)
+	if state := resp.Data["circuit_breaker"].(map[string]interface{})["state"]; state != breakerOpen {
+		t.Fatalf("expected the breaker to stay open, got %v", state)
+	}
+
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, nil)})
+	tb.mustRequest(t, logical.ReadOperation, "creds/breaker", nil)
+	resp = tb.mustRequest(t, logical.ReadOperation, "stats", nil)
+	if state := resp.Data["circuit_breaker"].(map[string]interface{})["state"]; state != breakerClosed {
+		t.Fatalf("expected the next probe to close the breaker, got %v", state)
+	}
+}
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	tb.setEnv(t, "SNCTL_BREAKER_THRESHOLD", "5")
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"breaker_threshold":   1,
+		"breaker_cooldown":    "1h",
+		"breaker_serve_stale": true,
+	})
+	resp := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil)
+	if resp.Data["breaker_threshold"] != 1 || resp.Data["breaker_cooldown"] != int64(3600) || resp.Data["breaker_serve_stale"] != true {
+		t.Fatalf("expected the stored breaker settings, got %v, %v, %v",
+			resp.Data["breaker_threshold"], resp.Data["breaker_cooldown"], resp.Data["breaker_serve_stale"])
+	}
+
+	// The stored threshold of 1 takes precedence over the environment's 5.
+	tb.writeRole(t, "breaker", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/breaker", nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "connection refused", ExitCode: 1})
+	resp, err := tb.request(t, logical.ReadOperation, "creds/breaker", map[string]interface{}{"no_cache": true})
+	if errorText(resp, err) == "" {
+		t.Fatal("expected the read to fail")
+	}
+	resp = tb.mustRequest(t, logical.ReadOperation, "stats", nil)
+	if state := resp.Data["circuit_breaker"].(map[string]interface{})["state"]; state != breakerOpen {
+		t.Fatalf("expected a single failure to open the breaker, got %v", state)
+	}
+	// With breaker_serve_stale, the cached token is served while open.
+	tb.mustRequest(t, logical.ReadOperation, "creds/breaker", nil)
+
+	resp, err = tb.request(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"breaker_threshold": -1})
+	if msg := errorText(resp, err); msg != "breaker_threshold must not be negative" {
+		t.Fatalf("expected a negative threshold to be rejected, got %q", msg)
+	}
+}
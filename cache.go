@@ -0,0 +1,171 @@
+package streamnative
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// cachedToken is a minted token held in memory.
+type cachedToken struct {
+	token    string
+	cachedAt time.Time
+	// expiresAt is the JWT exp claim, or zero when the token has none.
+	expiresAt time.Time
+}
+
+// tokenCache holds minted tokens in memory, keyed on a hash of everything
+// that determines the token. Keys are also tracked by the storage path they
+// were minted for so that writing or deleting the path drops them.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedToken
+	byPath  map[string]map[string]struct{}
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]*cachedToken),
+		byPath:  make(map[string]map[string]struct{}),
+	}
+}
+
+// tokenCacheKey hashes the inputs of token generation. The key-file is part of
+// the key so changed credentials never hit a token minted with the old ones.
+func tokenCacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns a cached token that is still at least skew away from its expiry.
+// When maxAge is positive, tokens cached longer ago than maxAge are ignored.
+// Tokens without an expiry are only returned when maxAge is positive.
+func (c *tokenCache) get(key string, maxAge time.Duration, skew time.Duration) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	now := time.Now()
+	if entry.expiresAt.IsZero() {
+		if maxAge <= 0 {
+			return "", false
+		}
+	} else if !now.Before(entry.expiresAt.Add(-skew)) {
+		return "", false
+	}
+	if maxAge > 0 && now.Sub(entry.cachedAt) >= maxAge {
+		return "", false
+	}
+	return entry.token, true
+}
+
+// stale returns a cached token regardless of age, as long as it has not
+// expired.
+func (c *tokenCache) stale(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		return "", false
+	}
+	return entry.token, true
+}
+
+// put caches token under key on behalf of path.
+func (c *tokenCache) put(path string, key string, token string) {
+	entry := &cachedToken{
+		token:    token,
+		cachedAt: time.Now(),
+	}
+	if claims, err := decodeJwtClaims(token); err == nil {
+		if exp, ok := numericClaim(claims, "exp"); ok {
+			entry.expiresAt = exp
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	c.trackLocked(path, key)
+}
+
+// track additionally associates the token cached under key with path, so
+// invalidating path drops it.
+func (c *tokenCache) track(path string, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackLocked(path, key)
+}
+
+func (c *tokenCache) trackLocked(path string, key string) {
+	keys, ok := c.byPath[path]
+	if !ok {
+		keys = make(map[string]struct{})
+		c.byPath[path] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// invalidate drops every token minted for path.
+func (c *tokenCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.byPath[path] {
+		delete(c.entries, key)
+	}
+	delete(c.byPath, path)
+}
+
+// flush drops every cached token and returns how many were dropped.
+func (c *tokenCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flushed := len(c.entries)
+	c.entries = make(map[string]*cachedToken)
+	c.byPath = make(map[string]map[string]struct{})
+	return flushed
+}
+
+// unexpiringRetention bounds how long a token without an expiry is kept.
+const unexpiringRetention = 24 * time.Hour
+
+// prune drops expired tokens, and tokens without an expiry cached longer ago
+// than unexpiringRetention. It returns the number of tokens dropped.
+func (c *tokenCache) prune() int {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pruned := 0
+	for key, entry := range c.entries {
+		if entry.expiresAt.IsZero() {
+			if now.Sub(entry.cachedAt) < unexpiringRetention {
+				continue
+			}
+		} else if now.Before(entry.expiresAt) {
+			continue
+		}
+		delete(c.entries, key)
+		pruned++
+	}
+	for path, keys := range c.byPath {
+		for key := range keys {
+			if _, ok := c.entries[key]; !ok {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(c.byPath, path)
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,186 @@
+package streamnative
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTokenCache(t *testing.T) {
+	c := newTokenCache()
+	fresh := testJWT(t, nil)
+	expiring := testJWT(t, map[string]interface{}{"exp": time.Now().Add(30 * time.Second).Unix()})
+	c.put("a", "fresh", fresh)
+	c.put("a", "expiring", expiring)
+	c.put("b", "other", fresh)
+
+	if token, ok := c.get("fresh", 0, time.Minute); !ok || token != fresh {
+		t.Fatal("expected the fresh token to be cached")
+	}
+	if _, ok := c.get("expiring", 0, time.Minute); ok {
+		t.Fatal("a token within the refresh skew of its expiry was returned")
+	}
+	if _, ok := c.get("fresh", time.Nanosecond, time.Minute); ok {
+		t.Fatal("a token older than the ttl was returned")
+	}
+
+	c.invalidate("a")
+	if _, ok := c.get("fresh", 0, time.Minute); ok {
+		t.Fatal("invalidating the path did not drop its tokens")
+	}
+	if _, ok := c.get("other", 0, time.Minute); !ok {
+		t.Fatal("invalidating one path dropped another's tokens")
+	}
+}
+
+func TestCachedReads(t *testing.T) {
+	tb := getTestBackend(t)
+	account := func() map[string]interface{} {
+		return map[string]interface{}{
+			"key-file":     testKeyFile,
+			"organization": "test-org",
+			"cluster":      "test-cluster",
+		}
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "legacy", account())
+	getTokens := func() int { return len(tb.snctl.Calls("auth get-token")) }
+
+	tb.mustRequest(t, logical.ReadOperation, "legacy", nil)
+	tb.mustRequest(t, logical.ReadOperation, "legacy", nil)
+	if n := getTokens(); n != 1 {
+		t.Fatalf("expected the second read to be cached, got %d get-token calls", n)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "legacy", account())
+	tb.mustRequest(t, logical.ReadOperation, "legacy", nil)
+	if n := getTokens(); n != 2 {
+		t.Fatalf("expected the write to invalidate the cache, got %d get-token calls", n)
+	}
+
+	tb.mustRequest(t, logical.DeleteOperation, "legacy", nil)
+	tb.mustRequest(t, logical.UpdateOperation, "legacy", account())
+	tb.mustRequest(t, logical.ReadOperation, "legacy", nil)
+	if n := getTokens(); n != 3 {
+		t.Fatalf("expected the delete to invalidate the cache, got %d get-token calls", n)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "refreshed", nil)
+	warm := tb.mustRequest(t, logical.ReadOperation, "creds/refreshed", nil).Data["token"]
+
+	fresh := testJWT(t, map[string]interface{}{"sub": "refreshed-client"})
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: fresh})
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/refreshed", map[string]interface{}{"no_cache": true})
+	if n := len(tb.snctl.Calls("auth get-token")); n != 2 {
+		t.Fatalf("expected no_cache to run get-token again, got %d calls", n)
+	}
+	if resp.Data["token"] == warm || resp.Data["token"] != fresh {
+		t.Fatal("expected no_cache to return a fresh token")
+	}
+
+	resp = tb.mustRequest(t, logical.ReadOperation, "creds/refreshed", nil)
+	if n := len(tb.snctl.Calls("auth get-token")); n != 2 || resp.Data["token"] != fresh {
+		t.Fatalf("expected the fresh token to replace the cached one, got %d calls", n)
+	}
+}
+
+func TestRefreshSkew(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "skewed", nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{
+		"exp": time.Now().Add(30 * time.Second).Unix(),
+	})})
+	getTokens := func() int { return len(tb.snctl.Calls("auth get-token")) }
+
+	// Within the default skew of 60s, the token is refreshed on every read.
+	tb.mustRequest(t, logical.ReadOperation, "creds/skewed", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/skewed", nil)
+	if n := getTokens(); n != 2 {
+		t.Fatalf("expected the expiring token to be refreshed, got %d get-token calls", n)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"refresh_skew": "10s"})
+	tb.mustRequest(t, logical.ReadOperation, "creds/skewed", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/skewed", nil)
+	if n := getTokens(); n != 2 {
+		t.Fatalf("expected the token to be served from the cache outside the skew, got %d get-token calls", n)
+	}
+
+	// The lease of a longer-lived token ends a skew before it does.
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, nil)})
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/skewed", map[string]interface{}{"no_cache": true})
+	if ttl := resp.Secret.TTL; ttl > time.Hour-10*time.Second || ttl < time.Hour-15*time.Second {
+		t.Fatalf("expected a lease 10s shorter than the token, got %s", ttl)
+	}
+}
+
+func TestCachedReadInvalidTtl(t *testing.T) {
+	tb := getTestBackend(t)
+	entry, err := logical.StorageEntryJSON("legacy", map[string]interface{}{
+		"key-file":     testKeyFile,
+		"organization": "test-org",
+		"cluster":      "test-cluster",
+		"ttl":          "soon",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tb.storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := tb.request(t, logical.ReadOperation, "legacy", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "ttl is not an integer") {
+		t.Fatalf("expected the invalid ttl to fail the read, got %q", msg)
+	}
+}
+
+func TestStoredTtlDuration(t *testing.T) {
+	tb := getTestBackend(t)
+	for ttl, want := range map[interface{}]float64{"1h": 3600, "90": 90, 120: 120} {
+		tb.writeRole(t, "limited", map[string]interface{}{"ttl": ttl})
+		if stored := tb.storedAccount(t, "roles/limited")["ttl"]; stored != want {
+			t.Errorf("ttl %v: expected %v seconds to be stored, got %v", ttl, want, stored)
+		}
+	}
+
+	// The stored hour lets the cached token be reused.
+	tb.writeRole(t, "limited", map[string]interface{}{"ttl": "1h"})
+	tb.mustRequest(t, logical.ReadOperation, "creds/limited", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/limited", nil)
+	if n := len(tb.snctl.Calls("auth get-token")); n != 1 {
+		t.Fatalf("expected the second read to be served from the cache, got %d get-token calls", n)
+	}
+
+	// Legacy paths take arbitrary input, so storing checks the ttl itself.
+	tb.mustRequest(t, logical.UpdateOperation, "legacy", map[string]interface{}{
+		"key-file":     testKeyFile,
+		"organization": "test-org",
+		"cluster":      "test-cluster",
+		"ttl":          "10m",
+	})
+	if stored := tb.storedAccount(t, "legacy")["ttl"]; stored != float64(600) {
+		t.Fatalf("expected 600 seconds to be stored, got %v", stored)
+	}
+	for _, ttl := range []interface{}{"soon", "-1h", []interface{}{"1h"}} {
+		// Not tb.request, whose existence check fails on invalid fields.
+		resp, err := tb.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "legacy",
+			Data:      map[string]interface{}{"ttl": ttl},
+			Storage:   tb.storage,
+		})
+		if msg := errorText(resp, err); !strings.Contains(msg, "ttl") {
+			t.Errorf("ttl %v: expected it to be rejected, got %q", ttl, msg)
+		}
+	}
+	if stored := tb.storedAccount(t, "legacy")["ttl"]; stored != float64(600) {
+		t.Fatalf("expected the rejected writes to keep the stored ttl, got %v", stored)
+	}
+}
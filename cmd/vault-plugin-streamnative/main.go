@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	streamnative "github.com/arctype-co/vault-plugin-streamnative"
@@ -11,11 +12,19 @@ import (
 
 func main() {
 	logger := hclog.New(&hclog.LoggerOptions{})
-	logger.Info("Using snctl", "snctl", streamnative.GetSnctl())
+	logger.Info("Using snctl", "snctl", streamnative.GetSnctl(), "version", streamnative.Version)
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
+	selfTest := flags.Bool("selftest", false, "check that snctl is usable, print a report and exit instead of serving")
 	flags.Parse(os.Args[1:])
 
+	if *selfTest {
+		if !streamnative.SelfTest(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
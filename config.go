@@ -0,0 +1,635 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// snctlConfig is an immutable snapshot of the backend configuration. Each
+// request captures the current snapshot when it starts and uses it throughout,
+// so swapping in a new configuration never affects a request already in flight.
+// Never modify a snapshot once it has been stored; build a new one instead.
+type snctlConfig struct {
+	// Mode selects how tokens are minted: modeSnctl or modeOAuth2.
+	Mode string
+	// BinaryPath is the snctl executable to run.
+	BinaryPath string
+	// ConfigDir is the HOME snctl runs under, keeping its configuration in
+	// ConfigDir/.snctl. The plugin process HOME is used when empty.
+	// Read from SNCTL_CONFIG_DIR.
+	ConfigDir string
+	// TempDir holds per-request key files and HOMEs. The system temporary
+	// directory is used when empty. Read from SNCTL_TEMP_DIR.
+	TempDir string
+	// SweepInterval is how often expired cached tokens and stale temporary
+	// files are cleaned up.
+	SweepInterval time.Duration
+	// TempFileMaxAge is the age after which a temporary key file or HOME is
+	// considered abandoned and removed.
+	TempFileMaxAge time.Duration
+	// SnctlEnv holds extra environment variables for snctl, such as proxy
+	// settings. Their values may be sensitive and are never logged.
+	SnctlEnv map[string]string
+	// KeyFileStdin pipes the key file to snctl on stdin rather than writing
+	// it to a temporary file.
+	KeyFileStdin bool
+	// IsolateHome runs every token generation under its own temporary HOME,
+	// so requests share no snctl state and need not be serialized.
+	IsolateHome bool
+	// HomeFallback allows falling back to a temporary HOME when ~/.snctl is
+	// unwritable.
+	HomeFallback bool
+	// EagerInit checks snctl and its config directory when the mount is
+	// initialized, logging any problem rather than waiting for the first read.
+	EagerInit bool
+	// RequestTimeout bounds each snctl invocation.
+	RequestTimeout time.Duration
+	// ValidateOnWrite mints a token when an account is written, rejecting
+	// accounts that cannot access their organization and cluster.
+	ValidateOnWrite bool
+	// MinWrapTTL response-wraps token reads with this TTL unless the client
+	// requested wrapping itself. Zero leaves wrapping to the client.
+	MinWrapTTL time.Duration
+	// MaxConcurrentTokens bounds the token generations in flight. Zero means
+	// unlimited.
+	MaxConcurrentTokens int
+	// MaxEntryBytes bounds the stored size of an account. Zero means
+	// unlimited.
+	MaxEntryBytes int
+	// MaxOutputBytes bounds how much of each of snctl's stdout and stderr is
+	// kept. Zero means unlimited.
+	MaxOutputBytes int
+	// MaxRetries is how many times a transiently failing get-token is retried.
+	MaxRetries int
+	// RefreshSkew is how long before its expiry a cached token is refreshed,
+	// and a token's lease ends.
+	RefreshSkew time.Duration
+	// BindingFlag is the get-token flag that binds a token to a client key
+	// thumbprint. Token binding is unsupported when empty.
+	BindingFlag string
+	// AudienceFlag is the get-token flag requesting a custom audience, from
+	// audience_flag or SNCTL_AUDIENCE_FLAG. Custom audiences are unsupported
+	// in snctl mode when empty.
+	AudienceFlag string
+	// SuperuserFlag is the get-token flag requesting a superuser token, from
+	// superuser_flag or SNCTL_SUPERUSER_FLAG. Superuser tokens are unsupported
+	// when empty.
+	SuperuserFlag string
+	// GetTokenArgs is the argv template of the get-token command, in which
+	// {organization}, {cluster} and {key_file} are replaced.
+	GetTokenArgs []string
+	// SubjectFlag is the get-token flag requesting a token for a named
+	// subject, from subject_flag or SNCTL_SUBJECT_FLAG. Subject tokens are
+	// unsupported when empty.
+	SubjectFlag string
+	// ScopeFlag is the get-token flag requesting scopes, given space
+	// separated, from scope_flag or SNCTL_SCOPE_FLAG. Scoped tokens are
+	// unsupported in snctl mode when empty.
+	ScopeFlag string
+	// BreakerThreshold is the number of consecutive mint failures that open
+	// the circuit breaker, from breaker_threshold or SNCTL_BREAKER_THRESHOLD.
+	// Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit stays open before a probe, from
+	// breaker_cooldown or SNCTL_BREAKER_COOLDOWN.
+	BreakerCooldown time.Duration
+	// BreakerServeStale serves a previously cached, unexpired token while the
+	// circuit is open, from breaker_serve_stale or SNCTL_BREAKER_SERVE_STALE.
+	BreakerServeStale bool
+	// AuditClaims adds the token's decoded subject and audience to read
+	// responses so they can be correlated in audit logs.
+	AuditClaims bool
+	// TokenJSONPath is a dotted path locating the token in get-token JSON
+	// output, from token_json_path or SNCTL_TOKEN_JSON_PATH. When empty the
+	// output is taken to be the token itself.
+	TokenJSONPath string
+	// HTTPClient makes OAuth2 token requests, with the configured CA bundle
+	// and client certificate.
+	HTTPClient *http.Client
+	// KeyCipher encrypts stored key files. They are stored in plaintext, under
+	// the barrier, when nil.
+	KeyCipher keyCipher
+	// TokenField is the response key the token is returned under.
+	TokenField string
+	// ServiceURLTemplate derives a cluster's Pulsar service URL, replacing
+	// {organization} and {cluster}, from service_url_template or
+	// SNCTL_SERVICE_URL_TEMPLATE.
+	ServiceURLTemplate string
+	// Freeze blocks minting on every account during its windows.
+	Freeze *freezeSchedule
+}
+
+// forAccount returns the config for reads of the account stored as data. An
+// account's request_timeout takes precedence over the mount's, so such an
+// account gets a copy carrying its own timeout; any other gets c itself, which
+// callers must not modify.
+func (c *snctlConfig) forAccount(data map[string]interface{}) *snctlConfig {
+	timeout := storedSeconds(data, "request_timeout")
+	if timeout <= 0 {
+		return c
+	}
+	copied := *c
+	copied.RequestTimeout = timeout
+	return &copied
+}
+
+// tempDir returns the directory for temporary files: TempDir, or the system
+// temporary directory.
+func (c *snctlConfig) tempDir() string {
+	if c.TempDir != "" {
+		return c.TempDir
+	}
+	return os.TempDir()
+}
+
+const (
+	defaultRequestTimeout  = 30 * time.Second
+	defaultRefreshSkew     = 60 * time.Second
+	defaultBreakerCooldown = 30 * time.Second
+	defaultMaxRetries      = 2
+	defaultSweepInterval   = 5 * time.Minute
+	defaultTempFileMaxAge  = time.Hour
+	defaultMaxEntryBytes   = 64 * 1024
+	defaultMaxOutputBytes  = 1 << 20
+	defaultTokenField      = "token"
+)
+
+const (
+	// modeSnctl mints tokens by running snctl.
+	modeSnctl = "snctl"
+	// modeOAuth2 mints tokens with a direct OAuth2 client credentials request.
+	modeOAuth2 = "oauth2"
+)
+
+// configStorageKey is where the per-mount configuration is persisted.
+const configStorageKey = "config/snctl"
+
+// storedConfig is the per-mount configuration written to config/snctl. Unset
+// fields fall back to the plugin's environment, then to built-in defaults.
+type storedConfig struct {
+	Mode          string `json:"mode,omitempty"`
+	BinaryPath    string `json:"binary_path,omitempty"`
+	ConfigDir     string `json:"config_dir,omitempty"`
+	TempDir       string `json:"temp_dir,omitempty"`
+	TokenField    string `json:"token_field,omitempty"`
+	GetTokenArgs  string `json:"get_token_args,omitempty"`
+	AudienceFlag  string `json:"audience_flag,omitempty"`
+	SuperuserFlag string `json:"superuser_flag,omitempty"`
+	SubjectFlag   string `json:"subject_flag,omitempty"`
+	ScopeFlag     string `json:"scope_flag,omitempty"`
+	TokenJSONPath string `json:"token_json_path,omitempty"`
+	KeyFileStdin  *bool  `json:"key_file_stdin,omitempty"`
+	IsolateHome   *bool  `json:"isolate_home,omitempty"`
+	HomeFallback  *bool  `json:"home_fallback,omitempty"`
+	EagerInit     *bool  `json:"eager_init,omitempty"`
+	// RequestTimeout in whole seconds.
+	RequestTimeout int64 `json:"request_timeout,omitempty"`
+	MaxRetries     *int  `json:"max_retries,omitempty"`
+
+	MaxConcurrentTokens *int              `json:"max_concurrent_tokens,omitempty"`
+	MaxEntryBytes       *int              `json:"max_entry_bytes,omitempty"`
+	MaxOutputBytes      *int              `json:"max_output_bytes,omitempty"`
+	SnctlEnv            map[string]string `json:"snctl_env,omitempty"`
+	ValidateOnWrite     *bool             `json:"validate_on_write,omitempty"`
+
+	BreakerThreshold *int `json:"breaker_threshold,omitempty"`
+	// BreakerCooldown in whole seconds.
+	BreakerCooldown   int64 `json:"breaker_cooldown,omitempty"`
+	BreakerServeStale *bool `json:"breaker_serve_stale,omitempty"`
+	// MinWrapTTL, RefreshSkew, SweepInterval and TempFileMaxAge in whole
+	// seconds. RefreshSkew is a pointer as zero is meaningful.
+	MinWrapTTL     int64  `json:"min_wrap_ttl,omitempty"`
+	RefreshSkew    *int64 `json:"refresh_skew,omitempty"`
+	SweepInterval  int64  `json:"sweep_interval,omitempty"`
+	TempFileMaxAge int64  `json:"temp_file_max_age,omitempty"`
+
+	// Transit key encrypting stored key files, and how to reach it.
+	TransitMount   string `json:"transit_mount,omitempty"`
+	TransitKey     string `json:"transit_key,omitempty"`
+	TransitAddress string `json:"transit_address,omitempty"`
+	TransitToken   string `json:"transit_token,omitempty"`
+
+	// Service URL returned by reads with format=pulsar_client.
+	ServiceURLTemplate string `json:"service_url_template,omitempty"`
+
+	// PEM material for OAuth2 requests.
+	CACert     string `json:"ca_cert,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// newConfig builds a configuration snapshot from the environment overlaid
+// with the stored configuration, which may be nil.
+func newConfig(stored *storedConfig) (*snctlConfig, error) {
+	conf, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return conf, nil
+	}
+	switch stored.Mode {
+	case "":
+	case modeSnctl, modeOAuth2:
+		conf.Mode = stored.Mode
+	default:
+		return nil, fmt.Errorf("mode must be '%s' or '%s'", modeSnctl, modeOAuth2)
+	}
+	if stored.BinaryPath != "" {
+		conf.BinaryPath = stored.BinaryPath
+	}
+	if stored.ConfigDir != "" {
+		conf.ConfigDir = stored.ConfigDir
+	}
+	if stored.TempDir != "" {
+		conf.TempDir = stored.TempDir
+	}
+	if stored.TokenField != "" {
+		if err := validateTokenField(stored.TokenField); err != nil {
+			return nil, err
+		}
+		conf.TokenField = stored.TokenField
+	}
+	if stored.TokenJSONPath != "" {
+		if err := validateJSONPath(stored.TokenJSONPath); err != nil {
+			return nil, errwrap.Wrapf("token_json_path is invalid: {{err}}", err)
+		}
+		conf.TokenJSONPath = stored.TokenJSONPath
+	}
+	if stored.ServiceURLTemplate != "" {
+		if err := validateServiceURLTemplate(stored.ServiceURLTemplate); err != nil {
+			return nil, err
+		}
+		conf.ServiceURLTemplate = stored.ServiceURLTemplate
+	}
+	if stored.GetTokenArgs != "" {
+		if conf.GetTokenArgs, err = parseGetTokenArgs(stored.GetTokenArgs); err != nil {
+			return nil, err
+		}
+	}
+	if stored.AudienceFlag != "" {
+		if err := validateFlag("audience_flag", stored.AudienceFlag); err != nil {
+			return nil, err
+		}
+		conf.AudienceFlag = stored.AudienceFlag
+	}
+	if stored.SuperuserFlag != "" {
+		if err := validateFlag("superuser_flag", stored.SuperuserFlag); err != nil {
+			return nil, err
+		}
+		conf.SuperuserFlag = stored.SuperuserFlag
+	}
+	if stored.SubjectFlag != "" {
+		if err := validateFlag("subject_flag", stored.SubjectFlag); err != nil {
+			return nil, err
+		}
+		conf.SubjectFlag = stored.SubjectFlag
+	}
+	if stored.ScopeFlag != "" {
+		if err := validateFlag("scope_flag", stored.ScopeFlag); err != nil {
+			return nil, err
+		}
+		conf.ScopeFlag = stored.ScopeFlag
+	}
+	if len(stored.SnctlEnv) > 0 {
+		for name := range stored.SnctlEnv {
+			if err := validateEnvName(name); err != nil {
+				return nil, err
+			}
+		}
+		conf.SnctlEnv = stored.SnctlEnv
+	}
+	if stored.KeyFileStdin != nil {
+		if *stored.KeyFileStdin && !stdinSupported() {
+			return nil, fmt.Errorf("key_file_stdin requires %s, which this platform lacks", stdinKeyFile)
+		}
+		conf.KeyFileStdin = *stored.KeyFileStdin
+	}
+	if stored.IsolateHome != nil {
+		conf.IsolateHome = *stored.IsolateHome
+	}
+	if stored.HomeFallback != nil {
+		conf.HomeFallback = *stored.HomeFallback
+	}
+	if stored.EagerInit != nil {
+		conf.EagerInit = *stored.EagerInit
+	}
+	if stored.RequestTimeout > 0 {
+		conf.RequestTimeout = time.Duration(stored.RequestTimeout) * time.Second
+	}
+	if stored.MaxRetries != nil {
+		conf.MaxRetries = *stored.MaxRetries
+	}
+	if stored.RefreshSkew != nil {
+		conf.RefreshSkew = time.Duration(*stored.RefreshSkew) * time.Second
+	}
+	if stored.SweepInterval > 0 {
+		conf.SweepInterval = time.Duration(stored.SweepInterval) * time.Second
+	}
+	if stored.TempFileMaxAge > 0 {
+		conf.TempFileMaxAge = time.Duration(stored.TempFileMaxAge) * time.Second
+	}
+	if stored.ValidateOnWrite != nil {
+		conf.ValidateOnWrite = *stored.ValidateOnWrite
+	}
+	if stored.MinWrapTTL > 0 {
+		conf.MinWrapTTL = time.Duration(stored.MinWrapTTL) * time.Second
+	}
+	if stored.MaxConcurrentTokens != nil {
+		conf.MaxConcurrentTokens = *stored.MaxConcurrentTokens
+	}
+	if stored.MaxEntryBytes != nil {
+		conf.MaxEntryBytes = *stored.MaxEntryBytes
+	}
+	if stored.MaxOutputBytes != nil {
+		conf.MaxOutputBytes = *stored.MaxOutputBytes
+	}
+	if stored.BreakerThreshold != nil {
+		conf.BreakerThreshold = *stored.BreakerThreshold
+	}
+	if stored.BreakerCooldown > 0 {
+		conf.BreakerCooldown = time.Duration(stored.BreakerCooldown) * time.Second
+	}
+	if stored.BreakerServeStale != nil {
+		conf.BreakerServeStale = *stored.BreakerServeStale
+	}
+	if conf.HTTPClient, err = newHTTPClient(stored.CACert, stored.ClientCert, stored.ClientKey); err != nil {
+		return nil, err
+	}
+	if stored.TransitMount != "" || stored.TransitKey != "" {
+		if stored.TransitMount == "" || stored.TransitKey == "" || stored.TransitToken == "" {
+			return nil, fmt.Errorf("transit_mount, transit_key and transit_token must be set together")
+		}
+		if conf.KeyCipher, err = newTransitCipher(stored.TransitAddress, stored.TransitToken, stored.TransitMount, stored.TransitKey); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// deniedEnvNames and deniedEnvPrefixes are variables snctl_env cannot set,
+// as they change which code snctl loads and runs.
+var (
+	deniedEnvNames    = []string{"PATH", "IFS", "ENV", "BASH_ENV", "GCONV_PATH", "LOCPATH", "NLSPATH", "GODEBUG"}
+	deniedEnvPrefixes = []string{"LD_", "DYLD_"}
+)
+
+// validateEnvName checks that name can be set in snctl's environment.
+func validateEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("snctl_env has an invalid variable name '%s'", name)
+	}
+	if name == "HOME" {
+		return fmt.Errorf("snctl_env cannot set HOME; use config_dir instead")
+	}
+	if containsString(deniedEnvNames, name) {
+		return fmt.Errorf("snctl_env cannot set %s", name)
+	}
+	for _, prefix := range deniedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("snctl_env cannot set %s", name)
+		}
+	}
+	return nil
+}
+
+// envNames returns the sorted variable names of env, leaving out the values.
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readStoredConfig returns the persisted configuration, or nil if none has
+// been written.
+func readStoredConfig(ctx context.Context, s logical.Storage) (*storedConfig, error) {
+	ent, err := s.Get(ctx, configStorageKey)
+	if err != nil {
+		return nil, errwrap.Wrapf("Reading config from storage failed: {{err}}", err)
+	}
+	if ent == nil {
+		return nil, nil
+	}
+	var stored storedConfig
+	if err := ent.DecodeJSON(&stored); err != nil {
+		return nil, errwrap.Wrapf("json decoding config failed: {{err}}", err)
+	}
+	return &stored, nil
+}
+
+// loadConfig rebuilds the configuration snapshot from storage and swaps it in.
+func (b *backend) loadConfig(ctx context.Context, s logical.Storage) error {
+	stored, err := readStoredConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	conf, err := newConfig(stored)
+	if err != nil {
+		return err
+	}
+	b.setConfig(conf)
+	return nil
+}
+
+// configFromEnv builds a configuration from the plugin's environment.
+func configFromEnv() (*snctlConfig, error) {
+	freeze, err := parseFreezeSchedule(strings.Split(os.Getenv("SNCTL_FREEZE_WINDOWS"), ";"), os.Getenv("SNCTL_FREEZE_TIMEZONE"))
+	if err != nil {
+		return nil, errwrap.Wrapf("SNCTL_FREEZE_WINDOWS is invalid: {{err}}", err)
+	}
+
+	tokenJSONPath := os.Getenv("SNCTL_TOKEN_JSON_PATH")
+	if err := validateJSONPath(tokenJSONPath); err != nil {
+		return nil, errwrap.Wrapf("SNCTL_TOKEN_JSON_PATH is invalid: {{err}}", err)
+	}
+
+	serviceURLTemplate := os.Getenv("SNCTL_SERVICE_URL_TEMPLATE")
+	if serviceURLTemplate != "" {
+		if err := validateServiceURLTemplate(serviceURLTemplate); err != nil {
+			return nil, errwrap.Wrapf("SNCTL_SERVICE_URL_TEMPLATE is invalid: {{err}}", err)
+		}
+	}
+
+	getTokenArgs := defaultGetTokenArgs
+	if args, ok := os.LookupEnv("SNCTL_GET_TOKEN_ARGS"); ok && strings.TrimSpace(args) != "" {
+		if getTokenArgs, err = parseGetTokenArgs(args); err != nil {
+			return nil, errwrap.Wrapf("SNCTL_GET_TOKEN_ARGS is invalid: {{err}}", err)
+		}
+	}
+
+	tokenField := defaultTokenField
+	if field, ok := os.LookupEnv("SNCTL_TOKEN_FIELD"); ok && field != "" {
+		if err := validateTokenField(field); err != nil {
+			return nil, errwrap.Wrapf("SNCTL_TOKEN_FIELD is invalid: {{err}}", err)
+		}
+		tokenField = field
+	}
+
+	return &snctlConfig{
+		Mode:           modeSnctl,
+		BinaryPath:     GetSnctl(),
+		ConfigDir:      os.Getenv("SNCTL_CONFIG_DIR"),
+		TempDir:        os.Getenv("SNCTL_TEMP_DIR"),
+		SweepInterval:  envDuration("SNCTL_SWEEP_INTERVAL", defaultSweepInterval),
+		TempFileMaxAge: envDuration("SNCTL_TEMP_FILE_MAX_AGE", defaultTempFileMaxAge),
+		KeyFileStdin:   envBool("SNCTL_KEY_FILE_STDIN", false) && stdinSupported(),
+		IsolateHome:    envBool("SNCTL_ISOLATE_HOME", false),
+		HomeFallback:   homeFallbackFromEnv(),
+		EagerInit:      envBool("SNCTL_EAGER_INIT", false),
+		RequestTimeout: envDuration("SNCTL_REQUEST_TIMEOUT", defaultRequestTimeout),
+		MaxRetries:     envInt("SNCTL_MAX_RETRIES", defaultMaxRetries),
+		RefreshSkew:    envDuration("SNCTL_REFRESH_SKEW", defaultRefreshSkew),
+		BindingFlag:    os.Getenv("SNCTL_BINDING_FLAG"),
+		AudienceFlag:   os.Getenv("SNCTL_AUDIENCE_FLAG"),
+		SuperuserFlag:  os.Getenv("SNCTL_SUPERUSER_FLAG"),
+		SubjectFlag:    os.Getenv("SNCTL_SUBJECT_FLAG"),
+		ScopeFlag:      os.Getenv("SNCTL_SCOPE_FLAG"),
+		GetTokenArgs:   getTokenArgs,
+
+		MaxConcurrentTokens: envInt("SNCTL_MAX_CONCURRENT_TOKENS", 0),
+		MaxEntryBytes:       envInt("SNCTL_MAX_ENTRY_BYTES", defaultMaxEntryBytes),
+		MaxOutputBytes:      envInt("SNCTL_MAX_OUTPUT_BYTES", defaultMaxOutputBytes),
+		MinWrapTTL:          envDuration("SNCTL_MIN_WRAP_TTL", 0),
+		ValidateOnWrite:     envBool("SNCTL_VALIDATE_ON_WRITE", false),
+
+		BreakerThreshold:  envInt("SNCTL_BREAKER_THRESHOLD", 0),
+		BreakerCooldown:   envDuration("SNCTL_BREAKER_COOLDOWN", defaultBreakerCooldown),
+		BreakerServeStale: envBool("SNCTL_BREAKER_SERVE_STALE", false),
+
+		AuditClaims:        envBool("SNCTL_AUDIT_CLAIMS", false),
+		TokenJSONPath:      tokenJSONPath,
+		HTTPClient:         http.DefaultClient,
+		TokenField:         tokenField,
+		ServiceURLTemplate: serviceURLTemplate,
+		Freeze:             freeze,
+	}, nil
+}
+
+// defaultGetTokenArgs is the get-token command of current snctl releases.
+var defaultGetTokenArgs = []string{"-n", "{organization}", "auth", "get-token", "{cluster}", "-f", "{key_file}"}
+
+// getTokenPlaceholders are the placeholders of a get-token argv template,
+// every one of which must be used.
+var getTokenPlaceholders = []string{"{organization}", "{cluster}", "{key_file}"}
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// parseGetTokenArgs splits a get-token argv template on whitespace and
+// checks its placeholders.
+func parseGetTokenArgs(template string) ([]string, error) {
+	args := strings.Fields(template)
+	joined := strings.Join(args, " ")
+	for _, placeholder := range getTokenPlaceholders {
+		if !strings.Contains(joined, placeholder) {
+			return nil, fmt.Errorf("get_token_args must contain %s", placeholder)
+		}
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(joined, -1) {
+		if !containsString(getTokenPlaceholders, placeholder) {
+			return nil, fmt.Errorf("get_token_args has an unknown placeholder %s", placeholder)
+		}
+	}
+	return args, nil
+}
+
+// expandGetTokenArgs fills in the get-token argv template.
+func expandGetTokenArgs(template []string, org string, cluster string, keyFile string) []string {
+	replacer := strings.NewReplacer("{organization}", org, "{cluster}", cluster, "{key_file}", keyFile)
+	args := make([]string, len(template))
+	for i, arg := range template {
+		args[i] = replacer.Replace(arg)
+	}
+	return args
+}
+
+// tokenFieldPattern matches the identifiers accepted as token_field.
+var tokenFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// reservedResponseFields are the other keys of a token response, which
+// token_field must not shadow.
+var reservedResponseFields = []string{
+	"binding", "claims", "data", "error", "expires_at", "issued_at", "lease_ttl_seconds", "pulsar_client",
+	"request_id", "role", "token_audience", "token_subject", "ttl_seconds", "warnings",
+}
+
+// validateTokenField checks that field can name the token in responses.
+func validateTokenField(field string) error {
+	if !tokenFieldPattern.MatchString(field) {
+		return fmt.Errorf("token_field '%s' must be a letter or underscore followed by letters, digits or underscores", field)
+	}
+	if containsString(reservedResponseFields, field) {
+		return fmt.Errorf("token_field '%s' is already a response field", field)
+	}
+	return nil
+}
+
+// flagPattern matches a command line flag such as --audience.
+var flagPattern = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// validateFlag checks that the config field names a single snctl flag.
+func validateFlag(field, flag string) error {
+	if !flagPattern.MatchString(flag) {
+		return fmt.Errorf("%s '%s' must be a flag such as --%s", field, flag, strings.TrimSuffix(field, "_flag"))
+	}
+	return nil
+}
+
+// homeFallbackFromEnv reads SNCTL_HOME_FALLBACK. The fallback is disabled
+// when it is unset, and a value that is not a boolean disables it, so that a
+// mistyped "false" never turns it on.
+func homeFallbackFromEnv() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("SNCTL_HOME_FALLBACK"))
+	return err == nil && enabled
+}
+
+func envInt(name string, def int) int {
+	if v, ok := os.LookupEnv(name); ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func envBool(name string, def bool) bool {
+	if v, ok := os.LookupEnv(name); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// envDuration parses a duration such as "30s", or a plain number of seconds.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(name); ok {
+		if d, err := parseutil.ParseDurationSecond(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// config returns the current configuration snapshot.
+func (b *backend) config() *snctlConfig {
+	return b.conf.Load()
+}
+
+// setConfig atomically replaces the configuration snapshot.
+func (b *backend) setConfig(conf *snctlConfig) {
+	b.conf.Store(conf)
+}
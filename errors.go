@@ -0,0 +1,160 @@
+package streamnative
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Token generation failures are classified with these errors, so that callers
+// can tell them apart with errors.Is.
+var (
+	// ErrSnctlNotFound means the snctl binary could not be found or run.
+	ErrSnctlNotFound = errors.New("snctl not found")
+	// ErrInvalidCredential means the service account key was rejected.
+	ErrInvalidCredential = errors.New("invalid credential")
+	// ErrTransient means a network or server-side failure which may succeed
+	// when retried.
+	ErrTransient = errors.New("transient failure")
+	// ErrInvalidRequest means StreamNative reported that the requested
+	// organization or cluster does not exist.
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// classifiedError marks an error with one of the classification errors while
+// keeping its message.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// classify marks err as being of kind.
+func classify(kind error, err error) error {
+	return &classifiedError{kind: kind, err: err}
+}
+
+// snctlNotFoundError reports that the snctl binary at path cannot be found,
+// and how to fix that.
+func snctlNotFoundError(path string) error {
+	return classify(ErrSnctlNotFound, fmt.Errorf("snctl binary not found at '%s'; set SNCTL_PATH or binary_path, or install snctl", path))
+}
+
+// classifySnctlError classifies err, describing a failed snctl invocation,
+// from its message.
+func classifySnctlError(err error) error {
+	msg := err.Error()
+	switch {
+	case authRejectionPattern.MatchString(msg):
+		return classify(ErrInvalidCredential, err)
+	case transientPattern.MatchString(msg):
+		return classify(ErrTransient, err)
+	case notFoundPattern.MatchString(msg):
+		return classify(ErrInvalidRequest, err)
+	}
+	return err
+}
+
+// SnctlExitCode returns the exit code of the failed snctl process behind err,
+// if snctl ran and exited unsuccessfully.
+func SnctlExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// withExitCode adds the snctl exit code behind err, if any, to data as
+// snctl_exit_code.
+func withExitCode(data map[string]interface{}, err error) map[string]interface{} {
+	if code, ok := SnctlExitCode(err); ok {
+		data["snctl_exit_code"] = code
+	}
+	return data
+}
+
+// readErrorResponse returns the failure of a token read. Vault returns only
+// the message of an error, so a failure of snctl itself is instead returned as
+// a response with the failure's status whose data also carries
+// snctl_exit_code.
+func readErrorResponse(req *logical.Request, err error) (*logical.Response, error) {
+	if _, ok := SnctlExitCode(err); !ok {
+		return nil, err
+	}
+	status := http.StatusInternalServerError
+	var coded logical.HTTPCodedError
+	if errors.As(err, &coded) {
+		status = coded.Code()
+	}
+	return logical.RespondWithStatusCode(&logical.Response{
+		Data: withExitCode(map[string]interface{}{"error": err.Error()}, err),
+	}, req, status)
+}
+
+// codedError is an error with the HTTP status returned to the client. Unlike
+// logical.CodedError it keeps the error it wraps, so the failure can still be
+// inspected, for example for its snctl exit code.
+type codedError struct {
+	status int
+	err    error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+// Code implements logical.HTTPCodedError.
+func (e *codedError) Code() int {
+	return e.status
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// mintErrorResponse maps a token generation failure to the status returned
+// to the client: 503 for transient failures, 502 for a credential the issuer
+// rejected and 400 for an organization or cluster that does not exist. Other
+// failures remain internal errors.
+func mintErrorResponse(err error) error {
+	switch {
+	case errors.Is(err, ErrTransient):
+		return &codedError{status: http.StatusServiceUnavailable, err: err}
+	case errors.Is(err, ErrInvalidCredential):
+		return &codedError{status: http.StatusBadGateway, err: err}
+	case errors.Is(err, ErrInvalidRequest):
+		return &codedError{status: http.StatusBadRequest, err: err}
+	}
+	return err
+}
+
+// errorClass names the kind of a failed token read, for logs.
+func errorClass(err error) string {
+	var coded logical.HTTPCodedError
+	switch {
+	case errors.Is(err, ErrSnctlNotFound):
+		return "snctl_not_found"
+	case errors.Is(err, ErrInvalidCredential):
+		return "invalid_credential"
+	case errors.Is(err, ErrTransient):
+		return "transient"
+	case errors.Is(err, ErrInvalidRequest):
+		return "invalid_request"
+	case errors.As(err, &coded) && coded.Code() == http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return "internal"
+}
+
+var _ logical.HTTPCodedError = (*codedError)(nil)
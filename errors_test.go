@@ -0,0 +1,214 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestClassifySnctlError(t *testing.T) {
+	for msg, want := range map[string]error{
+		"snctl get-token failed: exit status 1: 401 Unauthorized":                                                ErrInvalidCredential,
+		"snctl activate failed: exit status 1: error: invalid_client":                                            ErrInvalidCredential,
+		"snctl get-token failed: exit status 1: 503 Service Unavailable":                                         ErrTransient,
+		"snctl get-token failed: exit status 1: dial tcp: connection refused":                                    ErrTransient,
+		"snctl get-token failed: exit status 1: cluster not found":                                               ErrInvalidRequest,
+		"snctl get-token failed: exit status 1: pulsarclusters.cloud.streamnative.io \"test-cluster\" not found": ErrInvalidRequest,
+		// A rejection reported by a failing gateway is still a rejection.
+		"snctl get-token failed: exit status 1: 502: invalid_grant": ErrInvalidCredential,
+	} {
+		if err := classifySnctlError(errors.New(msg)); !errors.Is(err, want) {
+			t.Errorf("%q was classified as %v, want %v", msg, err, want)
+		} else if err.Error() != msg {
+			t.Errorf("classifying changed the message to %q", err.Error())
+		}
+	}
+	if err := classifySnctlError(errors.New("snctl get-token failed: exit status 2")); errors.Is(err, ErrTransient) || errors.Is(err, ErrInvalidCredential) || errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("an unexplained failure was classified: %v", err)
+	}
+	// snctl's own missing files and endpoints are not the request's fault.
+	for _, msg := range []string{
+		"snctl get-token failed: exit status 1: 404 page not found",
+		"snctl get-token failed: exit status 1: error: config file does not exist",
+	} {
+		if err := classifySnctlError(errors.New(msg)); errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%q was blamed on the request", msg)
+		}
+	}
+}
+
+func TestReadErrorsClassified(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stderr string
+		want   error
+		status int
+	}{
+		{"rejected", "error: 401 unauthorized", ErrInvalidCredential, http.StatusBadGateway},
+		{"transient", "error: 503 service unavailable", ErrTransient, http.StatusServiceUnavailable},
+		{"unknown cluster", "error: cluster test-cluster not found", ErrInvalidRequest, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+			tb.writeRole(t, "failing", nil)
+			failGetToken(tb, 1, tc.stderr)
+
+			_, err := tb.request(t, logical.ReadOperation, "creds/failing", nil)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			var coded logical.HTTPCodedError
+			if !errors.As(err, &coded) || coded.Code() != tc.status {
+				t.Fatalf("expected status %d, got %v", tc.status, err)
+			}
+		})
+	}
+
+	t.Run("snctl not found", func(t *testing.T) {
+		tb := getTestBackend(t)
+		tb.writeRole(t, "failing", nil)
+		tb.runner = func(cmd *exec.Cmd) error {
+			return fmt.Errorf("exec: %q: %w", cmd.Path, exec.ErrNotFound)
+		}
+		_, err := tb.request(t, logical.ReadOperation, "creds/failing", nil)
+		if !errors.Is(err, ErrSnctlNotFound) {
+			t.Fatalf("expected ErrSnctlNotFound, got %v", err)
+		}
+	})
+}
+
+// responseStatus returns the HTTP status Vault would answer a request with.
+func responseStatus(req *logical.Request, resp *logical.Response, err error) int {
+	if status, data := rawResponse(resp); data != nil {
+		return status
+	}
+	if status, _ := logical.RespondErrorCommon(req, resp, err); status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
+
+func TestReadStatusClass(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	tb.writeRole(t, "restricted", map[string]interface{}{"allowed_clusters": "test-cluster"})
+	// An account stored before organization was required.
+	buf, err := json.Marshal(map[string]interface{}{"key-file": testKeyFile, "default_cluster": "test-cluster", "version": accountVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tb.storage.Put(context.Background(), &logical.StorageEntry{Key: "orgless", Value: buf}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		path string
+		data map[string]interface{}
+		want string
+	}{
+		"missing organization": {"orgless", nil, "No 'organization' set"},
+		"empty cluster":        {"creds/restricted", map[string]interface{}{"cluster": " "}, "'cluster' must be a non-empty string"},
+		"disallowed cluster":   {"creds/restricted", map[string]interface{}{"cluster": "other-cluster"}, "allowed_clusters"},
+		"invalid format":       {"creds/restricted", map[string]interface{}{"format": "xml"}, "format"},
+		"invalid ttl":          {"creds/restricted", map[string]interface{}{"ttl": "soon"}, "ttl"},
+	} {
+		req := &logical.Request{Operation: logical.ReadOperation, Path: tc.path, Data: tc.data, Storage: tb.storage}
+		resp, err := tb.HandleRequest(context.Background(), req)
+		if status := responseStatus(req, resp, err); status != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d (%s)", name, status, errorText(resp, err))
+		} else if msg := errorText(resp, err); !strings.Contains(msg, tc.want) {
+			t.Errorf("%s: expected an error mentioning %q, got %q", name, tc.want, msg)
+		}
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != 0 {
+		t.Fatalf("expected invalid reads not to run snctl, got %d", n)
+	}
+
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "panic: runtime error: index out of range", ExitCode: 2})
+	req := &logical.Request{Operation: logical.ReadOperation, Path: "creds/restricted", Storage: tb.storage}
+	resp, err := tb.HandleRequest(context.Background(), req)
+	if status := responseStatus(req, resp, err); status != http.StatusInternalServerError {
+		t.Fatalf("expected an snctl crash to be an internal error, got %d (%s)", status, errorText(resp, err))
+	}
+}
+
+func TestSnctlExitCodeReported(t *testing.T) {
+	for _, code := range []int{1, 2} {
+		t.Run(fmt.Sprint("exit ", code), func(t *testing.T) {
+			tb := getTestBackend(t)
+			stubSnctl(t, tb, fmt.Sprintf(`case "$*" in *get-token*) echo "error: unexpected argument" >&2; exit %d ;; esac`, code))
+			tb.writeRole(t, "failing", nil)
+			tb.mustRequest(t, logical.UpdateOperation, "legacy", testAccount(testKeyFile))
+
+			for _, path := range []string{"creds/failing", "legacy"} {
+				resp, err := tb.request(t, logical.ReadOperation, path, nil)
+				if err != nil {
+					t.Fatalf("%s: expected the failure as a response, got %v", path, err)
+				}
+				status, data := rawResponse(resp)
+				if status != http.StatusInternalServerError {
+					t.Fatalf("%s: expected status 500, got %d", path, status)
+				}
+				if data["snctl_exit_code"] != float64(code) {
+					t.Fatalf("%s: expected snctl_exit_code %d, got %v", path, code, data)
+				}
+				if msg, _ := data["error"].(string); !strings.Contains(msg, "error: unexpected argument") {
+					t.Fatalf("%s: expected the human-readable message, got %q", path, msg)
+				}
+			}
+
+			resp := tb.mustRequest(t, logical.UpdateOperation, "validate", map[string]interface{}{
+				"key-file":     testKeyFile,
+				"organization": "test-org",
+				"cluster":      "test-cluster",
+			})
+			if resp.Data["snctl_exit_code"] != code {
+				t.Fatalf("expected validate to report exit code %d, got %v", code, resp.Data)
+			}
+		})
+	}
+}
+
+func TestFakeSnctlExitCode(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "error: 401 Unauthorized", ExitCode: 2})
+	tb.writeRole(t, "failing", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/failing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, data := rawResponse(resp); status != http.StatusBadGateway || data["snctl_exit_code"] != float64(2) {
+		t.Fatalf("expected a rejected credential with exit code 2, got %d %v", status, data)
+	}
+}
+
+func TestSnctlBinaryMissing(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.runner = runCommand
+	missing := filepath.Join(t.TempDir(), "snctl")
+	tb.setEnv(t, "SNCTL_PATH", missing)
+	tb.writeRole(t, "unrunnable", nil)
+
+	for _, path := range []string{"creds/unrunnable", "apikey/unrunnable"} {
+		resp, err := tb.request(t, logical.ReadOperation, path, nil)
+		want := fmt.Sprintf("snctl binary not found at '%s'; set SNCTL_PATH or binary_path, or install snctl", missing)
+		if msg := errorText(resp, err); !strings.Contains(msg, want) {
+			t.Fatalf("%s: expected the actionable message, got %q", path, msg)
+		}
+		if !errors.Is(err, ErrSnctlNotFound) {
+			t.Fatalf("%s: expected ErrSnctlNotFound, got %v", path, err)
+		}
+	}
+}
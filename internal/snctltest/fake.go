@@ -0,0 +1,139 @@
+// Package snctltest provides a fake snctl for tests. Its Run method stands in
+// for the backend's command runner, so handlers can be exercised without an
+// snctl binary or a ~/.snctl directory.
+package snctltest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Response is what the fake prints for a subcommand, and how it exits.
+type Response struct {
+	Stdout string
+	Stderr string
+	// ExitCode fails the invocation with an *exec.ExitError when non-zero.
+	ExitCode int
+}
+
+// Call records one invocation of the fake.
+type Call struct {
+	Args  []string
+	Env   []string
+	Stdin []byte
+}
+
+// Fake is a scripted snctl. Subcommands without a response fail, except
+// `config init` and `auth activate-service-account`, which succeed silently
+// unless given another response. A successful `config init` creates
+// ~/.snctl under the HOME the command runs with, as snctl does.
+type Fake struct {
+	mu        sync.Mutex
+	responses map[string]Response
+	calls     []Call
+}
+
+// New returns a fake snctl.
+func New() *Fake {
+	return &Fake{
+		responses: map[string]Response{
+			"config init":                   {},
+			"auth activate-service-account": {},
+		},
+	}
+}
+
+// On sets the response to the subcommand, such as "auth get-token".
+func (f *Fake) On(subcommand string, resp Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[subcommand] = resp
+}
+
+// Calls returns the invocations of the subcommand so far.
+func (f *Fake) Calls(subcommand string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []Call
+	for _, call := range f.calls {
+		if matches(call.Args, subcommand) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Run plays the scripted response to cmd, in place of running it.
+func (f *Fake) Run(cmd *exec.Cmd) error {
+	call := Call{
+		Args: append([]string(nil), cmd.Args[1:]...),
+		Env:  append([]string(nil), cmd.Env...),
+	}
+	if cmd.Stdin != nil {
+		stdin, err := io.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		call.Stdin = stdin
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var resp Response
+	found := false
+	for subcommand, candidate := range f.responses {
+		if matches(call.Args, subcommand) {
+			resp, found = candidate, true
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("fake snctl: unexpected invocation %q", call.Args)
+	}
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, resp.Stdout)
+	}
+	if cmd.Stderr != nil {
+		io.WriteString(cmd.Stderr, resp.Stderr)
+	}
+	if resp.ExitCode != 0 {
+		return exitError(resp.ExitCode)
+	}
+	if matches(call.Args, "config init") {
+		return os.MkdirAll(filepath.Join(home(cmd.Env), ".snctl"), 0700)
+	}
+	return nil
+}
+
+// exitError returns the *exec.ExitError of a process exiting with code, as
+// running snctl would.
+func exitError(code int) error {
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("fake snctl: exiting with status %d failed: %v", code, err)
+	}
+	return err
+}
+
+// home returns the HOME of the environment env, defaulting to the process's.
+func home(env []string) string {
+	dir := os.Getenv("HOME")
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "HOME="); ok {
+			dir = value
+		}
+	}
+	return dir
+}
+
+// matches reports whether args contain the words of subcommand in order.
+func matches(args []string, subcommand string) bool {
+	return strings.Contains(" "+strings.Join(args, " ")+" ", " "+subcommand+" ")
+}
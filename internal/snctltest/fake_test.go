@@ -0,0 +1,50 @@
+package snctltest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+)
+
+func ExampleFake() {
+	fake := snctltest.New()
+	fake.On("auth get-token", snctltest.Response{Stdout: "test-token"})
+
+	var out bytes.Buffer
+	cmd := exec.Command("snctl", "-n", "test-org", "auth", "get-token", "test-cluster")
+	cmd.Stdout = &out
+	if err := fake.Run(cmd); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(out.String())
+	fmt.Println(len(fake.Calls("auth get-token")), fake.Calls("auth get-token")[0].Args)
+	// Output:
+	// test-token
+	// 1 [-n test-org auth get-token test-cluster]
+}
+
+func ExampleFake_failure() {
+	fake := snctltest.New()
+	fake.On("auth get-token", snctltest.Response{Stderr: "error: 401 Unauthorized", ExitCode: 2})
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("snctl", "auth", "get-token", "test-cluster")
+	cmd.Stderr = &stderr
+	err := fake.Run(cmd)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Println(exitErr.ExitCode(), stderr.String())
+	}
+	// Output:
+	// 2 error: 401 Unauthorized
+}
+
+func ExampleFake_unexpected() {
+	fake := snctltest.New()
+	fmt.Println(fake.Run(exec.Command("snctl", "delete", "apikey", "test-key")))
+	// Output:
+	// fake snctl: unexpected invocation ["delete" "apikey" "test-key"]
+}
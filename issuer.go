@@ -0,0 +1,78 @@
+package streamnative
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+)
+
+// accountIssuers returns the distinct issuers an account's tokens are
+// requested from: its issuer_url, or else those of its key files.
+func accountIssuers(data map[string]interface{}) []string {
+	if issuer, _ := data["issuer_url"].(string); issuer != "" {
+		return []string{issuer}
+	}
+	var issuers []string
+	for _, keyFile := range accountKeyFiles(data) {
+		var key serviceAccountKey
+		if err := jsonutil.DecodeJSON([]byte(keyFile), &key); err != nil || key.IssuerURL == "" {
+			continue
+		}
+		if !containsString(issuers, key.IssuerURL) {
+			issuers = append(issuers, key.IssuerURL)
+		}
+	}
+	return issuers
+}
+
+// checkIssuerReachable fetches the issuer's OpenID discovery document, so
+// that an unresolvable host, an untrusted certificate or a blocked connection
+// is reported when an account is written rather than on its first read. Any
+// response other than a server error shows the issuer is reachable.
+func checkIssuerReachable(ctx context.Context, conf *snctlConfig, issuer string) error {
+	reqCtx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+	defer cancel()
+	discoveryURL := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("issuer '%s' is not a valid URL: {{err}}", issuer), err)
+	}
+	resp, err := conf.HTTPClient.Do(req)
+	if err != nil {
+		return describeIssuerError(issuer, conf, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("issuer '%s' is unavailable: its discovery endpoint returned status %d", issuer, resp.StatusCode)
+	}
+	return nil
+}
+
+// describeIssuerError says whether a failed request to issuer failed on DNS,
+// TLS, the connection, or the timeout.
+func describeIssuerError(issuer string, conf *snctlConfig, err error) error {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Errorf("issuer '%s' cannot be resolved: %w", issuer, err)
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &recordErr):
+		return fmt.Errorf("TLS handshake with issuer '%s' failed: %w", issuer, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("issuer '%s' did not respond within %s: %w", issuer, conf.RequestTimeout, err)
+	}
+	return fmt.Errorf("issuer '%s' is not reachable: %w", issuer, err)
+}
@@ -0,0 +1,68 @@
+package streamnative
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestValidateOnWriteIssuer(t *testing.T) {
+	// A listener closed before use leaves a port that refuses connections.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "https://" + listener.Addr().String()
+	listener.Close()
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+	// slow is trusted through ca_cert but never answers in time.
+	slow := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	slowCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: slow.Certificate().Raw}))
+
+	for name, tc := range map[string]struct {
+		issuer string
+		config map[string]interface{}
+		want   string
+	}{
+		"unresolvable":       {"https://issuer.invalid", nil, "issuer 'https://issuer.invalid' cannot be resolved"},
+		"connection refused": {refused, nil, "issuer '" + refused + "' is not reachable"},
+		"untrusted":          {untrusted.URL, nil, "TLS handshake with issuer '" + untrusted.URL + "' failed"},
+		"timeout": {slow.URL, map[string]interface{}{"ca_cert": slowCA, "request_timeout": "1s"},
+			"issuer '" + slow.URL + "' did not respond within 1s"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			config := map[string]interface{}{
+				"validate_on_write": true,
+				"request_timeout":   "5s",
+			}
+			for k, v := range tc.config {
+				config[k] = v
+			}
+			tb.mustRequest(t, logical.UpdateOperation, "config/snctl", config)
+			resp, err := tb.request(t, logical.UpdateOperation, "roles/unreachable", map[string]interface{}{
+				"key-file":        strings.ReplaceAll(testKeyFile, "https://auth.streamnative.cloud", tc.issuer),
+				"organization":    "test-org",
+				"default_cluster": "test-cluster",
+			})
+			if msg := errorText(resp, err); !strings.Contains(msg, tc.want) {
+				t.Fatalf("expected %q, got %q", tc.want, msg)
+			}
+			if calls := tb.snctl.Calls("auth get-token"); len(calls) != 0 {
+				t.Fatalf("expected no token to be minted, got %d", len(calls))
+			}
+			if resp := tb.mustRequest(t, logical.ReadOperation, "roles/unreachable", nil); resp != nil {
+				t.Fatalf("the rejected role was stored: %v", resp.Data)
+			}
+		})
+	}
+}
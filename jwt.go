@@ -0,0 +1,130 @@
+package streamnative
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+)
+
+// decodeJwtClaims decodes the payload of a compact JWT. The signature is not
+// verified; StreamNative remains the authority on the token's validity.
+func decodeJwtClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a compact JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errwrap.Wrapf("JWT payload is not base64url: {{err}}", err)
+	}
+	var claims map[string]interface{}
+	if err := jsonutil.DecodeJSON(payload, &claims); err != nil {
+		return nil, errwrap.Wrapf("JWT payload is not JSON: {{err}}", err)
+	}
+	return claims, nil
+}
+
+// looksLikeJwt reports whether s has the shape of a compact JWT: three
+// non-empty base64url segments separated by dots.
+func looksLikeJwt(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '=') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseTokenOutput extracts the token from get-token output, dropping
+// surrounding whitespace and any log lines printed before it. The last line
+// shaped like a JWT wins; failing that, the last non-empty line.
+func parseTokenOutput(out []byte) string {
+	lines := strings.Split(string(out), "\n")
+	last := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if looksLikeJwt(line) {
+			return line
+		}
+		if last == "" {
+			last = line
+		}
+	}
+	return last
+}
+
+// numericClaim reads a NumericDate claim such as exp or iat.
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := n.Int64()
+	if err != nil {
+		f, err := n.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		secs = int64(f)
+	}
+	return time.Unix(secs, 0), true
+}
+
+// audienceClaim returns the aud claim, which may be a string or an array.
+func audienceClaim(claims map[string]interface{}) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var out []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// validateToken checks that a minted token is a well-formed JWT that has not
+// expired and, when audience is set, that it was issued for that audience.
+func validateToken(token string, audience string) error {
+	claims, err := decodeJwtClaims(token)
+	if err != nil {
+		return err
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if !time.Now().Before(exp) {
+		return fmt.Errorf("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if audience != "" {
+		for _, aud := range audienceClaim(claims) {
+			if aud == audience {
+				return nil
+			}
+		}
+		return fmt.Errorf("token audience does not include '%s'", audience)
+	}
+	return nil
+}
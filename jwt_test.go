@@ -0,0 +1,109 @@
+package streamnative
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseTokenOutput(t *testing.T) {
+	const jwt = "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0.c2ln"
+	for out, want := range map[string]string{
+		"\ntoken-value\n":                   "token-value",
+		"  token-value \r\n":                "token-value",
+		jwt + "\n":                          jwt,
+		"Activated service account\n" + jwt: jwt,
+		"INFO refreshing\n" + jwt + "\n\n":  jwt,
+		jwt + "\nWARN token expires soon\n": jwt,
+		"":                                  "",
+	} {
+		if got := parseTokenOutput([]byte(out)); got != want {
+			t.Errorf("parseTokenOutput(%q) = %q, want %q", out, got, want)
+		}
+	}
+}
+
+func TestReadTrimsToken(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: "\ntoken-value\n"})
+	tb.writeRole(t, "trimmed", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/trimmed", nil)
+	if resp.Data["token"] != "token-value" {
+		t.Fatalf("expected exactly token-value, got %q", resp.Data["token"])
+	}
+}
+
+func TestReadReportsExpiry(t *testing.T) {
+	tb := getTestBackend(t)
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{
+		"exp": exp.Unix(),
+		"iat": exp.Add(-2 * time.Hour).Unix(),
+	})})
+	tb.writeRole(t, "expiring", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/expiring", nil)
+	if resp.Data["expires_at"] != exp.UTC().Format(time.RFC3339) {
+		t.Fatalf("expected expires_at %s, got %v", exp.UTC().Format(time.RFC3339), resp.Data["expires_at"])
+	}
+	if ttl, ok := resp.Data["ttl_seconds"].(int64); !ok || ttl <= 3500 || ttl > 3600 {
+		t.Fatalf("expected ttl_seconds of about an hour, got %v", resp.Data["ttl_seconds"])
+	}
+
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: "opaque-token"})
+	resp = tb.mustRequest(t, logical.ReadOperation, "creds/expiring", map[string]interface{}{"no_cache": true})
+	if _, ok := resp.Data["expires_at"]; ok {
+		t.Fatalf("expected expires_at to be omitted for an opaque token, got %v", resp.Data)
+	}
+}
+
+func TestIncludeClaims(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{
+		"sub":   "test-client@test-org",
+		"aud":   "urn:sn:pulsar:test-org:test-cluster",
+		"iss":   "https://auth.streamnative.cloud/",
+		"scope": "admin",
+		"exp":   1900000000,
+	})})
+	tb.writeRole(t, "claims", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/claims", map[string]interface{}{"include_claims": true})
+	want := map[string]interface{}{
+		"sub":   "test-client@test-org",
+		"aud":   "urn:sn:pulsar:test-org:test-cluster",
+		"iss":   "https://auth.streamnative.cloud/",
+		"scope": "admin",
+	}
+	claims, ok := resp.Data["claims"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a claims map, got %v", resp.Data["claims"])
+	}
+	for name, value := range want {
+		if claims[name] != value {
+			t.Errorf("expected claim %s=%v, got %v", name, value, claims[name])
+		}
+	}
+
+	resp = tb.mustRequest(t, logical.ReadOperation, "creds/claims", nil)
+	if _, ok := resp.Data["claims"]; ok {
+		t.Fatal("claims were returned without include_claims")
+	}
+}
+
+func TestIncludeClaimsMalformed(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: "opaque-token"})
+	tb.writeRole(t, "opaque", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/opaque", map[string]interface{}{"include_claims": true})
+	if claims, ok := resp.Data["claims"]; !ok || claims != nil {
+		t.Fatalf("expected claims: null, got %v", claims)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Fatal("expected a warning about the malformed token")
+	}
+}
@@ -0,0 +1,46 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// concurrencyLimiter bounds the number of token generations in flight, each
+// of which may spawn snctl subprocesses.
+type concurrencyLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots chan struct{}
+}
+
+// acquire waits for a free slot under limit, giving up when ctx is done. The
+// returned release must be called once the slot is no longer needed. A limit
+// of zero disables the limiter.
+func (l *concurrencyLimiter) acquire(ctx context.Context, limit int) (release func(), err error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	if l.limit != limit {
+		// Slots held under the old limit are released to the old channel.
+		l.limit = limit
+		l.slots = make(chan struct{}, limit)
+	}
+	slots := l.slots
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("token generation concurrency limit of %d reached", limit)
+	}
+}
+
+// inFlight returns the number of slots currently held.
+func (l *concurrencyLimiter) inFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.slots)
+}
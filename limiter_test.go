@@ -0,0 +1,72 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConcurrencyLimitSerializes(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_ISOLATE_HOME", "true")
+	tb.setEnv(t, "SNCTL_MAX_CONCURRENT_TOKENS", "1")
+	var mu sync.Mutex
+	running, most := 0, 0
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if !strings.Contains(strings.Join(cmd.Args, " "), "auth get-token") {
+			return run(cmd)
+		}
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return run(cmd)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("role-%d", i)
+		tb.writeRole(t, name, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := tb.request(t, logical.ReadOperation, "creds/"+name, nil); errorText(resp, err) != "" {
+				t.Error(errorText(resp, err))
+			}
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Fatalf("expected token generations to run one at a time, got %d at once", most)
+	}
+}
+
+func TestConcurrencyLimitReached(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_CONCURRENT_TOKENS", "1")
+	tb.setEnv(t, "SNCTL_REQUEST_TIMEOUT", "1")
+	tb.writeRole(t, "limited", nil)
+	release, err := tb.limiter.acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/limited", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "concurrency limit of 1 reached") {
+		t.Fatalf("expected the read to give up waiting for a slot, got %q", msg)
+	}
+}
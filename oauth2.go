@@ -0,0 +1,110 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+)
+
+// serviceAccountKey is a StreamNative service account key file.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	ClientEmail  string `json:"client_email"`
+	IssuerURL    string `json:"issuer_url"`
+}
+
+// pulsarAudience is the OAuth2 audience of a StreamNative Pulsar cluster.
+func pulsarAudience(org string, cluster string) string {
+	return fmt.Sprintf("urn:sn:pulsar:%s:%s", org, cluster)
+}
+
+// oauth2TokenResponse is the RFC 6749 access token response, including the
+// error fields returned on failure.
+type oauth2TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// readOAuth2Token mints a token with the OAuth2 client credentials grant
+// against the key file's issuer. It touches neither snctl nor the filesystem.
+func (b *backend) readOAuth2Token(ctx context.Context, conf *snctlConfig, data map[string]interface{}) (string, error) {
+	var key serviceAccountKey
+	if err := jsonutil.DecodeJSON([]byte(data["key-file"].(string)), &key); err != nil {
+		return "", errwrap.Wrapf("key-file is not valid JSON: {{err}}", err)
+	}
+	if key.IssuerURL == "" || key.ClientID == "" || key.ClientSecret == "" {
+		return "", fmt.Errorf("key-file must contain issuer_url, client_id and client_secret")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", key.ClientID)
+	form.Set("client_secret", key.ClientSecret)
+	audience, _ := data["audience"].(string)
+	if audience == "" {
+		audience = pulsarAudience(data["organization"].(string), data["cluster"].(string))
+	}
+	form.Set("audience", audience)
+	if scopes, _ := data["scopes"].([]string); len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+	defer cancel()
+	tokenURL := strings.TrimRight(key.IssuerURL, "/") + "/oauth/token"
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errwrap.Wrapf("Building token request failed: {{err}}", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	_, span := tracer.Start(reqCtx, "oauth2.token_request", accountAttributes(data))
+	resp, err := conf.HTTPClient.Do(req)
+	endSpan(span, err)
+	if err != nil {
+		b.Logger().Error("OAuth2 token request failed", "issuer", key.IssuerURL, "error", err)
+		if ctx.Err() != nil {
+			return "", errwrap.Wrapf("OAuth2 token request failed: {{err}}", err)
+		}
+		return "", classify(ErrTransient, errwrap.Wrapf("OAuth2 token request failed: {{err}}", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", errwrap.Wrapf("Reading OAuth2 token response failed: {{err}}", err)
+	}
+	var tokenResp oauth2TokenResponse
+	decodeErr := jsonutil.DecodeJSON(body, &tokenResp)
+	if resp.StatusCode != http.StatusOK {
+		b.Logger().Error("OAuth2 token request rejected", "issuer", key.IssuerURL, "status", resp.StatusCode, "error", tokenResp.Error)
+		err := fmt.Errorf("OAuth2 token request rejected with status %d", resp.StatusCode)
+		if tokenResp.Error != "" {
+			err = fmt.Errorf("OAuth2 token request rejected with status %d: %s %s", resp.StatusCode, tokenResp.Error, tokenResp.ErrorDescription)
+		}
+		switch {
+		case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+			return "", classify(ErrTransient, err)
+		case resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			return "", classify(ErrInvalidCredential, err)
+		}
+		return "", err
+	}
+	if decodeErr != nil {
+		return "", errwrap.Wrapf("OAuth2 token response is not JSON: {{err}}", decodeErr)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("OAuth2 token response has no access_token")
+	}
+	return tokenResp.AccessToken, nil
+}
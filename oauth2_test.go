@@ -0,0 +1,78 @@
+package streamnative
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOAuth2Mode(t *testing.T) {
+	tb := getTestBackend(t)
+	token := testJWT(t, nil)
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		for name, want := range map[string]string{
+			"grant_type":    "client_credentials",
+			"client_id":     "test-client",
+			"client_secret": "test-client-secret",
+			"audience":      "urn:sn:pulsar:test-org:test-cluster",
+		} {
+			if got := r.PostForm.Get(name); got != want {
+				t.Errorf("expected %s=%s, got %q", name, want, got)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": token, "token_type": "Bearer"})
+	}))
+	defer issuer.Close()
+
+	tb.runner = func(cmd *exec.Cmd) error {
+		t.Errorf("oauth2 mode ran %q", cmd.Args)
+		return nil
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"mode": modeOAuth2})
+	keyFile := strings.ReplaceAll(testKeyFile, "https://auth.streamnative.cloud", issuer.URL)
+	tb.writeRole(t, "direct", map[string]interface{}{"key-file": keyFile})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/direct", nil)
+	if resp.Data["token"] != token {
+		t.Fatalf("expected the issuer's access token, got %v", resp.Data["token"])
+	}
+}
+
+func TestOAuth2Scopes(t *testing.T) {
+	tb := getTestBackend(t)
+	var scopes []string
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		scopes = append(scopes, r.PostForm.Get("scope"))
+		json.NewEncoder(w).Encode(map[string]string{"access_token": testJWT(t, nil), "token_type": "Bearer"})
+	}))
+	defer issuer.Close()
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"mode": modeOAuth2})
+	keyFile := strings.ReplaceAll(testKeyFile, "https://auth.streamnative.cloud", issuer.URL)
+	tb.writeRole(t, "scoped", map[string]interface{}{"key-file": keyFile, "allowed_scopes": "read write"})
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/scoped", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "write"})
+	resp, err := tb.request(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{"scopes": "admin"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "allowed_scopes") {
+		t.Fatalf("expected the out-of-list scope to be rejected, got %q", msg)
+	}
+	if len(scopes) != 2 || scopes[0] != "" || scopes[1] != "write" {
+		t.Fatalf("expected no scope by default and then the requested one, got %q", scopes)
+	}
+}
@@ -0,0 +1,180 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxBatchSize bounds the number of tokens one batch-token call may mint.
+const maxBatchSize = 32
+
+func (b *backend) pathBatchToken() *framework.Path {
+	fields := tokenRequestFields()
+	fields["roles"] = &framework.FieldSchema{
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Roles to mint tokens for. An entry of the form <role>@<organization>/<cluster> overrides the role's organization and cluster.",
+	}
+
+	return &framework.Path{
+		Pattern: "batch-token$",
+
+		Fields: fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleBatchToken,
+				Summary:  "Generate tokens for several roles in one call.",
+			},
+		},
+
+		HelpSynopsis:    "Generate tokens for several roles at once.",
+		HelpDescription: "Mints a token for each entry in roles concurrently, within the token generation concurrency limit. Only roles that set allow_batch are minted. The remaining parameters apply to every entry. Each entry reports its token or its own error, so one failure does not fail the batch. The tokens are not leased.",
+	}
+}
+
+// batchEntry is one entry of a batch-token request.
+type batchEntry struct {
+	role         string
+	organization string
+	cluster      string
+}
+
+// parseBatchEntry parses a role name, optionally followed by
+// @<organization>/<cluster>.
+func parseBatchEntry(raw string) (batchEntry, bool) {
+	role, target, hasTarget := strings.Cut(raw, "@")
+	entry := batchEntry{role: role}
+	if role == "" {
+		return entry, false
+	}
+	if hasTarget {
+		var ok bool
+		entry.organization, entry.cluster, ok = strings.Cut(target, "/")
+		if !ok || entry.organization == "" || entry.cluster == "" {
+			return entry, false
+		}
+	}
+	return entry, true
+}
+
+func (b *backend) handleBatchToken(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range data.Get("roles").([]string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return logical.ErrorResponse("'roles' must list at least one role"), nil
+	}
+	if len(names) > maxBatchSize {
+		return logical.ErrorResponse("'roles' lists %d entries; at most %d are allowed", len(names), maxBatchSize), nil
+	}
+
+	shared := tokenRequestParams(req.Data)
+	results := make(map[string]interface{}, len(names))
+	// Entries are checked before any token is minted, so that results is
+	// only written concurrently by the minting goroutines, under mu.
+	jobs := make(map[string]batchEntry, len(names))
+	for _, name := range names {
+		entry, ok := parseBatchEntry(name)
+		if !ok {
+			results[name] = map[string]interface{}{
+				"error": "entries must be a role name, optionally followed by @<organization>/<cluster>",
+			}
+			continue
+		}
+		allowed, err := roleAllowsBatch(ctx, req.Storage, entry.role)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			results[name] = map[string]interface{}{
+				"error": fmt.Sprintf("role '%s' does not set allow_batch; read creds/%s instead", entry.role, entry.role),
+			}
+			continue
+		}
+		jobs[name] = entry
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, entry := range jobs {
+		params := make(map[string]interface{}, len(shared)+2)
+		for k, v := range shared {
+			params[k] = v
+		}
+		if entry.organization != "" {
+			params["organization"] = entry.organization
+			params["cluster"] = entry.cluster
+		}
+
+		wg.Add(1)
+		go func(name, path string, params map[string]interface{}) {
+			defer wg.Done()
+			result := b.batchResult(ctx, req, path, params)
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, roleStorageKey(entry.role), params)
+	}
+	wg.Wait()
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"tokens": results,
+		},
+	}
+	conf := b.config()
+	if conf.MinWrapTTL > 0 && (req.WrapInfo == nil || req.WrapInfo.TTL == 0) {
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{TTL: conf.MinWrapTTL}
+	}
+	return resp, nil
+}
+
+// roleAllowsBatch reports whether the named role opts in to batch-token with
+// allow_batch. Vault checks the caller's policy for batch-token alone, not
+// for each role's creds/<name>, so a role must opt in to being minted here.
+// A missing role is allowed through, to be reported by the read itself.
+func roleAllowsBatch(ctx context.Context, s logical.Storage, name string) (bool, error) {
+	ent, err := s.Get(ctx, roleStorageKey(name))
+	if err != nil {
+		return false, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	if ent == nil {
+		return true, nil
+	}
+	var role map[string]interface{}
+	if err := jsonutil.DecodeJSON(ent.Value, &role); err != nil {
+		return false, errwrap.Wrapf("json decoding failed: {{err}}", err)
+	}
+	allowed, _ := role["allow_batch"].(bool)
+	return allowed, nil
+}
+
+// batchResult mints the token for one batch entry, reporting a failure as
+// the entry's error.
+func (b *backend) batchResult(ctx context.Context, req *logical.Request, path string, params map[string]interface{}) map[string]interface{} {
+	resp, err := b.issueToken(ctx, req, path, params)
+	switch {
+	case err != nil:
+		return withExitCode(map[string]interface{}{"error": err.Error()}, err)
+	case resp.IsError():
+		return map[string]interface{}{"error": resp.Error().Error()}
+	}
+	result := resp.Data
+	if len(resp.Warnings) > 0 {
+		result["warnings"] = resp.Warnings
+	}
+	return result
+}
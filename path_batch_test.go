@@ -0,0 +1,51 @@
+package streamnative
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBatchToken(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "batched", map[string]interface{}{"allow_batch": true, "allowed_clusters": "test-cluster,other-cluster"})
+	tb.writeRole(t, "other", map[string]interface{}{"allow_batch": true})
+	tb.writeRole(t, "private", nil)
+
+	resp := tb.mustRequest(t, logical.UpdateOperation, "batch-token", map[string]interface{}{
+		"roles": "batched,batched@test-org/other-cluster,other,private,missing,@test-org/test-cluster,batched@test-org/forbidden-cluster",
+	})
+	results := resp.Data["tokens"].(map[string]interface{})
+	if len(results) != 7 {
+		t.Fatalf("expected a result for every entry, got %v", results)
+	}
+	for _, name := range []string{"batched", "batched@test-org/other-cluster", "other"} {
+		result := results[name].(map[string]interface{})
+		if result["token"] == nil || result["error"] != nil {
+			t.Errorf("expected a token for %s, got %v", name, result)
+		}
+	}
+	if data := results["batched@test-org/other-cluster"].(map[string]interface{})["data"].(map[string]interface{}); data["cluster"] != "other-cluster" {
+		t.Errorf("the cluster override was not applied: %v", data)
+	}
+	for name, want := range map[string]string{
+		"private":                            "does not set allow_batch",
+		"missing":                            "No value at",
+		"@test-org/test-cluster":             "must be a role name",
+		"batched@test-org/forbidden-cluster": "allowed_clusters",
+	} {
+		result := results[name].(map[string]interface{})
+		if msg, _ := result["error"].(string); !strings.Contains(msg, want) {
+			t.Errorf("expected %s to fail with %q, got %v", name, want, result)
+		}
+		if result["token"] != nil {
+			t.Errorf("%s returned a token", name)
+		}
+	}
+	for _, call := range tb.snctl.Calls("auth get-token") {
+		if args := strings.Join(call.Args, " "); strings.Contains(args, "forbidden-cluster") {
+			t.Fatalf("a token was minted for a rejected entry: %s", args)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package streamnative
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathCapabilities describes the features enabled on this mount. It is cheap,
+// has no side effects, and never reveals secret configuration.
+func (b *backend) pathCapabilities() *framework.Path {
+	return &framework.Path{
+		Pattern: "capabilities$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleCapabilities,
+				Summary:  "Describe the features enabled on this mount.",
+			},
+		},
+
+		HelpSynopsis:    "Describe the plugin's enabled features.",
+		HelpDescription: "Returns the read parameters the plugin accepts and which optional features are enabled by its configuration.",
+	}
+}
+
+func (b *backend) handleCapabilities(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+
+	var params []string
+	for name := range tokenRequestFields() {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":         b.RunningVersion,
+			"mode":            conf.Mode,
+			"read_parameters": params,
+			"features": map[string]interface{}{
+				"token_cache":     true,
+				"token_binding":   conf.BindingFlag != "",
+				"custom_audience": conf.Mode == modeOAuth2 || conf.AudienceFlag != "",
+				"superuser":       conf.Mode == modeSnctl && conf.SuperuserFlag != "",
+				"subject":         conf.Mode == modeSnctl && conf.SubjectFlag != "",
+				"scopes":          conf.Mode == modeOAuth2 || conf.ScopeFlag != "",
+				"circuit_breaker": conf.BreakerThreshold > 0,
+				"audit_claims":    conf.AuditClaims,
+				"token_json_path": conf.TokenJSONPath != "",
+				"freeze_windows":  len(conf.Freeze.windows) > 0,
+				"home_fallback":   conf.HomeFallback,
+			},
+		},
+	}, nil
+}
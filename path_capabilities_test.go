@@ -0,0 +1,29 @@
+package streamnative
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCapabilitiesReflectConfig(t *testing.T) {
+	tb := getTestBackend(t)
+	features := func() map[string]interface{} {
+		resp := tb.mustRequest(t, logical.ReadOperation, "capabilities", nil)
+		if !containsString(resp.Data["read_parameters"].([]string), "format") {
+			t.Fatalf("format is missing from the read parameters: %v", resp.Data["read_parameters"])
+		}
+		return resp.Data["features"].(map[string]interface{})
+	}
+
+	if f := features(); f["audit_claims"] != false || f["circuit_breaker"] != false || f["token_binding"] != false {
+		t.Fatalf("expected optional features to be disabled by default, got %v", f)
+	}
+
+	t.Setenv("SNCTL_AUDIT_CLAIMS", "true")
+	t.Setenv("SNCTL_BREAKER_THRESHOLD", "3")
+	tb.setEnv(t, "SNCTL_BINDING_FLAG", "--cnf-jkt")
+	if f := features(); f["audit_claims"] != true || f["circuit_breaker"] != true || f["token_binding"] != true {
+		t.Fatalf("expected the enabled features to be reported, got %v", f)
+	}
+}
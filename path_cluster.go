@@ -0,0 +1,144 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathCluster() *framework.Path {
+	return &framework.Path{
+		Pattern: "cluster/" + framework.GenericNameRegex("organization") + "/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("role"),
+
+		Fields: map[string]*framework.FieldSchema{
+			"organization": {
+				Type:        framework.TypeString,
+				Description: "The organization.",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the cluster.",
+			},
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Role whose service account looks up the cluster, given in the path so that policies can limit which roles each caller may use. Defaults to the organization's default account.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleClusterRead,
+				Summary:  "Describe a cluster.",
+			},
+		},
+
+		HelpSynopsis:    "Describe a StreamNative cluster.",
+		HelpDescription: "Looks up the cluster with `snctl get pulsarcluster` as the organization's default account, or as the role named by cluster/<organization>/<name>/<role>, returning its service and admin URLs, location and readiness.",
+	}
+}
+
+// clusterResource is the part of a PulsarCluster resource that is returned.
+type clusterResource struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Location         string `json:"location"`
+		ServiceEndpoints []struct {
+			DNSName string `json:"dnsName"`
+			Type    string `json:"type"`
+		} `json:"serviceEndpoints"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+func (b *backend) handleClusterRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	if conf.Mode == modeOAuth2 {
+		return logical.ErrorResponse("Describing clusters runs snctl and is not supported in oauth2 mode"), nil
+	}
+	org := data.Get("organization").(string)
+	name := data.Get("name").(string)
+
+	account := map[string]interface{}{"organization": org}
+	if role := data.Get("role").(string); role != "" {
+		stored, err := b.readRole(ctx, req.Storage, role)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return logical.ErrorResponse("No role named '%s'", role), nil
+		}
+		if stored["organization"] != org && !containsString(storedStringList(stored, "allowed_organizations"), org) {
+			return logical.ErrorResponse("Organization '%s' is not in role '%s''s allowed_organizations", org, role), nil
+		}
+		if allowed := storedStringList(stored, "allowed_clusters"); len(allowed) > 0 && !containsString(allowed, name) {
+			return logical.ErrorResponse("Cluster '%s' is not in role '%s''s allowed_clusters", name, role), nil
+		}
+		account = stored
+	}
+	resolved, _, err := b.withOrgAccountKey(ctx, req.Storage, account)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return missingKeyResponse(account), nil
+	}
+	resolved["organization"] = org
+	resolved["cluster"] = name
+	conf = conf.forAccount(resolved)
+
+	var out []byte
+	err = b.withServiceAccount(ctx, conf, resolved, func(home string, _ string, _ string, _ []byte) error {
+		var err error
+		out, err = b.runSnctlWithRetry(ctx, conf, home, nil, "get pulsarcluster",
+			"-n", org, "get", "pulsarcluster", name, "-o", "json")
+		return err
+	})
+	if err != nil {
+		return nil, mintErrorResponse(err)
+	}
+	details, err := parseClusterResource(out)
+	if err != nil {
+		return nil, err
+	}
+	details["organization"] = org
+	return &logical.Response{Data: details}, nil
+}
+
+// parseClusterResource extracts the cluster's URLs, location and readiness
+// from `snctl get pulsarcluster -o json` output.
+func parseClusterResource(out []byte) (map[string]interface{}, error) {
+	var cluster clusterResource
+	if err := jsonutil.DecodeJSON(out, &cluster); err != nil {
+		return nil, errwrap.Wrapf("cluster description is not JSON: {{err}}", err)
+	}
+	details := map[string]interface{}{
+		"name":     cluster.Metadata.Name,
+		"location": cluster.Spec.Location,
+	}
+	for _, endpoint := range cluster.Spec.ServiceEndpoints {
+		if endpoint.DNSName == "" || (endpoint.Type != "" && !strings.EqualFold(endpoint.Type, "service")) {
+			continue
+		}
+		details["service_url"] = fmt.Sprintf("pulsar+ssl://%s:6651", endpoint.DNSName)
+		details["admin_url"] = "https://" + endpoint.DNSName
+		break
+	}
+	for _, condition := range cluster.Status.Conditions {
+		if condition.Type == "Ready" {
+			details["ready"] = condition.Status == "True"
+		}
+	}
+	return details, nil
+}
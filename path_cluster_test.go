@@ -0,0 +1,104 @@
+package streamnative
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testClusterJSON is `snctl get pulsarcluster -o json` output for
+// test-cluster.
+const testClusterJSON = `{
+	"metadata": {"name": "test-cluster"},
+	"spec": {
+		"location": "us-east1",
+		"serviceEndpoints": [{"dnsName": "test-cluster.test-org.aws.snio.cloud", "type": "service"}]
+	},
+	"status": {"conditions": [{"type": "Ready", "status": "True"}]}
+}`
+
+func TestClusterRead(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("get pulsarcluster", snctltest.Response{Stdout: testClusterJSON})
+	tb.writeRole(t, "describer", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "cluster/test-org/test-cluster/describer", nil)
+	want := map[string]interface{}{
+		"name":         "test-cluster",
+		"organization": "test-org",
+		"location":     "us-east1",
+		"service_url":  "pulsar+ssl://test-cluster.test-org.aws.snio.cloud:6651",
+		"admin_url":    "https://test-cluster.test-org.aws.snio.cloud",
+		"ready":        true,
+	}
+	for k, v := range want {
+		if resp.Data[k] != v {
+			t.Errorf("expected %s %v, got %v", k, v, resp.Data[k])
+		}
+	}
+	calls := tb.snctl.Calls("get pulsarcluster")
+	if len(calls) != 1 || !strings.Contains(strings.Join(calls[0].Args, " "), "-n test-org get pulsarcluster test-cluster -o json") {
+		t.Fatalf("unexpected invocations %v", calls)
+	}
+}
+
+func TestClusterReadRole(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("get pulsarcluster", snctltest.Response{Stdout: testClusterJSON})
+	tb.writeRole(t, "restricted", map[string]interface{}{"allowed_clusters": "test-cluster"})
+	tb.writeRole(t, "team/nested", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "cluster/test-org/test-cluster/restricted", nil)
+	tb.mustRequest(t, logical.ReadOperation, "cluster/test-org/test-cluster/team/nested", nil)
+
+	for path, want := range map[string]string{
+		"cluster/test-org/other-cluster/restricted": "allowed_clusters",
+		"cluster/other-org/test-cluster/restricted": "allowed_organizations",
+		"cluster/test-org/test-cluster/missing":     "No role named 'missing'",
+	} {
+		resp, err := tb.request(t, logical.ReadOperation, path, nil)
+		if msg := errorText(resp, err); !strings.Contains(msg, want) {
+			t.Errorf("%s: expected an error mentioning %q, got %q", path, want, msg)
+		}
+	}
+
+	// The role comes only from the path, which policies can restrict; a
+	// role parameter does not select one.
+	resp, err := tb.request(t, logical.ReadOperation, "cluster/test-org/test-cluster", map[string]interface{}{"role": "restricted"})
+	if msg := errorText(resp, err); !strings.Contains(msg, "no default account") {
+		t.Fatalf("expected the organization default to be required, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("get pulsarcluster")); n != 2 {
+		t.Fatalf("expected only the permitted reads to run snctl, got %d", n)
+	}
+}
+
+func TestClusterReadKeyFiles(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("get pulsarcluster", snctltest.Response{Stdout: testClusterJSON})
+	tb.writeRole(t, "failover", map[string]interface{}{
+		"key-file":  nil,
+		"key_files": []interface{}{testKeyFileFor("revoked-client"), testKeyFileFor("new-client")},
+	})
+	rejectActivation(tb, "revoked-client")
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "cluster/test-org/test-cluster/failover", nil)
+	if resp.Data["name"] != "test-cluster" {
+		t.Fatalf("expected the cluster described with the second key file, got %v", resp.Data)
+	}
+	// The rejected activation never reaches the fake.
+	if n := len(tb.snctl.Calls("auth activate-service-account")); n != 1 {
+		t.Fatalf("expected the second key file to be activated, got %d activations", n)
+	}
+
+	rejectActivation(tb, "new-client")
+	resp, err := tb.request(t, logical.ReadOperation, "cluster/test-org/test-cluster/failover", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "invalid_client") {
+		t.Fatalf("expected the rejected key files to fail the read, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("get pulsarcluster")); n != 1 {
+		t.Fatalf("expected no further describe, got %d", n)
+	}
+}
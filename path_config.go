@@ -0,0 +1,586 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathConfig() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/snctl$",
+
+		Fields: map[string]*framework.FieldSchema{
+			"mode": {
+				Type:        framework.TypeString,
+				Description: "How tokens are minted: 'snctl' runs the snctl CLI, 'oauth2' calls the key file's issuer directly. Defaults to 'snctl'.",
+			},
+			"binary_path": {
+				Type:        framework.TypeString,
+				Description: "Path to the snctl binary. Defaults to $SNCTL_PATH, then 'snctl' on the PATH.",
+			},
+			"config_dir": {
+				Type:        framework.TypeString,
+				Description: "HOME directory snctl runs under; its configuration is kept in config_dir/.snctl. Defaults to $SNCTL_CONFIG_DIR, then the plugin's HOME.",
+			},
+			"temp_dir": {
+				Type:        framework.TypeString,
+				Description: "Directory for per-request key files and HOMEs. Must be writable. Defaults to $SNCTL_TEMP_DIR, then the system temporary directory.",
+			},
+			"refresh_skew": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long before a token's expiry it is no longer served from the cache, and its lease ends. Defaults to $SNCTL_REFRESH_SKEW, then 60s.",
+			},
+			"token_field": {
+				Type:        framework.TypeString,
+				Description: "Response key the token is returned under, such as access_token. Defaults to $SNCTL_TOKEN_FIELD, then token.",
+			},
+			"token_json_path": {
+				Type:        framework.TypeString,
+				Description: "Dotted path of the token in snctl auth get-token JSON output, such as data.access_token; numeric segments index into arrays. Setting it requires token_json_sample. When empty the output is the token itself. Defaults to $SNCTL_TOKEN_JSON_PATH.",
+			},
+			"token_json_sample": {
+				Type:        framework.TypeString,
+				Description: "A sample of the get-token JSON output, in which token_json_path must resolve to a string for the write to succeed. Not stored.",
+			},
+			"service_url_template": {
+				Type:        framework.TypeString,
+				Description: "Pulsar service URL of a cluster, returned by reads with format=pulsar_client, in which {organization} and {cluster} are replaced; both must appear. Defaults to $SNCTL_SERVICE_URL_TEMPLATE.",
+			},
+			"get_token_args": {
+				Type:        framework.TypeString,
+				Description: "Arguments of the snctl command minting a token, separated by spaces, with {organization}, {cluster} and {key_file} placeholders. Defaults to $SNCTL_GET_TOKEN_ARGS, then '-n {organization} auth get-token {cluster} -f {key_file}'.",
+			},
+			"sweep_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often expired cached tokens and stale temporary files are cleaned up. Defaults to $SNCTL_SWEEP_INTERVAL, then 5m.",
+			},
+			"temp_file_max_age": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Age after which a leftover temporary key file or HOME is removed. Defaults to $SNCTL_TEMP_FILE_MAX_AGE, then 1h.",
+			},
+			"ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM bundle of the CAs trusted for OAuth2 token requests, replacing the system roots.",
+			},
+			"client_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM client certificate presented on OAuth2 token requests, for issuers behind mutual TLS.",
+			},
+			"client_key": {
+				Type:        framework.TypeString,
+				Description: "PEM private key of client_cert. Never returned.",
+			},
+			"transit_mount": {
+				Type:        framework.TypeString,
+				Description: "Mount of a transit secrets engine whose transit_key encrypts key files before they are stored. Set with transit_key and transit_token.",
+			},
+			"transit_key": {
+				Type:        framework.TypeString,
+				Description: "Name of the transit key encrypting key files.",
+			},
+			"transit_address": {
+				Type:        framework.TypeString,
+				Description: "Address of the Vault server serving transit_mount. Defaults to the plugin's $VAULT_ADDR.",
+			},
+			"transit_token": {
+				Type:        framework.TypeString,
+				Description: "Vault token allowed to encrypt and decrypt with transit_key. It is used until replaced and never renewed, so it must not expire while configured. Never returned.",
+			},
+			"audience_flag": {
+				Type:        framework.TypeString,
+				Description: "The snctl auth get-token flag requesting a custom audience, such as --audience. Reads requesting an audience in snctl mode are rejected when unset. Defaults to $SNCTL_AUDIENCE_FLAG.",
+			},
+			"superuser_flag": {
+				Type:        framework.TypeString,
+				Description: "The snctl auth get-token flag requesting a superuser token, such as --superuser. Superuser reads are rejected when unset. Defaults to $SNCTL_SUPERUSER_FLAG.",
+			},
+			"subject_flag": {
+				Type:        framework.TypeString,
+				Description: "The snctl auth get-token flag requesting a token for a named subject, such as --subject. Reads requesting a subject are rejected when unset. Defaults to $SNCTL_SUBJECT_FLAG.",
+			},
+			"scope_flag": {
+				Type:        framework.TypeString,
+				Description: "The snctl auth get-token flag requesting scopes, given space separated, such as --scope. Reads requesting scopes in snctl mode are rejected when unset. Defaults to $SNCTL_SCOPE_FLAG.",
+			},
+			"snctl_env": {
+				Type:        framework.TypeKVPairs,
+				Description: "Extra environment variables for snctl, such as HTTPS_PROXY and NO_PROXY. HOME, PATH and loader variables such as LD_PRELOAD cannot be set. Values are never returned or logged.",
+			},
+			"key_file_stdin": {
+				Type:        framework.TypeBool,
+				Description: "Pipe the key file to snctl on stdin instead of writing it to a temporary file. Requires /dev/stdin. Defaults to $SNCTL_KEY_FILE_STDIN, then false.",
+			},
+			"isolate_home": {
+				Type:        framework.TypeBool,
+				Description: "Run each token generation under its own temporary HOME so reads run concurrently. Defaults to $SNCTL_ISOLATE_HOME, then false.",
+			},
+			"home_fallback": {
+				Type:        framework.TypeBool,
+				Description: "Fall back to a temporary HOME for each read when the snctl config directory cannot be written. Only a missing, unreadable or unwritable directory falls back; other failures are returned. Defaults to $SNCTL_HOME_FALLBACK, then false.",
+			},
+			"eager_init": {
+				Type:        framework.TypeBool,
+				Description: "Check snctl and initialize its config when the mount starts, logging a warning on failure. Defaults to $SNCTL_EAGER_INIT, then false.",
+			},
+			"request_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout for each snctl invocation. Defaults to $SNCTL_REQUEST_TIMEOUT, then 30s.",
+			},
+			"validate_on_write": {
+				Type:        framework.TypeBool,
+				Description: "Mint a token when an account is written, rejecting it if its organization or cluster is not accessible. Defaults to $SNCTL_VALIDATE_ON_WRITE, then false.",
+			},
+			"min_wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Response-wrap token reads with this TTL unless the client requests wrapping itself. Defaults to $SNCTL_MIN_WRAP_TTL, then 0, leaving wrapping to the client.",
+			},
+			"max_concurrent_tokens": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of token generations in flight; 0 is unlimited. Defaults to $SNCTL_MAX_CONCURRENT_TOKENS, then 0.",
+			},
+			"max_entry_bytes": {
+				Type:        framework.TypeInt,
+				Description: "Largest stored size of an account, in bytes. 0 means unlimited. Defaults to $SNCTL_MAX_ENTRY_BYTES, then 65536.",
+			},
+			"max_output_bytes": {
+				Type:        framework.TypeInt,
+				Description: "Most bytes of each of snctl's stdout and stderr kept; the rest is discarded. 0 means unlimited. Defaults to $SNCTL_MAX_OUTPUT_BYTES, then 1048576.",
+			},
+			"max_retries": {
+				Type:        framework.TypeInt,
+				Description: "Number of times a transiently failing token generation is retried. Defaults to $SNCTL_MAX_RETRIES, then 2.",
+			},
+			"breaker_threshold": {
+				Type:        framework.TypeInt,
+				Description: "Number of consecutive token generation failures that open the circuit breaker; 0 disables it. Defaults to $SNCTL_BREAKER_THRESHOLD, then 0.",
+			},
+			"breaker_cooldown": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long the circuit breaker stays open before a single read is let through. Defaults to $SNCTL_BREAKER_COOLDOWN, then 30s.",
+			},
+			"breaker_serve_stale": {
+				Type:        framework.TypeBool,
+				Description: "Serve a cached token that has not yet expired while the circuit breaker is open. Defaults to $SNCTL_BREAKER_SERVE_STALE, then false.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleConfigRead,
+				Summary:  "Return the effective snctl configuration.",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleConfigWrite,
+				Summary:  "Configure how snctl is invoked.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.handleConfigDelete,
+				Summary:  "Remove the stored configuration, reverting to the environment and defaults.",
+			},
+		},
+
+		HelpSynopsis:    "Configure how this mount invokes snctl.",
+		HelpDescription: "Settings written here take precedence over the plugin's environment variables. An empty value reverts a setting to the environment or default.",
+	}
+}
+
+func (b *backend) handleConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	// The PEM material is only kept in its parsed form in the snapshot.
+	stored, err := readStoredConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		stored = &storedConfig{}
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"mode":                    conf.Mode,
+			"binary_path":             conf.BinaryPath,
+			"config_dir":              conf.ConfigDir,
+			"temp_dir":                conf.TempDir,
+			"token_field":             conf.TokenField,
+			"token_json_path":         conf.TokenJSONPath,
+			"service_url_template":    conf.ServiceURLTemplate,
+			"get_token_args":          strings.Join(conf.GetTokenArgs, " "),
+			"audience_flag":           conf.AudienceFlag,
+			"superuser_flag":          conf.SuperuserFlag,
+			"subject_flag":            conf.SubjectFlag,
+			"scope_flag":              conf.ScopeFlag,
+			"binding_flag":            conf.BindingFlag,
+			"audit_claims":            conf.AuditClaims,
+			"freeze_windows":          conf.Freeze.specs(),
+			"freeze_timezone":         conf.Freeze.timezone(),
+			"snctl_env_keys":          envNames(conf.SnctlEnv),
+			"key_file_stdin":          conf.KeyFileStdin,
+			"isolate_home":            conf.IsolateHome,
+			"home_fallback":           conf.HomeFallback,
+			"eager_init":              conf.EagerInit,
+			"request_timeout":         int64(conf.RequestTimeout / time.Second),
+			"max_retries":             conf.MaxRetries,
+			"max_concurrent_tokens":   conf.MaxConcurrentTokens,
+			"max_entry_bytes":         conf.MaxEntryBytes,
+			"max_output_bytes":        conf.MaxOutputBytes,
+			"min_wrap_ttl":            int64(conf.MinWrapTTL / time.Second),
+			"refresh_skew":            int64(conf.RefreshSkew / time.Second),
+			"validate_on_write":       conf.ValidateOnWrite,
+			"sweep_interval":          int64(conf.SweepInterval / time.Second),
+			"temp_file_max_age":       int64(conf.TempFileMaxAge / time.Second),
+			"breaker_threshold":       conf.BreakerThreshold,
+			"breaker_cooldown":        int64(conf.BreakerCooldown / time.Second),
+			"breaker_serve_stale":     conf.BreakerServeStale,
+			"ca_cert_fingerprint":     pemFingerprint(stored.CACert),
+			"client_cert_fingerprint": pemFingerprint(stored.ClientCert),
+			"client_key_set":          stored.ClientKey != "",
+			"transit_mount":           stored.TransitMount,
+			"transit_key":             stored.TransitKey,
+			"transit_address":         stored.TransitAddress,
+			"transit_token_set":       stored.TransitToken != "",
+			"sources":                 configSources(stored),
+		},
+	}, nil
+}
+
+// pemFingerprint identifies configured PEM material without returning it.
+func pemFingerprint(pem string) string {
+	if pem == "" {
+		return ""
+	}
+	return fingerprint([]byte(pem))
+}
+
+// Kinds of environment variable values, which are only used when they parse.
+const (
+	envString = iota
+	envSeconds
+	envFlag
+	envNumber
+)
+
+// configEnvVars are the environment variables config/snctl fields fall back
+// to, and the kind of value each holds. binding_flag, audit_claims and the
+// freeze settings are only read from the environment.
+var configEnvVars = map[string]struct {
+	name string
+	kind int
+}{
+	"binary_path":           {"SNCTL_PATH", envString},
+	"config_dir":            {"SNCTL_CONFIG_DIR", envString},
+	"temp_dir":              {"SNCTL_TEMP_DIR", envString},
+	"token_field":           {"SNCTL_TOKEN_FIELD", envString},
+	"token_json_path":       {"SNCTL_TOKEN_JSON_PATH", envString},
+	"service_url_template":  {"SNCTL_SERVICE_URL_TEMPLATE", envString},
+	"get_token_args":        {"SNCTL_GET_TOKEN_ARGS", envString},
+	"audience_flag":         {"SNCTL_AUDIENCE_FLAG", envString},
+	"superuser_flag":        {"SNCTL_SUPERUSER_FLAG", envString},
+	"subject_flag":          {"SNCTL_SUBJECT_FLAG", envString},
+	"scope_flag":            {"SNCTL_SCOPE_FLAG", envString},
+	"binding_flag":          {"SNCTL_BINDING_FLAG", envString},
+	"audit_claims":          {"SNCTL_AUDIT_CLAIMS", envFlag},
+	"freeze_windows":        {"SNCTL_FREEZE_WINDOWS", envString},
+	"freeze_timezone":       {"SNCTL_FREEZE_TIMEZONE", envString},
+	"key_file_stdin":        {"SNCTL_KEY_FILE_STDIN", envFlag},
+	"isolate_home":          {"SNCTL_ISOLATE_HOME", envFlag},
+	"home_fallback":         {"SNCTL_HOME_FALLBACK", envFlag},
+	"eager_init":            {"SNCTL_EAGER_INIT", envFlag},
+	"request_timeout":       {"SNCTL_REQUEST_TIMEOUT", envSeconds},
+	"max_retries":           {"SNCTL_MAX_RETRIES", envNumber},
+	"max_concurrent_tokens": {"SNCTL_MAX_CONCURRENT_TOKENS", envNumber},
+	"max_entry_bytes":       {"SNCTL_MAX_ENTRY_BYTES", envNumber},
+	"max_output_bytes":      {"SNCTL_MAX_OUTPUT_BYTES", envNumber},
+	"min_wrap_ttl":          {"SNCTL_MIN_WRAP_TTL", envSeconds},
+	"refresh_skew":          {"SNCTL_REFRESH_SKEW", envSeconds},
+	"validate_on_write":     {"SNCTL_VALIDATE_ON_WRITE", envFlag},
+	"sweep_interval":        {"SNCTL_SWEEP_INTERVAL", envSeconds},
+	"temp_file_max_age":     {"SNCTL_TEMP_FILE_MAX_AGE", envSeconds},
+	"breaker_threshold":     {"SNCTL_BREAKER_THRESHOLD", envNumber},
+	"breaker_cooldown":      {"SNCTL_BREAKER_COOLDOWN", envSeconds},
+	"breaker_serve_stale":   {"SNCTL_BREAKER_SERVE_STALE", envFlag},
+}
+
+// configSources reports where the effective value of each config/snctl
+// field comes from: "storage", "env" or "default".
+func configSources(stored *storedConfig) map[string]string {
+	// Unset fields are omitted from the encoding, so its keys are those stored.
+	var set map[string]interface{}
+	if buf, err := json.Marshal(stored); err == nil {
+		_ = json.Unmarshal(buf, &set)
+	}
+	sources := make(map[string]string)
+	for _, field := range []string{"mode", "snctl_env", "ca_cert", "client_cert", "client_key",
+		"transit_mount", "transit_key", "transit_address", "transit_token"} {
+		sources[field] = "default"
+	}
+	for field := range configEnvVars {
+		sources[field] = "default"
+	}
+	for field := range sources {
+		if _, ok := set[field]; ok {
+			sources[field] = "storage"
+		} else if env, ok := configEnvVars[field]; ok && envValueUsed(env.name, env.kind) {
+			sources[field] = "env"
+		}
+	}
+	return sources
+}
+
+// envValueUsed reports whether the environment variable is set to a value
+// the configuration accepts.
+func envValueUsed(name string, kind int) bool {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return false
+	}
+	var err error
+	switch kind {
+	case envSeconds:
+		_, err = parseutil.ParseDurationSecond(value)
+	case envFlag:
+		// Any SNCTL_HOME_FALLBACK is used: one that does not parse disables
+		// the fallback.
+		if name == "SNCTL_HOME_FALLBACK" {
+			return true
+		}
+		_, err = strconv.ParseBool(value)
+	case envNumber:
+		_, err = strconv.Atoi(value)
+	default:
+		// SNCTL_PATH is used even when empty.
+		return value != "" || name == "SNCTL_PATH"
+	}
+	return err == nil
+}
+
+func (b *backend) handleConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	stored, err := readStoredConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		stored = &storedConfig{}
+	}
+
+	if mode, ok := data.GetOk("mode"); ok {
+		stored.Mode = mode.(string)
+	}
+	if binaryPath, ok := data.GetOk("binary_path"); ok {
+		stored.BinaryPath = binaryPath.(string)
+	}
+	if configDir, ok := data.GetOk("config_dir"); ok {
+		stored.ConfigDir = configDir.(string)
+	}
+	if tempDir, ok := data.GetOk("temp_dir"); ok {
+		stored.TempDir = tempDir.(string)
+		if stored.TempDir != "" {
+			if err := probeWritable(stored.TempDir); err != nil {
+				return logical.ErrorResponse("temp_dir: %v", err), nil
+			}
+		}
+	}
+	if tokenField, ok := data.GetOk("token_field"); ok {
+		stored.TokenField = tokenField.(string)
+	}
+	if tokenJSONPath, ok := data.GetOk("token_json_path"); ok {
+		stored.TokenJSONPath = tokenJSONPath.(string)
+		if stored.TokenJSONPath != "" {
+			// A path that does not match snctl's output would fail every read.
+			sample, ok := data.GetOk("token_json_sample")
+			if !ok {
+				return logical.ErrorResponse("token_json_path requires token_json_sample, a sample of the get-token output it must resolve in"), nil
+			}
+			if err := validateJSONPath(stored.TokenJSONPath); err != nil {
+				return logical.ErrorResponse("token_json_path is invalid: %v", err), nil
+			}
+			if _, err := extractJSONToken([]byte(sample.(string)), stored.TokenJSONPath); err != nil {
+				return logical.ErrorResponse("token_json_sample: %v", err), nil
+			}
+		}
+	}
+	if serviceURLTemplate, ok := data.GetOk("service_url_template"); ok {
+		stored.ServiceURLTemplate = serviceURLTemplate.(string)
+	}
+	if getTokenArgs, ok := data.GetOk("get_token_args"); ok {
+		stored.GetTokenArgs = getTokenArgs.(string)
+	}
+	if audienceFlag, ok := data.GetOk("audience_flag"); ok {
+		stored.AudienceFlag = audienceFlag.(string)
+	}
+	if superuserFlag, ok := data.GetOk("superuser_flag"); ok {
+		stored.SuperuserFlag = superuserFlag.(string)
+	}
+	if subjectFlag, ok := data.GetOk("subject_flag"); ok {
+		stored.SubjectFlag = subjectFlag.(string)
+	}
+	if scopeFlag, ok := data.GetOk("scope_flag"); ok {
+		stored.ScopeFlag = scopeFlag.(string)
+	}
+	if caCert, ok := data.GetOk("ca_cert"); ok {
+		stored.CACert = caCert.(string)
+	}
+	if clientCert, ok := data.GetOk("client_cert"); ok {
+		stored.ClientCert = clientCert.(string)
+	}
+	if clientKey, ok := data.GetOk("client_key"); ok {
+		stored.ClientKey = clientKey.(string)
+	}
+	if transitMount, ok := data.GetOk("transit_mount"); ok {
+		stored.TransitMount = transitMount.(string)
+	}
+	if transitKey, ok := data.GetOk("transit_key"); ok {
+		stored.TransitKey = transitKey.(string)
+	}
+	if transitAddress, ok := data.GetOk("transit_address"); ok {
+		stored.TransitAddress = transitAddress.(string)
+	}
+	if transitToken, ok := data.GetOk("transit_token"); ok {
+		stored.TransitToken = transitToken.(string)
+	}
+	if snctlEnv, ok := data.GetOk("snctl_env"); ok {
+		stored.SnctlEnv = snctlEnv.(map[string]string)
+	}
+	if keyFileStdin, ok := data.GetOk("key_file_stdin"); ok {
+		enabled := keyFileStdin.(bool)
+		stored.KeyFileStdin = &enabled
+	}
+	if isolateHome, ok := data.GetOk("isolate_home"); ok {
+		enabled := isolateHome.(bool)
+		stored.IsolateHome = &enabled
+	}
+	if homeFallback, ok := data.GetOk("home_fallback"); ok {
+		enabled := homeFallback.(bool)
+		stored.HomeFallback = &enabled
+	}
+	if eagerInit, ok := data.GetOk("eager_init"); ok {
+		enabled := eagerInit.(bool)
+		stored.EagerInit = &enabled
+	}
+	if requestTimeout, ok := data.GetOk("request_timeout"); ok {
+		if requestTimeout.(int) < 0 {
+			return logical.ErrorResponse("request_timeout must not be negative"), nil
+		}
+		stored.RequestTimeout = int64(requestTimeout.(int))
+	}
+	if validateOnWrite, ok := data.GetOk("validate_on_write"); ok {
+		enabled := validateOnWrite.(bool)
+		stored.ValidateOnWrite = &enabled
+	}
+	if minWrapTTL, ok := data.GetOk("min_wrap_ttl"); ok {
+		if minWrapTTL.(int) < 0 {
+			return logical.ErrorResponse("min_wrap_ttl must not be negative"), nil
+		}
+		stored.MinWrapTTL = int64(minWrapTTL.(int))
+	}
+	if refreshSkew, ok := data.GetOk("refresh_skew"); ok {
+		skew := int64(refreshSkew.(int))
+		if skew < 0 {
+			return logical.ErrorResponse("refresh_skew must not be negative"), nil
+		}
+		stored.RefreshSkew = &skew
+	}
+	if sweepInterval, ok := data.GetOk("sweep_interval"); ok {
+		if sweepInterval.(int) < 0 {
+			return logical.ErrorResponse("sweep_interval must not be negative"), nil
+		}
+		stored.SweepInterval = int64(sweepInterval.(int))
+	}
+	if tempFileMaxAge, ok := data.GetOk("temp_file_max_age"); ok {
+		if tempFileMaxAge.(int) < 0 {
+			return logical.ErrorResponse("temp_file_max_age must not be negative"), nil
+		}
+		stored.TempFileMaxAge = int64(tempFileMaxAge.(int))
+	}
+	if maxConcurrent, ok := data.GetOk("max_concurrent_tokens"); ok {
+		limit := maxConcurrent.(int)
+		if limit < 0 {
+			return logical.ErrorResponse("max_concurrent_tokens must not be negative"), nil
+		}
+		stored.MaxConcurrentTokens = &limit
+	}
+	if maxEntryBytes, ok := data.GetOk("max_entry_bytes"); ok {
+		limit := maxEntryBytes.(int)
+		if limit < 0 {
+			return logical.ErrorResponse("max_entry_bytes must not be negative"), nil
+		}
+		stored.MaxEntryBytes = &limit
+	}
+	if maxOutputBytes, ok := data.GetOk("max_output_bytes"); ok {
+		limit := maxOutputBytes.(int)
+		if limit < 0 {
+			return logical.ErrorResponse("max_output_bytes must not be negative"), nil
+		}
+		stored.MaxOutputBytes = &limit
+	}
+	if maxRetries, ok := data.GetOk("max_retries"); ok {
+		retries := maxRetries.(int)
+		if retries < 0 {
+			return logical.ErrorResponse("max_retries must not be negative"), nil
+		}
+		stored.MaxRetries = &retries
+	}
+	if breakerThreshold, ok := data.GetOk("breaker_threshold"); ok {
+		threshold := breakerThreshold.(int)
+		if threshold < 0 {
+			return logical.ErrorResponse("breaker_threshold must not be negative"), nil
+		}
+		stored.BreakerThreshold = &threshold
+	}
+	if breakerCooldown, ok := data.GetOk("breaker_cooldown"); ok {
+		if breakerCooldown.(int) < 0 {
+			return logical.ErrorResponse("breaker_cooldown must not be negative"), nil
+		}
+		stored.BreakerCooldown = int64(breakerCooldown.(int))
+	}
+	if serveStale, ok := data.GetOk("breaker_serve_stale"); ok {
+		enabled := serveStale.(bool)
+		stored.BreakerServeStale = &enabled
+	}
+
+	conf, err := newConfig(stored)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ent, err := logical.StorageEntryJSON(configStorageKey, stored)
+	if err != nil {
+		return nil, errwrap.Wrapf("json encoding failed: {{err}}", err)
+	}
+	// The config may hold a client key or transit token.
+	ent.SealWrap = true
+	if err := req.Storage.Put(ctx, ent); err != nil {
+		b.Logger().Error("Putting config to storage failed", "error", err)
+		return nil, errwrap.Wrapf("Putting config to storage failed: {{err}}", err)
+	}
+
+	b.setConfig(conf)
+	return nil, nil
+}
+
+func (b *backend) handleConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	if err := req.Storage.Delete(ctx, configStorageKey); err != nil {
+		b.Logger().Error("Deleting config from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Deleting config from storage failed: {{err}}", err)
+	}
+
+	conf, err := newConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	b.setConfig(conf)
+	return nil, nil
+}
@@ -0,0 +1,53 @@
+package streamnative
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathHealth checks that snctl is installed and usable without minting a
+// token. Unlike ready it runs snctl, so poll it sparingly.
+func (b *backend) pathHealth() *framework.Path {
+	return &framework.Path{
+		Pattern: "health$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleHealth,
+				Summary:  "Check that snctl is installed and usable.",
+			},
+		},
+
+		HelpSynopsis:    "Check that snctl is installed and usable.",
+		HelpDescription: "Runs `snctl version`, and `snctl config init` under a throwaway HOME, reporting whether each succeeded.",
+	}
+}
+
+func (b *backend) handleHealth(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	outData := map[string]interface{}{
+		"snctl_path": conf.BinaryPath,
+	}
+
+	version, err := b.snctlVersion(ctx, conf)
+	outData["snctl_found"] = err == nil
+	if err != nil {
+		outData["snctl_error"] = err.Error()
+	} else {
+		outData["snctl_version"] = version
+	}
+
+	home, err := b.temporarySnctlHome(ctx, conf)
+	outData["config_ok"] = err == nil
+	if err != nil {
+		outData["config_error"] = err.Error()
+	} else {
+		liveTempFiles.remove(home)
+	}
+
+	return &logical.Response{
+		Data: outData,
+	}, nil
+}
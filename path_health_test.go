@@ -0,0 +1,31 @@
+package streamnative
+
+import (
+	"testing"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHealth(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("version", snctltest.Response{Stdout: "v0.11.2\n"})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "health", nil)
+	if resp.Data["snctl_found"] != true || resp.Data["config_ok"] != true {
+		t.Fatalf("expected a healthy snctl, got %v", resp.Data)
+	}
+}
+
+func TestHealthBrokenSnctl(t *testing.T) {
+	tb := getTestBackend(t)
+	script := stubSnctl(t, tb, "echo broken >&2\nexit 1")
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "health", nil)
+	if resp.Data["snctl_found"] != false || resp.Data["config_ok"] != false {
+		t.Fatalf("expected the broken snctl to be reported, got %v", resp.Data)
+	}
+	if resp.Data["snctl_path"] != script {
+		t.Fatalf("unexpected snctl_path %v", resp.Data["snctl_path"])
+	}
+}
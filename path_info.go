@@ -0,0 +1,44 @@
+package streamnative
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathInfo() *framework.Path {
+	return &framework.Path{
+		Pattern: "info$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleInfo,
+				Summary:  "Report the plugin and snctl versions.",
+			},
+		},
+
+		HelpSynopsis:    "Report the plugin and snctl versions.",
+		HelpDescription: "Returns the plugin's build version and the version reported by `snctl version`. If snctl cannot be run, the error is returned in snctl_error.",
+	}
+}
+
+func (b *backend) handleInfo(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	outData := map[string]interface{}{
+		"plugin_version": b.RunningVersion,
+		"snctl_path":     conf.BinaryPath,
+	}
+
+	version, err := b.snctlVersion(ctx, conf)
+	if err != nil {
+		b.Logger().Warn("Failed to run `snctl version`", "error", err)
+		outData["snctl_error"] = err.Error()
+	} else {
+		outData["snctl_version"] = version
+	}
+
+	return &logical.Response{
+		Data: outData,
+	}, nil
+}
@@ -0,0 +1,28 @@
+package streamnative
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestInfo(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.snctl.On("version", snctltest.Response{Stdout: "snctl version v0.11.2 (linux/amd64)\n"})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "info", nil)
+	if resp.Data["snctl_version"] != "v0.11.2" {
+		t.Fatalf("unexpected snctl_version %v", resp.Data["snctl_version"])
+	}
+	if resp.Data["plugin_version"] != Version {
+		t.Fatalf("unexpected plugin_version %v", resp.Data["plugin_version"])
+	}
+
+	tb.snctl.On("version", snctltest.Response{Stderr: "segmentation fault", ExitCode: 139})
+	resp = tb.mustRequest(t, logical.ReadOperation, "info", nil)
+	if msg, _ := resp.Data["snctl_error"].(string); !strings.Contains(msg, "segmentation fault") {
+		t.Fatalf("expected the snctl error in the response, got %v", resp.Data)
+	}
+}
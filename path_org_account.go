@@ -0,0 +1,242 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// orgAccountPrefix is the storage prefix of organization default accounts.
+const orgAccountPrefix = "config/account/"
+
+// orgAccountStorageKey is where the organization's default account is stored.
+func orgAccountStorageKey(org string) string {
+	return orgAccountPrefix + org
+}
+
+func (b *backend) pathOrgAccounts() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/account/?$",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleOrgAccountList,
+					Summary:  "List the organizations with a default account.",
+				},
+			},
+
+			HelpSynopsis: "List the organization default accounts.",
+		},
+		{
+			Pattern: "config/account/" + framework.GenericNameRegex("organization"),
+
+			Fields: map[string]*framework.FieldSchema{
+				"organization": {
+					Type:        framework.TypeString,
+					Description: "The organization.",
+				},
+				"key-file": {
+					Type:        framework.TypeString,
+					Description: "The service account key file used by the organization's roles that have none of their own.",
+					Required:    true,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleOrgAccountRead,
+					Summary:  "Identify the organization's default key. Its secret is never returned.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleOrgAccountWrite,
+					Summary:  "Store the organization's default key.",
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleOrgAccountWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleOrgAccountDelete,
+					Summary:  "Delete the organization's default key.",
+				},
+			},
+
+			ExistenceCheck: b.handleOrgAccountExistenceCheck,
+
+			HelpSynopsis:    "Manage an organization's default service account key.",
+			HelpDescription: "Roles of the organization written without a key file mint tokens with this key. Changing it affects all of them.",
+		},
+	}
+}
+
+// readOrgAccount returns the organization's default account, or nil if it
+// has none.
+func (b *backend) readOrgAccount(ctx context.Context, s logical.Storage, org string) (map[string]interface{}, error) {
+	account, err := b.readStoredOrgAccount(ctx, s, org)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	if err := decryptKeyFiles(ctx, b.config(), account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// readStoredOrgAccount is readOrgAccount leaving the key file as stored,
+// encrypted when keyEncryptionField is set.
+func (b *backend) readStoredOrgAccount(ctx context.Context, s logical.Storage, org string) (map[string]interface{}, error) {
+	ent, err := s.Get(ctx, orgAccountStorageKey(org))
+	if err != nil {
+		b.Logger().Error("Reading from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	if ent == nil {
+		return nil, nil
+	}
+	var account map[string]interface{}
+	if err := jsonutil.DecodeJSON(ent.Value, &account); err != nil {
+		b.Logger().Error("JSON decoding failed", "error", err)
+		return nil, errwrap.Wrapf("json decoding failed: {{err}}", err)
+	}
+	return account, nil
+}
+
+// withOrgAccountKey returns data as is when it has a key of its own, and
+// otherwise a copy using its organization's default key. It reports whether
+// the default was used, and is nil when there is no key to use.
+func (b *backend) withOrgAccountKey(ctx context.Context, s logical.Storage, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	resolved, usesOrgKey, err := b.withStoredOrgAccountKey(ctx, s, data)
+	if err != nil || !usesOrgKey {
+		return resolved, usesOrgKey, err
+	}
+	if err := decryptKeyFiles(ctx, b.config(), resolved); err != nil {
+		return nil, false, err
+	}
+	return resolved, true, nil
+}
+
+// withStoredOrgAccountKey is withOrgAccountKey leaving the default key as
+// stored; the copy's keyEncryptionField says whether it is encrypted.
+func (b *backend) withStoredOrgAccountKey(ctx context.Context, s logical.Storage, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	if len(accountKeyFiles(data)) > 0 {
+		return data, false, nil
+	}
+	org, _ := data["organization"].(string)
+	if org == "" {
+		return nil, false, nil
+	}
+	account, err := b.readStoredOrgAccount(ctx, s, org)
+	if err != nil || account == nil {
+		return nil, false, err
+	}
+	resolved := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		resolved[k] = v
+	}
+	resolved["key-file"] = account["key-file"]
+	if encryption, ok := account[keyEncryptionField]; ok {
+		resolved[keyEncryptionField] = encryption
+	} else {
+		delete(resolved, keyEncryptionField)
+	}
+	return resolved, true, nil
+}
+
+func (b *backend) handleOrgAccountRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	account, err := b.readOrgAccount(ctx, req.Storage, data.Get("organization").(string))
+	if err != nil || account == nil {
+		return nil, err
+	}
+	keyFile, _ := account["key-file"].(string)
+	var key serviceAccountKey
+	_ = jsonutil.DecodeJSON([]byte(keyFile), &key)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_fingerprint": fingerprint([]byte(keyFile)),
+			"client_id":       key.ClientID,
+			"last_rotation":   account["last_rotation"],
+		},
+	}, nil
+}
+
+func (b *backend) handleOrgAccountWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	org := data.Get("organization").(string)
+	keyFile := data.Get("key-file").(string)
+	if keyFile == "" {
+		return logical.ErrorResponse("'key-file' is required"), nil
+	}
+	if err := validateKeyFile(keyFile); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	path := orgAccountStorageKey(org)
+	lastRotation, err := b.lastRotation(ctx, req.Storage, path, []string{keyFile})
+	if err != nil {
+		return nil, err
+	}
+	account := map[string]interface{}{
+		"key-file":      keyFile,
+		"last_rotation": lastRotation,
+		"version":       accountVersion,
+	}
+	if err := encryptKeyFiles(ctx, b.config(), account); err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(account)
+	if err != nil {
+		return nil, errwrap.Wrapf("json encoding failed: {{err}}", err)
+	}
+	if limit := b.config().MaxEntryBytes; limit > 0 && len(buf) > limit {
+		return logical.ErrorResponse("The account is %d bytes when stored, more than max_entry_bytes of %d", len(buf), limit), nil
+	}
+
+	b.Logger().Info("Storing organization default account", "organization", org)
+	if err := b.putAccount(ctx, req.Storage, path, buf); err != nil {
+		b.Logger().Error("Putting to storage failed", "error", err)
+		return nil, errwrap.Wrapf("Putting to storage failed: {{err}}", err)
+	}
+	// Tokens minted with the previous default are cached on its behalf.
+	b.cache.invalidate(path)
+	return nil, nil
+}
+
+func (b *backend) handleOrgAccountDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := orgAccountStorageKey(data.Get("organization").(string))
+
+	b.cache.invalidate(path)
+
+	if err := req.Storage.Delete(ctx, path); err != nil {
+		b.Logger().Error("Deleting from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Deleting from storage failed: {{err}}", err)
+	}
+	return nil, nil
+}
+
+func (b *backend) handleOrgAccountList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	orgs, err := req.Storage.List(ctx, orgAccountPrefix)
+	if err != nil {
+		b.Logger().Error("Listing storage failed", "error", err)
+		return nil, errwrap.Wrapf("Listing storage failed: {{err}}", err)
+	}
+	return logical.ListResponse(orgs), nil
+}
+
+func (b *backend) handleOrgAccountExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, orgAccountStorageKey(data.Get("organization").(string)))
+	if err != nil {
+		return false, errwrap.Wrapf("existence check failed: {{err}}", err)
+	}
+	return out != nil, nil
+}
+
+// missingKeyResponse rejects an account with no key of its own and no
+// organization default to fall back to.
+func missingKeyResponse(data map[string]interface{}) *logical.Response {
+	return logical.ErrorResponse(fmt.Sprintf("No 'key-file' set, and organization '%v' has no default account at %s",
+		data["organization"], orgAccountStorageKey(fmt.Sprint(data["organization"]))))
+}
@@ -0,0 +1,63 @@
+package streamnative
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// lastActivatedKey returns the key file piped to the latest activation.
+func (tb *testBackend) lastActivatedKey(t *testing.T) string {
+	t.Helper()
+	calls := tb.snctl.Calls("auth activate-service-account")
+	if len(calls) == 0 {
+		t.Fatal("no service account was activated")
+	}
+	return string(calls[len(calls)-1].Stdin)
+}
+
+func TestOrgDefaultAccount(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"key_file_stdin": true})
+	tb.mustRequest(t, logical.UpdateOperation, "config/account/test-org", map[string]interface{}{
+		"key-file": testKeyFileFor("org-client"),
+	})
+	tb.writeRole(t, "own", map[string]interface{}{"key-file": testKeyFileFor("own-client")})
+	tb.mustRequest(t, logical.UpdateOperation, "roles/defaulted", map[string]interface{}{
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/own", nil)
+	if key := tb.lastActivatedKey(t); !strings.Contains(key, "own-client") {
+		t.Fatalf("expected the role's own key, got %s", key)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/defaulted", nil)
+	if key := tb.lastActivatedKey(t); !strings.Contains(key, "org-client") {
+		t.Fatalf("expected the organization default key, got %s", key)
+	}
+
+	// Tokens minted with the old default are not served after it changes.
+	minted := len(tb.snctl.Calls("auth get-token"))
+	tb.mustRequest(t, logical.ReadOperation, "creds/defaulted", nil)
+	if calls := len(tb.snctl.Calls("auth get-token")); calls != minted {
+		t.Fatalf("expected the token to be cached, got %d mints", calls-minted)
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "config/account/test-org", map[string]interface{}{
+		"key-file": testKeyFileFor("rotated-client"),
+	})
+	tb.mustRequest(t, logical.ReadOperation, "creds/defaulted", nil)
+	if calls := len(tb.snctl.Calls("auth get-token")); calls != minted+1 {
+		t.Fatalf("expected a new token after the default changed, got %d mints", calls-minted)
+	}
+	if key := tb.lastActivatedKey(t); !strings.Contains(key, "rotated-client") {
+		t.Fatalf("expected the updated default key, got %s", key)
+	}
+
+	tb.mustRequest(t, logical.DeleteOperation, "config/account/test-org", nil)
+	resp, err := tb.request(t, logical.ReadOperation, "creds/defaulted", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "no default account") {
+		t.Fatalf("expected a role without a key or default to be refused, got %q", msg)
+	}
+}
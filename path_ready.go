@@ -0,0 +1,39 @@
+package streamnative
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathReady is a cheap readiness probe. It never runs snctl, so it is safe to
+// poll frequently.
+func (b *backend) pathReady() *framework.Path {
+	return &framework.Path{
+		Pattern: "ready$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleReady,
+				Summary:  "Report whether the backend is ready to serve tokens.",
+			},
+		},
+
+		HelpSynopsis:    "Lightweight readiness probe.",
+		HelpDescription: "Reports whether the backend is mounted, initialized, and outside any backend-wide freeze window, without invoking snctl.",
+	}
+}
+
+func (b *backend) handleReady(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	initialized := b.initialized.Load()
+	_, frozen := b.config().Freeze.active(time.Now())
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"ready":       initialized && !frozen,
+			"initialized": initialized,
+			"frozen":      frozen,
+		},
+	}, nil
+}
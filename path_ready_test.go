@@ -0,0 +1,21 @@
+package streamnative
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReadyRunsNoCommand(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.runner = func(cmd *exec.Cmd) error {
+		t.Errorf("ready ran %q", cmd.Args)
+		return nil
+	}
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "ready", nil)
+	if resp.Data["ready"] != true || resp.Data["initialized"] != true {
+		t.Fatalf("expected the backend to be ready, got %v", resp.Data)
+	}
+}
@@ -0,0 +1,336 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rolePrefix is the storage prefix of roles.
+const rolePrefix = "roles/"
+
+// roleStorageKey is where the role name is stored.
+func roleStorageKey(name string) string {
+	return rolePrefix + name
+}
+
+// validateRoleName checks a role name. Names may be namespaced with slashes,
+// such as team-a/prod, but no segment may be empty, "." or "..".
+func validateRoleName(name string) error {
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("role name '%s' has an empty, '.' or '..' segment", name)
+		}
+	}
+	return nil
+}
+
+// listRoleNames returns the names of every role, including those in
+// namespaces.
+func listRoleNames(ctx context.Context, s logical.Storage) ([]string, error) {
+	var names []string
+	prefixes := []string{""}
+	for len(prefixes) > 0 {
+		prefix := prefixes[0]
+		prefixes = prefixes[1:]
+		keys, err := s.List(ctx, rolePrefix+prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if strings.HasSuffix(key, "/") {
+				prefixes = append(prefixes, prefix+key)
+			} else {
+				names = append(names, prefix+key)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (b *backend) pathRoles() []*framework.Path {
+	fields := accountSchema()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the role.",
+	}
+	fields[writeOperationField] = &framework.FieldSchema{
+		Type:          framework.TypeString,
+		Description:   "Set to create_only to fail if the role exists, or update_only to fail if it does not. Not stored.",
+		AllowedValues: []interface{}{writeCreateOnly, writeUpdateOnly},
+	}
+
+	return []*framework.Path{
+		{
+			// Only paths ending in a slash list, so that roles/team-a/
+			// lists a namespace while roles/team-a is a role.
+			Pattern: "roles/?(?P<namespace>(.+/)?)$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"namespace": {
+					Type:        framework.TypeString,
+					Description: "Namespace to list the roles of, ending in a slash.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleRoleList,
+					Summary:  "List the roles and namespaces at the top level, or in a namespace.",
+				},
+			},
+
+			HelpSynopsis:    "List the roles.",
+			HelpDescription: "Lists the roles and namespaces at roles/, or in the namespace at roles/<namespace>/. Namespaces are listed with a trailing slash.",
+		},
+		{
+			Pattern: "roles/" + framework.MatchAllRegex("name"),
+
+			Fields: fields,
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRoleRead,
+					Summary:  "Return the role's settings. The key file's secret is never returned.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRoleWrite,
+					Summary:  "Store a role's service account and settings.",
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.handleRoleWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleRoleDelete,
+					Summary:  "Delete the role.",
+				},
+			},
+
+			ExistenceCheck: b.handleRoleExistenceCheck,
+
+			HelpSynopsis:    "Manage the service accounts tokens are generated for.",
+			HelpDescription: "A role holds a StreamNative service account key and the organization and cluster to mint tokens for. Tokens are read from creds/<name>.",
+		},
+	}
+}
+
+func (b *backend) pathCreds() *framework.Path {
+	fields := tokenRequestFields()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the role.",
+	}
+
+	return &framework.Path{
+		Pattern: "creds/" + framework.MatchAllRegex("name"),
+
+		Fields: fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleCredsRead,
+				Summary:  "Generate a token for the role.",
+			},
+		},
+
+		HelpSynopsis:    "Generate a Pulsar token for a role.",
+		HelpDescription: "Mints a token with the service account stored in roles/<name>.",
+	}
+}
+
+func (b *backend) handleCredsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	resp, err := b.issueToken(ctx, req, roleStorageKey(name), tokenRequestParams(req.Data))
+	if err != nil {
+		return readErrorResponse(req, err)
+	}
+	return resp, nil
+}
+
+func (b *backend) handleRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.readRole(ctx, req.Storage, data.Get("name").(string))
+	if err != nil || role == nil {
+		return nil, err
+	}
+	return &logical.Response{Data: roleMetadata(role)}, nil
+}
+
+// readRole returns the named role, or nil if it does not exist.
+func (b *backend) readRole(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error) {
+	ent, err := s.Get(ctx, roleStorageKey(name))
+	if err != nil {
+		b.Logger().Error("Reading from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	if ent == nil {
+		return nil, nil
+	}
+	var role map[string]interface{}
+	if err := jsonutil.DecodeJSON(ent.Value, &role); err != nil {
+		b.Logger().Error("JSON decoding failed", "error", err)
+		return nil, errwrap.Wrapf("json decoding failed: {{err}}", err)
+	}
+	if err := decryptKeyFiles(ctx, b.config(), role); err != nil {
+		return nil, err
+	}
+	migrateDefaultCluster(role)
+	return role, nil
+}
+
+// roleMetadata describes a stored role. Key files are identified only by
+// their client_id and a fingerprint; their secrets are never returned.
+func roleMetadata(role map[string]interface{}) map[string]interface{} {
+	meta := make(map[string]interface{})
+	for field := range accountSchema() {
+		switch field {
+		case "key-file", "key_file_base64", "key_files":
+			continue
+		}
+		if value, ok := role[field]; ok {
+			meta[field] = value
+		}
+	}
+	if last, ok := role["last_rotation"]; ok {
+		meta["last_rotation"] = last
+	}
+	if next, ok := nextRotation(role); ok {
+		meta["next_rotation"] = next.UTC().Format(time.RFC3339)
+		meta["rotation_overdue"] = time.Now().After(next)
+	}
+	keyFiles := accountKeyFiles(role)
+	if len(keyFiles) == 0 {
+		meta["uses_organization_key"] = true
+	}
+	fingerprints := make([]string, 0, len(keyFiles))
+	clientIDs := make([]string, 0, len(keyFiles))
+	for _, keyFile := range keyFiles {
+		fingerprints = append(fingerprints, fingerprint([]byte(keyFile)))
+		var key serviceAccountKey
+		_ = jsonutil.DecodeJSON([]byte(keyFile), &key)
+		clientIDs = append(clientIDs, key.ClientID)
+	}
+	if _, ok := role["key_files"]; ok {
+		meta["key_fingerprints"] = fingerprints
+		meta["client_ids"] = clientIDs
+	} else if len(keyFiles) == 1 {
+		meta["key_fingerprint"] = fingerprints[0]
+		meta["client_id"] = clientIDs[0]
+	}
+	return meta
+}
+
+func (b *backend) handleRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if err := validateRoleName(name); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	operation, err := parseWriteOperation(data.Get(writeOperationField))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	path := roleStorageKey(name)
+	// Hold the role's write lock from reading it until the merged role is
+	// stored, so a concurrent update is not lost.
+	lock := locksutil.LockForKey(b.writeLocks, path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	stored, err := b.readRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if operation == writeCreateOnly && stored != nil {
+		return nil, logical.CodedError(http.StatusConflict,
+			fmt.Sprintf("role '%s' already exists; operation=create_only does not replace it", name))
+	}
+	if operation == writeUpdateOnly && stored == nil {
+		return nil, logical.CodedError(http.StatusConflict,
+			fmt.Sprintf("role '%s' does not exist; operation=update_only does not create it", name))
+	}
+
+	// An update changes only the fields it sets.
+	role := make(map[string]interface{})
+	if stored != nil && req.Operation == logical.UpdateOperation {
+		role = stored
+	}
+	_, hasKeyFile := data.GetOk("key-file")
+	_, hasEncoded := data.GetOk("key_file_base64")
+	_, hasKeyFiles := data.GetOk("key_files")
+	if hasKeyFile || hasEncoded || hasKeyFiles {
+		// A new key replaces the stored ones, whichever way they were set.
+		delete(role, "key-file")
+		delete(role, "key_file_base64")
+		delete(role, "key_files")
+	}
+	if _, hasCluster := data.GetOk("cluster"); hasCluster {
+		delete(role, "default_cluster")
+	}
+	for field := range accountSchema() {
+		if value, ok := data.GetOk(field); ok {
+			role[field] = value
+		}
+	}
+
+	// A role without a key of its own uses its organization's default.
+	if keyFile, ok := role["key-file"].(string); ok && keyFile == "" {
+		delete(role, "key-file")
+	}
+	if encoded, ok := role["key_file_base64"].(string); ok && encoded == "" {
+		delete(role, "key_file_base64")
+	}
+	if role["key-file"] == nil && role["key_file_base64"] == nil && role["key_files"] == nil {
+		org, _ := role["organization"].(string)
+		if org != "" {
+			account, err := b.readOrgAccount(ctx, req.Storage, org)
+			if err != nil {
+				return nil, err
+			}
+			if account == nil {
+				return missingKeyResponse(role), nil
+			}
+		}
+	}
+
+	b.cache.invalidate(path)
+	return b.storeAccount(ctx, req.Storage, path, role)
+}
+
+func (b *backend) handleRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := roleStorageKey(data.Get("name").(string))
+
+	b.cache.invalidate(path)
+
+	if err := req.Storage.Delete(ctx, path); err != nil {
+		b.Logger().Error("Deleting from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Deleting from storage failed: {{err}}", err)
+	}
+	return nil, nil
+}
+
+func (b *backend) handleRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, rolePrefix+data.Get("namespace").(string))
+	if err != nil {
+		b.Logger().Error("Listing storage failed", "error", err)
+		return nil, errwrap.Wrapf("Listing storage failed: {{err}}", err)
+	}
+	return logical.ListResponse(names), nil
+}
+
+// handleRoleExistenceCheck reports whether the role is stored, so that Vault
+// routes a write to an existing role as an update rather than a create.
+func (b *backend) handleRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, roleStorageKey(data.Get("name").(string)))
+	if err != nil {
+		return false, errwrap.Wrapf("existence check failed: {{err}}", err)
+	}
+	return out != nil, nil
+}
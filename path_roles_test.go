@@ -0,0 +1,267 @@
+package streamnative
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRoleUpdateMerges(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "merged", map[string]interface{}{"allowed_clusters": "test-cluster,other-cluster"})
+
+	tb.mustRequest(t, logical.UpdateOperation, "roles/merged", map[string]interface{}{"default_cluster": "other-cluster"})
+	role := tb.mustRequest(t, logical.ReadOperation, "roles/merged", nil).Data
+	if role["default_cluster"] != "other-cluster" {
+		t.Fatalf("the update was not applied: %v", role)
+	}
+	if role["organization"] != "test-org" || role["client_id"] != "test-client" || role["allowed_clusters"] == nil {
+		t.Fatalf("the update lost fields it did not set: %v", role)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "roles/merged", map[string]interface{}{"key-file": testKeyFileFor("new-client")})
+	role = tb.mustRequest(t, logical.ReadOperation, "roles/merged", nil).Data
+	if role["client_id"] != "new-client" || role["default_cluster"] != "other-cluster" {
+		t.Fatalf("expected only the key to change, got %v", role)
+	}
+
+	resp, err := tb.request(t, logical.UpdateOperation, "roles/partial", map[string]interface{}{"default_cluster": "test-cluster"})
+	if err == nil && !resp.IsError() {
+		t.Fatal("expected creating a role without an organization to fail")
+	}
+}
+
+func TestShadowedAccountsWarned(t *testing.T) {
+	tb := getTestBackend(t)
+	ctx := context.Background()
+	for _, key := range []string{"health", "creds/old", "still-served", "roles/legacy"} {
+		if err := tb.storage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tb.backend.Initialize(ctx, &logical.InitializationRequest{Storage: tb.storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := tb.logs.String()
+	for _, key := range []string{"health", "creds/old"} {
+		if !strings.Contains(logs, "path="+key+"\n") {
+			t.Errorf("expected a warning about %s, got logs:\n%s", key, logs)
+		}
+	}
+	for _, key := range []string{"still-served", "roles/legacy"} {
+		if strings.Contains(logs, "path="+key+"\n") {
+			t.Errorf("unexpected warning about %s:\n%s", key, logs)
+		}
+	}
+}
+
+func TestRoleMetadata(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "described", map[string]interface{}{"allowed_clusters": "test-cluster,other-cluster"})
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "roles/described", nil)
+	if resp.Data["organization"] != "test-org" || resp.Data["default_cluster"] != "test-cluster" {
+		t.Fatalf("expected the role's settings, got %v", resp.Data)
+	}
+	if resp.Data["client_id"] != "test-client" || resp.Data["key_fingerprint"] == nil {
+		t.Fatalf("expected the key's client_id and fingerprint, got %v", resp.Data)
+	}
+	for _, field := range []string{"key-file", "key_file_base64", "key_files", "token"} {
+		if _, ok := resp.Data[field]; ok {
+			t.Errorf("the metadata includes %s", field)
+		}
+	}
+	if out := fmt.Sprint(resp.Data); strings.Contains(out, "test-client-secret") {
+		t.Fatalf("the metadata includes the client secret: %s", out)
+	}
+	if calls := tb.snctl.Calls("auth get-token"); len(calls) != 0 {
+		t.Fatalf("reading the metadata ran snctl: %v", calls)
+	}
+}
+
+func TestExistenceCheck(t *testing.T) {
+	tb := getTestBackend(t)
+	exists := func(path string) bool {
+		t.Helper()
+		found, exists, err := tb.HandleExistenceCheck(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   tb.storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatalf("no existence check for %s", path)
+		}
+		return exists
+	}
+
+	writes := map[string]map[string]interface{}{
+		"roles/checked":           testAccount(testKeyFile),
+		"config/account/test-org": {"key-file": testKeyFile},
+		"team/checked":            testAccount(testKeyFile),
+	}
+	for path, data := range writes {
+		if exists(path) {
+			t.Fatalf("%s exists before it is written, so its first write would be an update", path)
+		}
+		tb.mustRequest(t, logical.UpdateOperation, path, data)
+		if !exists(path) {
+			t.Fatalf("%s does not exist after it is written, so a second write would be a create", path)
+		}
+	}
+
+	// An update routed as a create would require the role's key-file again.
+	tb.mustRequest(t, logical.UpdateOperation, "roles/checked", map[string]interface{}{"default_cluster": "other-cluster"})
+}
+
+func TestWriteOperation(t *testing.T) {
+	tb := getTestBackend(t)
+	conflict := func(resp *logical.Response, err error) bool {
+		var coded logical.HTTPCodedError
+		return errors.As(err, &coded) && coded.Code() == http.StatusConflict
+	}
+	role := func(clientID string, operation string) map[string]interface{} {
+		return map[string]interface{}{
+			"key-file":        testKeyFileFor(clientID),
+			"organization":    "test-org",
+			"default_cluster": "test-cluster",
+			"operation":       operation,
+		}
+	}
+	legacy := func(clientID string, operation string) map[string]interface{} {
+		data := role(clientID, operation)
+		delete(data, "default_cluster")
+		data["cluster"] = "test-cluster"
+		return data
+	}
+
+	for _, prefix := range []string{"roles/", ""} {
+		data := role
+		if prefix == "" {
+			data = legacy
+		}
+		path := prefix + "provisioned"
+
+		if resp, err := tb.request(t, logical.UpdateOperation, path, data("first-client", writeUpdateOnly)); !conflict(resp, err) {
+			t.Fatalf("%s: expected update_only of an absent account to conflict, got %q", path, errorText(resp, err))
+		}
+		if entry, _ := tb.storage.Get(context.Background(), path); entry != nil {
+			t.Fatalf("%s: the rejected update_only write created the account", path)
+		}
+		tb.mustRequest(t, logical.UpdateOperation, path, data("first-client", writeCreateOnly))
+		if resp, err := tb.request(t, logical.UpdateOperation, path, data("second-client", writeCreateOnly)); !conflict(resp, err) {
+			t.Fatalf("%s: expected create_only of an existing account to conflict, got %q", path, errorText(resp, err))
+		}
+		if account := tb.storedAccount(t, path); !strings.Contains(fmt.Sprint(account["key-file"]), "first-client") {
+			t.Fatalf("%s: the rejected create_only write replaced the account", path)
+		}
+		tb.mustRequest(t, logical.UpdateOperation, path, data("second-client", writeUpdateOnly))
+		account := tb.storedAccount(t, path)
+		if !strings.Contains(fmt.Sprint(account["key-file"]), "second-client") {
+			t.Fatalf("%s: expected update_only to replace the account", path)
+		}
+		if _, ok := account["operation"]; ok {
+			t.Fatalf("%s: operation was stored", path)
+		}
+
+		resp, err := tb.request(t, logical.UpdateOperation, path, data("third-client", "upsert"))
+		if msg := errorText(resp, err); !strings.Contains(msg, "create_only") {
+			t.Fatalf("%s: expected an unknown operation to be rejected, got %q", path, msg)
+		}
+	}
+
+	// Of concurrent create_only writes, exactly one succeeds.
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := tb.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "roles/raced",
+				Data:      role(fmt.Sprintf("client-%d", i), writeCreateOnly),
+				Storage:   tb.storage,
+			})
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		} else if !conflict(nil, err) {
+			t.Fatalf("expected losing writes to conflict, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one create_only write to succeed, got %d", succeeded)
+	}
+}
+
+func TestNamespacedRoles(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"key_file_stdin": true})
+	tb.writeRole(t, "team-a/prod", map[string]interface{}{"key-file": testKeyFileFor("team-a-client")})
+	tb.writeRole(t, "team-b/prod", map[string]interface{}{"key-file": testKeyFileFor("team-b-client")})
+	tb.writeRole(t, "prod", nil)
+
+	list := func(path string) []string {
+		t.Helper()
+		resp := tb.mustRequest(t, logical.ListOperation, path, nil)
+		keys, _ := resp.Data["keys"].([]string)
+		return keys
+	}
+	if keys := list("roles/"); !reflect.DeepEqual(keys, []string{"prod", "team-a/", "team-b/"}) {
+		t.Fatalf("expected the top-level role and both namespaces, got %v", keys)
+	}
+	if keys := list("roles/team-a/"); !reflect.DeepEqual(keys, []string{"prod"}) {
+		t.Fatalf("expected the namespace's role, got %v", keys)
+	}
+
+	for team, client := range map[string]string{"team-a": "team-a-client", "team-b": "team-b-client"} {
+		resp := tb.mustRequest(t, logical.ReadOperation, "roles/"+team+"/prod", nil)
+		if resp.Data["key_fingerprint"] != fingerprint([]byte(testKeyFileFor(client))) {
+			t.Errorf("%s: expected its own key, got %v", team, resp.Data["key_fingerprint"])
+		}
+		tb.mustRequest(t, logical.ReadOperation, "creds/"+team+"/prod", nil)
+		if key := tb.lastActivatedKey(t); !strings.Contains(key, client) {
+			t.Errorf("%s: expected tokens minted with its own key, got %s", team, key)
+		}
+	}
+
+	// An update, routed through the existence check, changes only its role.
+	tb.mustRequest(t, logical.UpdateOperation, "roles/team-a/prod", map[string]interface{}{"allowed_clusters": "test-cluster"})
+	if resp := tb.mustRequest(t, logical.ReadOperation, "roles/team-b/prod", nil); resp.Data["allowed_clusters"] != nil {
+		t.Fatalf("updating team-a/prod changed team-b/prod: %v", resp.Data["allowed_clusters"])
+	}
+
+	tb.mustRequest(t, logical.DeleteOperation, "roles/team-a/prod", nil)
+	if resp := tb.mustRequest(t, logical.ReadOperation, "roles/team-a/prod", nil); resp != nil {
+		t.Fatalf("expected team-a/prod to be deleted, got %v", resp.Data)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "roles/team-b/prod", nil)
+	tb.mustRequest(t, logical.ReadOperation, "roles/prod", nil)
+	if keys := list("roles/"); !reflect.DeepEqual(keys, []string{"prod", "team-b/"}) {
+		t.Fatalf("expected the emptied namespace to disappear, got %v", keys)
+	}
+
+	for _, name := range []string{"team-a//prod", "team-a/../prod", "./prod"} {
+		resp, err := tb.request(t, logical.UpdateOperation, "roles/"+name, testAccount(testKeyFile))
+		if msg := errorText(resp, err); !strings.Contains(msg, "segment") {
+			t.Errorf("%s: expected the name to be rejected, got %q", name, msg)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package streamnative
+
+import (
+	"context"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathRotate() *framework.Path {
+	return &framework.Path{
+		Pattern: "rotate/" + framework.MatchAllRegex("path"),
+
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Specifies the path of the service account.",
+			},
+			"key-file": {
+				Type:        framework.TypeString,
+				Description: "The new service account key file.",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleRotate,
+				Summary:  "Replace the key file of a stored service account.",
+			},
+		},
+
+		HelpSynopsis:    "Rotate a service account key.",
+		HelpDescription: "Replaces only the key file of the service account at path, keeping its other settings. The account is validated as on a write. Cached tokens for the account are dropped. Roles and other internal paths cannot be rotated here; write key-file to roles/<name> instead.",
+	}
+}
+
+func (b *backend) handleRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if isInternalKey(path) {
+		// Roles have their own ACLs; their keys are replaced by writing
+		// key-file to roles/<name>.
+		return logical.ErrorResponse("'%s' is not a service account path; to rotate a role's key, write key-file to roles/<name>", path), nil
+	}
+	keyFile := data.Get("key-file").(string)
+	if keyFile == "" {
+		return logical.ErrorResponse("'key-file' is required"), nil
+	}
+	if err := validateKeyFile(keyFile); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Hold the account's write lock from reading it until the rotated
+	// account is stored, so a concurrent write is not lost.
+	lock := locksutil.LockForKey(b.writeLocks, path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ent, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Reading from storage failed", "error", err)
+		return nil, errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	if ent == nil || ent.Value == nil {
+		return logical.ErrorResponse("No value at %v%v", req.MountPoint, path), nil
+	}
+	var account map[string]interface{}
+	if err := jsonutil.DecodeJSON(ent.Value, &account); err != nil {
+		return logical.ErrorResponse("No service account at %v%v", req.MountPoint, path), nil
+	}
+	if _, ok := account["organization"].(string); !ok {
+		return logical.ErrorResponse("No service account at %v%v", req.MountPoint, path), nil
+	}
+
+	// The new key replaces every key of an account with failover keys.
+	delete(account, "key_files")
+	delete(account, keyEncryptionField)
+	account["key-file"] = keyFile
+
+	b.Logger().Info("Rotating service account key", "path", path)
+	resp, err := b.storeAccount(ctx, req.Storage, path, account)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return resp, err
+	}
+	b.cache.invalidate(path)
+
+	return resp, nil
+}
@@ -0,0 +1,76 @@
+package streamnative
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRotate(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "rotated", map[string]interface{}{
+		"key-file":         testKeyFile,
+		"organization":     "test-org",
+		"default_cluster":  "test-cluster",
+		"allowed_clusters": "test-cluster,other-cluster",
+	})
+	activateInHome(t, tb)
+	subject := func() interface{} {
+		resp := tb.mustRequest(t, logical.ReadOperation, "rotated", nil)
+		claims, err := decodeJwtClaims(resp.Data["token"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims["sub"]
+	}
+	if sub := subject(); sub != "test-client" {
+		t.Fatalf("expected a token for test-client, got %v", sub)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "rotate/rotated", map[string]interface{}{"key-file": testKeyFileFor("new-client")})
+	if sub := subject(); sub != "new-client" {
+		t.Fatalf("expected the read after the rotation to use the new key, got a token for %v", sub)
+	}
+
+	account := tb.storedAccount(t, "rotated")
+	if account["organization"] != "test-org" || account["default_cluster"] != "test-cluster" {
+		t.Fatalf("the rotation lost the account's settings: %v", account)
+	}
+	if clusters, _ := account["allowed_clusters"].([]interface{}); len(clusters) != 2 {
+		t.Fatalf("the rotation lost allowed_clusters: %v", account["allowed_clusters"])
+	}
+}
+
+func TestRotateRefused(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "role", nil)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"token_field": "access_token"})
+	entry := &logical.StorageEntry{Key: "notes/plain", Value: []byte(`{"note": "not an account"}`)}
+	if err := tb.storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"roles/role", "config/snctl", "wal/entry", "notes/plain", "missing"} {
+		resp, err := tb.request(t, logical.UpdateOperation, "rotate/"+path, map[string]interface{}{"key-file": testKeyFileFor("new-client")})
+		if errorText(resp, err) == "" {
+			t.Errorf("expected rotating %s to be refused", path)
+		}
+	}
+	if account := tb.storedAccount(t, "roles/role"); strings.Contains(account["key-file"].(string), "new-client") {
+		t.Fatal("the role's key was rotated")
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "rotated", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"max_entry_bytes": 512})
+	padded := strings.Replace(testKeyFile, `"type"`, `"padding":"`+strings.Repeat("x", 512)+`","type"`, 1)
+	resp, err := tb.request(t, logical.UpdateOperation, "rotate/rotated", map[string]interface{}{"key-file": padded})
+	if msg := errorText(resp, err); !strings.Contains(msg, "max_entry_bytes") {
+		t.Fatalf("expected the size limit to apply, got %q", msg)
+	}
+}
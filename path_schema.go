@@ -0,0 +1,89 @@
+package streamnative
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathSchema describes the fields an account or role write accepts, so that
+// configuration generators need not hard-code them.
+func (b *backend) pathSchema() *framework.Path {
+	return &framework.Path{
+		Pattern: "schema$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleSchema,
+				Summary:  "Describe the fields an account or role write accepts.",
+			},
+		},
+
+		HelpSynopsis:    "Describe the account write fields.",
+		HelpDescription: "Returns a JSON Schema object describing the fields accepted when writing an account or a role: their types, whether they are required, and descriptions.",
+	}
+}
+
+func (b *backend) handleSchema(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: writeSchema(accountSchema()),
+	}, nil
+}
+
+// writeSchema converts fields into a JSON Schema object.
+func writeSchema(fields map[string]*framework.FieldSchema) map[string]interface{} {
+	properties := make(map[string]interface{}, len(fields))
+	required := []string{}
+	for name, field := range fields {
+		property := map[string]interface{}{
+			"description": field.Description,
+		}
+		schemaType, format := jsonSchemaType(field.Type)
+		property["type"] = schemaType
+		if format != "" {
+			property["format"] = format
+		}
+		if schemaType == "array" {
+			property["items"] = map[string]interface{}{"type": "string"}
+		}
+		if field.Deprecated {
+			property["deprecated"] = true
+		}
+		if field.Default != nil {
+			property["default"] = field.Default
+		}
+		properties[name] = property
+		if field.Required {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// jsonSchemaType returns the JSON Schema type and format of a field type,
+// following Vault's own OpenAPI document.
+func jsonSchemaType(t framework.FieldType) (string, string) {
+	switch t {
+	case framework.TypeBool:
+		return "boolean", ""
+	case framework.TypeInt, framework.TypeInt64:
+		return "integer", ""
+	case framework.TypeFloat:
+		return "number", ""
+	case framework.TypeDurationSecond, framework.TypeSignedDurationSecond:
+		return "string", "duration"
+	case framework.TypeMap, framework.TypeKVPairs:
+		return "object", ""
+	case framework.TypeSlice, framework.TypeStringSlice, framework.TypeCommaStringSlice:
+		return "array", ""
+	default:
+		return "string", ""
+	}
+}
@@ -0,0 +1,37 @@
+package streamnative
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSchema(t *testing.T) {
+	tb := getTestBackend(t)
+	resp := tb.mustRequest(t, logical.ReadOperation, "schema", nil)
+	if resp.Data["type"] != "object" {
+		t.Fatalf("expected an object schema, got %v", resp.Data["type"])
+	}
+	properties, _ := resp.Data["properties"].(map[string]interface{})
+	required, _ := resp.Data["required"].([]string)
+	if !reflect.DeepEqual(required, []string{"key-file", "organization"}) {
+		t.Fatalf("unexpected required fields %v", required)
+	}
+
+	for name, want := range map[string]string{"key-file": "string", "organization": "string", "cluster": "string", "allowed_clusters": "array"} {
+		property, ok := properties[name].(map[string]interface{})
+		if !ok {
+			t.Fatalf("the schema does not list %s", name)
+		}
+		if property["type"] != want {
+			t.Errorf("expected %s to be a %s, got %v", name, want, property["type"])
+		}
+		if description, _ := property["description"].(string); description == "" {
+			t.Errorf("%s has no description", name)
+		}
+	}
+	if cluster := properties["cluster"].(map[string]interface{}); cluster["deprecated"] != true {
+		t.Errorf("expected cluster to be deprecated in favor of default_cluster, got %v", cluster)
+	}
+}
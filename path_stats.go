@@ -0,0 +1,51 @@
+package streamnative
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathStats() *framework.Path {
+	return &framework.Path{
+		Pattern: "stats$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.handleStats,
+				Summary:  "Report runtime statistics of the backend.",
+			},
+		},
+
+		HelpSynopsis:    "Runtime statistics.",
+		HelpDescription: "Reports the state of the snctl circuit breaker and the token generations in flight.",
+	}
+}
+
+func (b *backend) handleStats(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.config()
+	state, failures, openedAt := b.breaker.status()
+
+	breaker := map[string]interface{}{
+		"enabled":              conf.BreakerThreshold > 0,
+		"state":                state,
+		"consecutive_failures": failures,
+		"threshold":            conf.BreakerThreshold,
+		"cooldown_seconds":     int64(conf.BreakerCooldown / time.Second),
+	}
+	if !openedAt.IsZero() {
+		breaker["opened_at"] = openedAt.UTC().Format(time.RFC3339)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"circuit_breaker": breaker,
+			"concurrency": map[string]interface{}{
+				"limit":     conf.MaxConcurrentTokens,
+				"in_flight": b.limiter.inFlight(),
+			},
+		},
+	}, nil
+}
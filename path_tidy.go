@@ -0,0 +1,39 @@
+package streamnative
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathTidyCache drops every cached token, for example during a security
+// incident. It has its own path so that ACL policies can reserve it for
+// administrators.
+func (b *backend) pathTidyCache() *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy/cache$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleTidyCache,
+				Summary:  "Drop every cached token.",
+			},
+		},
+
+		HelpSynopsis: "Drop every cached token.",
+		HelpDescription: "Clears the in-memory token cache of all accounts and roles, so every later read mints a new token. " +
+			"Tokens already handed out remain valid until they expire. API keys are not affected; revoke their leases " +
+			"with `vault lease revoke -prefix` on the mount's apikey/ path.",
+	}
+}
+
+func (b *backend) handleTidyCache(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cleared := b.cache.flush()
+	b.Logger().Warn("Cleared the token cache", "cleared", cleared)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"cleared": cleared,
+		},
+	}, nil
+}
@@ -0,0 +1,35 @@
+package streamnative
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTidyCache(t *testing.T) {
+	tb := getTestBackend(t)
+	roles := []string{"first", "second", "third"}
+	for _, role := range roles {
+		tb.writeRole(t, role, map[string]interface{}{"key-file": testKeyFileFor(role + "-client")})
+		tb.mustRequest(t, logical.ReadOperation, "creds/"+role, nil)
+	}
+	minted := len(tb.snctl.Calls("auth get-token"))
+
+	resp := tb.mustRequest(t, logical.UpdateOperation, "tidy/cache", nil)
+	if resp.Data["cleared"] != len(roles) {
+		t.Fatalf("expected %d cleared tokens, got %v", len(roles), resp.Data["cleared"])
+	}
+	tb.cache.mu.Lock()
+	cached := len(tb.cache.entries)
+	tb.cache.mu.Unlock()
+	if cached != 0 {
+		t.Fatalf("expected the cache to be empty, got %d tokens", cached)
+	}
+
+	for _, role := range roles {
+		tb.mustRequest(t, logical.ReadOperation, "creds/"+role, nil)
+	}
+	if n := len(tb.snctl.Calls("auth get-token")) - minted; n != len(roles) {
+		t.Fatalf("expected every read to mint again, got %d mints", n)
+	}
+}
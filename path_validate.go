@@ -0,0 +1,64 @@
+package streamnative
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathValidate() *framework.Path {
+	schema := accountSchema()
+	return &framework.Path{
+		Pattern: "validate$",
+
+		Fields: map[string]*framework.FieldSchema{
+			"key-file":     schema["key-file"],
+			"organization": schema["organization"],
+			"cluster": {
+				Type:        framework.TypeString,
+				Description: "The Pulsar cluster to mint a token for.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleValidate,
+				Summary:  "Check that a service account can mint tokens, without storing it.",
+			},
+		},
+
+		HelpSynopsis:    "Test a service account before storing it.",
+		HelpDescription: "Activates the service account and mints a token for the cluster exactly as a read would. Reports whether that succeeded; the token is discarded and nothing is stored.",
+	}
+}
+
+func (b *backend) handleValidate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	account := make(map[string]interface{})
+	for _, field := range []string{"key-file", "organization", "cluster"} {
+		value, ok := data.GetOk(field)
+		if !ok || value.(string) == "" {
+			return logical.ErrorResponse("'%s' is required", field), nil
+		}
+		account[field] = value
+	}
+	if invalidResponse := validateWriteData(account); invalidResponse != nil {
+		return invalidResponse, nil
+	}
+
+	// The token is discarded, so a failure here says nothing about the
+	// health of token generation and is kept out of the circuit breaker.
+	if _, err := b.readNewToken(ctx, b.config(), account, ""); err != nil {
+		return &logical.Response{
+			Data: withExitCode(map[string]interface{}{
+				"valid": false,
+				"error": err.Error(),
+			}, err),
+		}, nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"valid": true,
+		},
+	}, nil
+}
@@ -0,0 +1,53 @@
+package streamnative
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestValidate(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_ISOLATE_HOME", "true")
+	tempDir := tb.config().TempDir
+	validate := func() *logical.Response {
+		return tb.mustRequest(t, logical.UpdateOperation, "validate", map[string]interface{}{
+			"key-file":     testKeyFile,
+			"organization": "test-org",
+			"cluster":      "test-cluster",
+		})
+	}
+
+	if resp := validate(); resp.Data["valid"] != true || len(resp.Data) != 1 {
+		t.Fatalf("expected only valid=true, got %v", resp.Data)
+	}
+
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	failGetToken(tb, 1, "error: unauthorized_client")
+	resp := validate()
+	if resp.Data["valid"] != false || !strings.Contains(resp.Data["error"].(string), "unauthorized_client") {
+		t.Fatalf("expected the failure to be reported, got %v", resp.Data)
+	}
+	if _, ok := resp.Data["token"]; ok {
+		t.Fatal("the validation returned a token")
+	}
+
+	if keys, err := logical.CollectKeys(context.Background(), tb.storage); err != nil || len(keys) != 0 {
+		t.Fatalf("expected nothing to be stored, got %v, %v", keys, err)
+	}
+	homes, _ := filepath.Glob(filepath.Join(tempDir, "snio-home-*"))
+	if left := append(keyFilesLeft(t, tempDir), homes...); len(left) != 0 {
+		t.Fatalf("the validation left temporary files behind: %v", left)
+	}
+
+	r, err := tb.request(t, logical.UpdateOperation, "validate", map[string]interface{}{
+		"key-file":     testKeyFile,
+		"organization": "test-org",
+	})
+	if msg := errorText(r, err); !strings.Contains(msg, "'cluster' is required") {
+		t.Fatalf("expected a missing cluster to be rejected, got %q", msg)
+	}
+}
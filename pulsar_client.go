@@ -0,0 +1,62 @@
+package streamnative
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatPulsarClient is the read format that adds a Pulsar client
+// configuration to the response.
+const formatPulsarClient = "pulsar_client"
+
+// validateFormat checks a read's format parameter.
+func validateFormat(format string) error {
+	switch format {
+	case "", formatPulsarClient:
+		return nil
+	}
+	return fmt.Errorf("format must be '%s' when set", formatPulsarClient)
+}
+
+// serviceURLPlaceholders are the placeholders of a service URL template, every
+// one of which must be used so that each cluster gets its own URL.
+var serviceURLPlaceholders = []string{"{organization}", "{cluster}"}
+
+// validateServiceURLTemplate checks the placeholders of a service URL
+// template.
+func validateServiceURLTemplate(template string) error {
+	for _, placeholder := range serviceURLPlaceholders {
+		if !strings.Contains(template, placeholder) {
+			return fmt.Errorf("service_url_template must contain %s", placeholder)
+		}
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+		if !containsString(serviceURLPlaceholders, placeholder) {
+			return fmt.Errorf("service_url_template has an unknown placeholder %s", placeholder)
+		}
+	}
+	return nil
+}
+
+// pulsarServiceURL derives the cluster's service URL from the configured
+// template, replacing {organization} and {cluster}. It reports false when no
+// template is configured.
+func pulsarServiceURL(conf *snctlConfig, data map[string]interface{}) (string, bool) {
+	if conf.ServiceURLTemplate == "" {
+		return "", false
+	}
+	return strings.NewReplacer(
+		"{organization}", fmt.Sprint(data["organization"]),
+		"{cluster}", fmt.Sprint(data["cluster"]),
+	).Replace(conf.ServiceURLTemplate), true
+}
+
+// pulsarClientConfig is a Pulsar client configuration authenticating with
+// token, in the form accepted by Pulsar client factories.
+func pulsarClientConfig(serviceURL, token string) map[string]interface{} {
+	return map[string]interface{}{
+		"serviceUrl": serviceURL,
+		"authPlugin": "token",
+		"authParams": "token:" + token,
+	}
+}
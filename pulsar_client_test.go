@@ -0,0 +1,65 @@
+package streamnative
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPulsarClientFormat(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_SERVICE_URL_TEMPLATE", "pulsar+ssl://{cluster}.{organization}.example.com:6651")
+	tb.writeRole(t, "client", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/client", map[string]interface{}{"format": "pulsar_client"})
+	token := resp.Data["token"].(string)
+	want := map[string]interface{}{
+		"serviceUrl": "pulsar+ssl://test-cluster.test-org.example.com:6651",
+		"authPlugin": "token",
+		"authParams": "token:" + token,
+	}
+	if got := resp.Data["pulsar_client"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestPulsarClientFormatWithoutServiceURL(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "client", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/client", map[string]interface{}{"format": "pulsar_client"})
+	if _, ok := resp.Data["pulsar_client"]; ok || resp.Data["token"] == nil {
+		t.Fatalf("expected only the token, got %v", resp.Data)
+	}
+	if len(resp.Warnings) == 0 || !strings.Contains(resp.Warnings[0], "set service_url_template on config/snctl") {
+		t.Fatalf("expected a warning about the service URL, got %v", resp.Warnings)
+	}
+}
+
+func TestServiceURLTemplateConfig(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_SERVICE_URL_TEMPLATE", "pulsar+ssl://{cluster}.{organization}.env.example.com:6651")
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"service_url_template": "pulsar+ssl://{cluster}.{organization}.config.example.com:6651",
+	})
+	tb.writeRole(t, "client", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/client", map[string]interface{}{"format": "pulsar_client"})
+	client, _ := resp.Data["pulsar_client"].(map[string]interface{})
+	if url := client["serviceUrl"]; url != "pulsar+ssl://test-cluster.test-org.config.example.com:6651" {
+		t.Fatalf("expected the stored template to override the environment's, got %v", url)
+	}
+
+	for template, want := range map[string]string{
+		"pulsar+ssl://pulsar.example.com:6651":                       "must contain {organization}",
+		"pulsar+ssl://{organization}.example.com:6651":               "must contain {cluster}",
+		"pulsar+ssl://{cluster}.{organization}.{region}.example.com": "unknown placeholder {region}",
+	} {
+		resp, err := tb.request(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"service_url_template": template})
+		if msg := errorText(resp, err); !strings.Contains(msg, want) {
+			t.Errorf("%s: expected %q, got %q", template, want, msg)
+		}
+	}
+}
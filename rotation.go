@@ -0,0 +1,69 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rotationCheckInterval is how often the periodic function looks for roles
+// overdue for key rotation.
+const rotationCheckInterval = time.Hour
+
+// parseRotationPeriod accepts a duration such as "720h", or seconds.
+func parseRotationPeriod(raw interface{}) (int64, error) {
+	period, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return 0, err
+	}
+	return int64(period / time.Second), nil
+}
+
+// nextRotation returns when the account's key is due for rotation, if it has
+// a rotation_period.
+func nextRotation(data map[string]interface{}) (time.Time, bool) {
+	var period int64
+	switch v := data["rotation_period"].(type) {
+	case json.Number:
+		period, _ = v.Int64()
+	case int64:
+		period = v
+	}
+	lastRotation, _ := data["last_rotation"].(string)
+	last, err := time.Parse(time.RFC3339, lastRotation)
+	if period <= 0 || err != nil {
+		return time.Time{}, false
+	}
+	return last.Add(time.Duration(period) * time.Second), true
+}
+
+// warnOverdueRotations logs every role whose key is past its rotation date.
+// Keys are supplied externally, so nothing is rotated here.
+func (b *backend) warnOverdueRotations(ctx context.Context, s logical.Storage) error {
+	names, err := listRoleNames(ctx, s)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range names {
+		ent, err := s.Get(ctx, roleStorageKey(name))
+		if err != nil {
+			return err
+		}
+		if ent == nil {
+			continue
+		}
+		var role map[string]interface{}
+		if err := jsonutil.DecodeJSON(ent.Value, &role); err != nil {
+			continue
+		}
+		if next, ok := nextRotation(role); ok && now.After(next) {
+			b.Logger().Warn("Role is overdue for key rotation", "role", name, "next_rotation", next.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
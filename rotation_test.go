@@ -0,0 +1,71 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestNextRotation(t *testing.T) {
+	next, ok := nextRotation(map[string]interface{}{
+		"rotation_period": json.Number("3600"),
+		"last_rotation":   "2024-06-01T10:00:00Z",
+	})
+	if want := time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Fatalf("expected %s, got %s, %v", want, next, ok)
+	}
+	if _, ok := nextRotation(map[string]interface{}{"last_rotation": "2024-06-01T10:00:00Z"}); ok {
+		t.Fatal("expected no next rotation without a rotation_period")
+	}
+}
+
+func TestRoleNextRotation(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "scheduled", map[string]interface{}{"rotation_period": "720h"})
+
+	role := tb.mustRequest(t, logical.ReadOperation, "roles/scheduled", nil).Data
+	last, err := time.Parse(time.RFC3339, role["last_rotation"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := last.Add(720 * time.Hour).UTC().Format(time.RFC3339); role["next_rotation"] != want {
+		t.Fatalf("expected next_rotation %s, got %v", want, role["next_rotation"])
+	}
+	if role["rotation_overdue"] != false {
+		t.Fatalf("a fresh key is reported overdue: %v", role)
+	}
+}
+
+func TestOverdueRotationWarning(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "overdue", map[string]interface{}{"rotation_period": "24h"})
+	tb.writeRole(t, "current", map[string]interface{}{"rotation_period": "24h"})
+	ctx := context.Background()
+	role := tb.storedAccount(t, "roles/overdue")
+	role["last_rotation"] = time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	buf, err := json.Marshal(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tb.storage.Put(ctx, &logical.StorageEntry{Key: "roles/overdue", Value: buf}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.periodic(ctx, &logical.Request{Storage: tb.storage}); err != nil {
+		t.Fatal(err)
+	}
+	logs := tb.logs.String()
+	if !strings.Contains(logs, "Role is overdue for key rotation: role=overdue") {
+		t.Fatalf("expected a warning about the overdue role, got:\n%s", logs)
+	}
+	if strings.Contains(logs, "role=current") {
+		t.Fatalf("the current role was reported overdue:\n%s", logs)
+	}
+	if tb.mustRequest(t, logical.ReadOperation, "roles/overdue", nil).Data["rotation_overdue"] != true {
+		t.Fatal("expected the role metadata to report the rotation overdue")
+	}
+}
@@ -0,0 +1,184 @@
+package streamnative
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// freezeWindow is a daily time range, optionally limited to some weekdays,
+// during which tokens may not be minted. A window whose end precedes its start
+// runs past midnight into the following day.
+type freezeWindow struct {
+	spec  string
+	days  [7]bool
+	start int // minutes after midnight
+	end   int
+}
+
+// freezeSchedule is a set of freeze windows evaluated in one time zone.
+type freezeSchedule struct {
+	windows  []freezeWindow
+	location *time.Location
+}
+
+// parseFreezeSchedule parses windows of the form "[DAYS ]HH:MM-HH:MM", where
+// DAYS is a comma separated list of weekdays or weekday ranges such as
+// "Mon-Fri" or "Sat,Sun". timezone is an IANA zone name and defaults to UTC.
+func parseFreezeSchedule(specs []string, timezone string) (*freezeSchedule, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errwrap.Wrapf("invalid freeze timezone: {{err}}", err)
+	}
+	sched := &freezeSchedule{location: loc}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		w, err := parseFreezeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		sched.windows = append(sched.windows, w)
+	}
+	return sched, nil
+}
+
+func parseFreezeWindow(spec string) (freezeWindow, error) {
+	w := freezeWindow{spec: spec}
+	fields := strings.Fields(spec)
+	var timeRange string
+	switch len(fields) {
+	case 1:
+		timeRange = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		timeRange = fields[1]
+		for _, part := range strings.Split(fields[0], ",") {
+			from, to, isRange := strings.Cut(part, "-")
+			first, ok := weekdays[strings.ToLower(from)]
+			if !ok {
+				return w, fmt.Errorf("freeze window '%s': unknown weekday '%s'", spec, from)
+			}
+			last := first
+			if isRange {
+				if last, ok = weekdays[strings.ToLower(to)]; !ok {
+					return w, fmt.Errorf("freeze window '%s': unknown weekday '%s'", spec, to)
+				}
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	default:
+		return w, fmt.Errorf("freeze window '%s' is not of the form '[DAYS ]HH:MM-HH:MM'", spec)
+	}
+
+	from, to, ok := strings.Cut(timeRange, "-")
+	if !ok {
+		return w, fmt.Errorf("freeze window '%s' has no time range", spec)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, fmt.Errorf("freeze window '%s': %v", spec, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("freeze window '%s': %v", spec, err)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active returns the window in effect at t, if any.
+func (s *freezeSchedule) active(t time.Time) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	t = t.In(s.location)
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.windows {
+		if w.start <= w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return w.spec, true
+			}
+			continue
+		}
+		// The window runs past midnight.
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return w.spec, true
+		}
+	}
+	return "", false
+}
+
+// splitFreezeWindows accepts a list of windows or a single string of windows
+// separated by semicolons.
+func splitFreezeWindows(raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case string:
+		return strings.Split(v, ";"), nil
+	case []interface{}:
+		specs := make([]string, 0, len(v))
+		for _, item := range v {
+			spec, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("freeze_windows must contain strings")
+			}
+			specs = append(specs, spec)
+		}
+		return specs, nil
+	case []string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("freeze_windows must be a string or a list of strings")
+}
+
+// specs returns the schedule's windows as they were written.
+func (s *freezeSchedule) specs() []string {
+	specs := []string{}
+	if s == nil {
+		return specs
+	}
+	for _, w := range s.windows {
+		specs = append(specs, w.spec)
+	}
+	return specs
+}
+
+// timezone returns the name of the zone the schedule is evaluated in.
+func (s *freezeSchedule) timezone() string {
+	if s == nil {
+		return "UTC"
+	}
+	return s.location.String()
+}
@@ -0,0 +1,67 @@
+package streamnative
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestFreezeSchedule(t *testing.T) {
+	sched, err := parseFreezeSchedule([]string{"Mon-Fri 09:00-17:00", "Sat,Sun 22:00-02:00"}, "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin := sched.location
+	for _, tc := range []struct {
+		at     time.Time
+		frozen bool
+	}{
+		{time.Date(2024, 6, 3, 10, 0, 0, 0, berlin), true},   // Monday
+		{time.Date(2024, 6, 3, 17, 0, 0, 0, berlin), false},  // the end is exclusive
+		{time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC), true},  // 10:00 in Berlin
+		{time.Date(2024, 6, 8, 10, 0, 0, 0, berlin), false},  // Saturday
+		{time.Date(2024, 6, 8, 23, 30, 0, 0, berlin), true},  // Saturday night
+		{time.Date(2024, 6, 10, 1, 30, 0, 0, berlin), true},  // early Monday, from Sunday
+		{time.Date(2024, 6, 11, 1, 30, 0, 0, berlin), false}, // early Tuesday
+	} {
+		if _, frozen := sched.active(tc.at); frozen != tc.frozen {
+			t.Errorf("at %s: expected frozen=%v", tc.at, tc.frozen)
+		}
+	}
+}
+
+func TestFreezeScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"9-17", "Mon-Fry 09:00-17:00", "09:00", "Mon 09:00-25:00", "Mon Tue 09:00-17:00"} {
+		if _, err := parseFreezeSchedule([]string{spec}, ""); err == nil {
+			t.Errorf("expected '%s' to be rejected", spec)
+		}
+	}
+	if _, err := parseFreezeSchedule([]string{"09:00-17:00"}, "Mars/Olympus"); err == nil {
+		t.Error("expected an unknown timezone to be rejected")
+	}
+}
+
+func TestFreezeWindowRead(t *testing.T) {
+	tb := getTestBackend(t)
+	now := time.Now().UTC()
+	clock := func(d time.Duration) string { return now.Add(d).Format("15:04") }
+	tb.writeRole(t, "frozen", map[string]interface{}{"freeze_windows": clock(-time.Hour) + "-" + clock(time.Hour)})
+	tb.writeRole(t, "thawed", map[string]interface{}{"freeze_windows": clock(time.Hour) + "-" + clock(2*time.Hour)})
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/frozen", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "frozen") {
+		t.Fatalf("expected the read inside the window to be rejected, got %q", msg)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/thawed", nil)
+
+	resp, err = tb.request(t, logical.UpdateOperation, "roles/invalid", map[string]interface{}{
+		"key-file":       testKeyFile,
+		"organization":   "test-org",
+		"freeze_windows": "weekdays 09:00-17:00",
+	})
+	if msg := errorText(resp, err); !strings.Contains(msg, "freeze window") {
+		t.Fatalf("expected an invalid schedule to be rejected, got %q", msg)
+	}
+}
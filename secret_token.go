@@ -0,0 +1,70 @@
+package streamnative
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const secretTokenType = "streamnative_token"
+
+// secretToken is the lease wrapping every token handed out by the backend.
+func (b *backend) secretToken() *framework.Secret {
+	return &framework.Secret{
+		Type: secretTokenType,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Pulsar JWT",
+			},
+		},
+
+		Renew:  b.handleTokenRenew,
+		Revoke: b.handleTokenRevoke,
+	}
+}
+
+// handleTokenRenew extends the lease up to the end of the token it was issued
+// with. A JWT cannot be extended, and Vault discards any data a renewal
+// returns, so a client needing a token past that must read a new one.
+func (b *backend) handleTokenRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raw, ok := req.Secret.InternalData["token_exp"]
+	if !ok {
+		return logical.ErrorResponse("the lease does not record its token's expiry; read a new token"), nil
+	}
+	tokenExp, err := parseutil.ParseInt(raw)
+	if err != nil {
+		return nil, errwrap.Wrapf("lease has an invalid token expiry: {{err}}", err)
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	if tokenExp == 0 {
+		// A token without an exp never expires, so the lease may go on.
+		resp.Secret.TTL = b.System().DefaultLeaseTTL()
+		return resp, nil
+	}
+	// As when it was issued, the lease ends a skew before the token.
+	ttl := time.Until(time.Unix(tokenExp, 0))
+	if skew := b.config().RefreshSkew; ttl > skew {
+		ttl -= skew
+	}
+	if ttl <= 0 {
+		return logical.ErrorResponse("the token has expired; read a new token"), nil
+	}
+	resp.Secret.TTL = ttl
+	return resp, nil
+}
+
+// handleTokenRevoke drops the account's cached tokens so the revoked token is
+// not handed out again. StreamNative offers no revocation for issued JWTs, so
+// the token itself stays valid until it expires.
+func (b *backend) handleTokenRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if path, ok := req.Secret.InternalData["path"].(string); ok {
+		b.cache.invalidate(path)
+	}
+	return nil, nil
+}
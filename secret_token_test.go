@@ -0,0 +1,193 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTokenLease(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "leased", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/leased", nil)
+	if resp.Secret == nil {
+		t.Fatal("expected the token to be leased")
+	}
+	if resp.Secret.TTL <= 0 || resp.Secret.TTL > time.Hour {
+		t.Fatalf("expected a lease of at most the token's hour, got %s", resp.Secret.TTL)
+	}
+
+	renewed, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   tb.storage,
+		Secret:    resp.Secret,
+	})
+	if msg := errorText(renewed, err); msg != "" {
+		t.Fatalf("renewing failed: %s", msg)
+	}
+	// The renewed lease still ends with the token it was issued with.
+	if renewed.Secret == nil || renewed.Secret.TTL <= 0 || renewed.Secret.TTL > resp.Secret.TTL {
+		t.Fatalf("expected the renewal to keep the lease within the token's life of %s, got %v", resp.Secret.TTL, renewed.Secret)
+	}
+	if len(renewed.Data) != 0 {
+		t.Fatalf("expected the renewal to return no token, got %v", renewed.Data)
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != 1 {
+		t.Fatalf("expected the renewal not to mint, got %d get-token calls", n)
+	}
+
+	revoked, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   tb.storage,
+		Secret:    resp.Secret,
+	})
+	if msg := errorText(revoked, err); msg != "" {
+		t.Fatalf("revoking failed: %s", msg)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/leased", nil)
+	if n := len(tb.snctl.Calls("auth get-token")); n != 2 {
+		t.Fatalf("expected revocation to drop the cached token, got %d get-token calls", n)
+	}
+}
+
+func TestLeaseRenewalEndsWithToken(t *testing.T) {
+	tb := getTestBackend(t)
+	sys := tb.System().(*logical.StaticSystemView)
+	sys.MaxLeaseTTLVal = time.Minute
+	exp := time.Now().Add(time.Hour).Unix()
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{"exp": exp})})
+	tb.writeRole(t, "renewed", nil)
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/renewed", nil)
+	if resp.Secret.TTL != time.Minute {
+		t.Fatalf("expected the lease clamped to the mount's minute, got %s", resp.Secret.TTL)
+	}
+
+	// renew renews the lease with its token_exp replaced, or removed if nil.
+	renew := func(tokenExp interface{}) (*logical.Response, error) {
+		secret := *resp.Secret
+		secret.InternalData = make(map[string]interface{})
+		for k, v := range resp.Secret.InternalData {
+			secret.InternalData[k] = v
+		}
+		if tokenExp == nil {
+			delete(secret.InternalData, "token_exp")
+		} else {
+			secret.InternalData["token_exp"] = tokenExp
+		}
+		return tb.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   tb.storage,
+			Secret:    &secret,
+		})
+	}
+	// Renewal may extend a clamped lease, but only to a skew before exp.
+	// Vault stores internal data as JSON, so its numbers come back decoded.
+	renewed, err := renew(json.Number(fmt.Sprint(exp)))
+	if msg := errorText(renewed, err); msg != "" {
+		t.Fatalf("renewing failed: %s", msg)
+	}
+	if ttl := renewed.Secret.TTL; ttl <= 58*time.Minute || ttl > 59*time.Minute {
+		t.Fatalf("expected the lease to end a minute before the token's hour, got %s", ttl)
+	}
+	// A token expiring within the skew keeps the rest of its life.
+	renewed, err = renew(time.Now().Add(30 * time.Second).Unix())
+	if msg := errorText(renewed, err); msg != "" {
+		t.Fatalf("renewing failed: %s", msg)
+	}
+	if ttl := renewed.Secret.TTL; ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("expected the lease to end with the token, got %s", ttl)
+	}
+
+	for name, tc := range map[string]struct {
+		tokenExp interface{}
+		want     string
+	}{
+		"expired":   {time.Now().Add(-time.Minute).Unix(), "the token has expired"},
+		"no expiry": {nil, "does not record its token's expiry"},
+	} {
+		renewed, err := renew(tc.tokenExp)
+		if msg := errorText(renewed, err); !strings.Contains(msg, tc.want) {
+			t.Errorf("%s: expected %q, got %q", name, tc.want, msg)
+		}
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != 1 {
+		t.Fatalf("expected renewals not to mint, got %d get-token calls", n)
+	}
+}
+
+func TestLeaseClampedToMount(t *testing.T) {
+	tb := getTestBackend(t)
+	sys := tb.System().(*logical.StaticSystemView)
+	sys.DefaultLeaseTTLVal = 5 * time.Minute
+	sys.MaxLeaseTTLVal = time.Minute
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{
+		"exp": time.Now().Add(30 * 24 * time.Hour).Unix(),
+	})})
+	tb.writeRole(t, "clamped", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/clamped", nil)
+	if resp.Secret.TTL != time.Minute || resp.Data["lease_ttl_seconds"] != int64(60) {
+		t.Fatalf("expected the lease clamped to the mount's minute, got %s and %v", resp.Secret.TTL, resp.Data["lease_ttl_seconds"])
+	}
+	if len(resp.Warnings) == 0 || !strings.Contains(resp.Warnings[0], "max_lease_ttl") {
+		t.Fatalf("expected a warning about the clamp, got %v", resp.Warnings)
+	}
+}
+
+func TestLeaseDefaultWithoutExpiry(t *testing.T) {
+	tb := getTestBackend(t)
+	sys := tb.System().(*logical.StaticSystemView)
+	sys.DefaultLeaseTTLVal = 5 * time.Minute
+	sys.MaxLeaseTTLVal = time.Hour
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: testJWT(t, map[string]interface{}{"exp": nil})})
+	tb.writeRole(t, "unexpiring", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/unexpiring", nil)
+	if resp.Secret.TTL != 5*time.Minute {
+		t.Fatalf("expected the mount's default lease for a token without exp, got %s", resp.Secret.TTL)
+	}
+	renewed, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   tb.storage,
+		Secret:    resp.Secret,
+	})
+	if msg := errorText(renewed, err); msg != "" || renewed.Secret.TTL != 5*time.Minute {
+		t.Fatalf("expected renewal to extend by the mount's default lease, got %q, %v", msg, renewed.Secret)
+	}
+}
+
+func TestMinWrapTTL(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "wrapped", nil)
+
+	if resp := tb.mustRequest(t, logical.ReadOperation, "creds/wrapped", nil); resp.WrapInfo != nil {
+		t.Fatalf("expected no wrapping by default, got %+v", resp.WrapInfo)
+	}
+
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"min_wrap_ttl": "2m"})
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/wrapped", nil)
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != 2*time.Minute {
+		t.Fatalf("expected the response to be wrapped for 2m, got %+v", resp.WrapInfo)
+	}
+
+	// A wrap TTL requested by the client wins.
+	resp, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/wrapped",
+		Storage:   tb.storage,
+		WrapInfo:  &logical.RequestWrapInfo{TTL: 30 * time.Second},
+	})
+	if msg := errorText(resp, err); msg != "" {
+		t.Fatal(msg)
+	}
+	if resp.WrapInfo != nil {
+		t.Fatalf("expected the client's wrap TTL to be left to Vault, got %+v", resp.WrapInfo)
+	}
+}
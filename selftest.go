@@ -0,0 +1,48 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// SelfTest checks, without Vault, that snctl is installed and usable with the
+// configuration from the environment, writing a pass or fail line for each
+// check to w. It reports whether every check passed. The config stored in
+// Vault is not consulted.
+func SelfTest(ctx context.Context, w io.Writer) bool {
+	b, err := newBackend()
+	if err != nil {
+		fmt.Fprintf(w, "FAIL  configuration from the environment: %v\n", err)
+		return false
+	}
+	conf := b.config()
+	fmt.Fprintf(w, "snctl: %s\n", conf.BinaryPath)
+
+	ok := true
+	check := func(name string, detail string, err error) {
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "PASS  %s: %s\n", name, detail)
+	}
+
+	path, err := exec.LookPath(conf.BinaryPath)
+	check("binary", path, err)
+	if err != nil {
+		return false
+	}
+
+	version, err := b.snctlVersion(ctx, conf)
+	check("snctl version", version, err)
+
+	home, err := b.temporarySnctlHome(ctx, conf)
+	check("snctl config init in a temporary HOME", "ok", err)
+	if err == nil {
+		liveTempFiles.remove(home)
+	}
+	return ok
+}
@@ -0,0 +1,77 @@
+package streamnative
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// selfTestSnctl installs a stub snctl running body for SelfTest, with a
+// private config and temporary directory.
+func selfTestSnctl(t *testing.T, body string) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "snctl")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SNCTL_PATH", script)
+	t.Setenv("SNCTL_CONFIG_DIR", t.TempDir())
+	t.Setenv("SNCTL_TEMP_DIR", t.TempDir())
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	return script
+}
+
+func TestSelfTest(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body string
+		pass bool
+		want []string
+	}{
+		"working": {
+			body: `case "$*" in
+version) echo "snctl version 1.2.3" ;;
+*"config init"*) mkdir -p "$HOME/.snctl" ;;
+esac`,
+			pass: true,
+			want: []string{"PASS  binary: ", "PASS  snctl version: 1.2.3", "PASS  snctl config init in a temporary HOME: ok"},
+		},
+		"config init failing": {
+			body: `case "$*" in
+version) echo "snctl version 1.2.3" ;;
+*"config init"*) echo "error: read-only file system" >&2; exit 1 ;;
+esac`,
+			want: []string{"PASS  snctl version: 1.2.3", "FAIL  snctl config init in a temporary HOME: ", "read-only file system"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			selfTestSnctl(t, tc.body)
+			var report bytes.Buffer
+			if passed := SelfTest(context.Background(), &report); passed != tc.pass {
+				t.Fatalf("expected the self test to return %v, got %v:\n%s", tc.pass, passed, report.String())
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(report.String(), want) {
+					t.Errorf("expected %q in the report:\n%s", want, report.String())
+				}
+			}
+			if left, _ := filepath.Glob(filepath.Join(os.Getenv("SNCTL_TEMP_DIR"), "snio-home-*")); len(left) != 0 {
+				t.Fatalf("the temporary HOME was left behind: %v", left)
+			}
+		})
+	}
+
+	t.Run("missing binary", func(t *testing.T) {
+		script := selfTestSnctl(t, "exit 0")
+		os.Remove(script)
+		var report bytes.Buffer
+		if SelfTest(context.Background(), &report) {
+			t.Fatalf("expected the self test to fail:\n%s", report.String())
+		}
+		if !strings.Contains(report.String(), "FAIL  binary: ") || strings.Contains(report.String(), "snctl version") {
+			t.Fatalf("expected only the binary check to run and fail:\n%s", report.String())
+		}
+	})
+}
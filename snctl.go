@@ -0,0 +1,373 @@
+package streamnative
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+)
+
+// snctlCommand builds an snctl invocation. The configured snctl_env is added
+// to the plugin's environment. A non-empty home overrides the HOME of the
+// child process, relocating its ~/.snctl config directory.
+func snctlCommand(ctx context.Context, conf *snctlConfig, home string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, conf.BinaryPath, args...)
+	if home == "" && len(conf.SnctlEnv) == 0 {
+		return cmd
+	}
+	cmd.Env = os.Environ()
+	for _, name := range envNames(conf.SnctlEnv) {
+		cmd.Env = append(cmd.Env, name+"="+conf.SnctlEnv[name])
+	}
+	if home != "" {
+		cmd.Env = append(cmd.Env, "HOME="+home)
+	}
+	return cmd
+}
+
+// commandRunner runs a prepared snctl command to completion, like
+// (*exec.Cmd).Run. Tests replace the backend's runner to fake snctl; the fake
+// writes to cmd.Stdout and cmd.Stderr and returns the error the process
+// would have.
+type commandRunner func(cmd *exec.Cmd) error
+
+// runCommand is the default commandRunner, running the real process.
+func runCommand(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+// stdinKeyFile is passed as the key file path when the key is piped to snctl
+// on stdin instead of being written to disk.
+const stdinKeyFile = "/dev/stdin"
+
+// stdinSupported reports whether this platform can hand snctl its key file on
+// stdin.
+func stdinSupported() bool {
+	_, err := os.Stat(stdinKeyFile)
+	return err == nil
+}
+
+// fingerprint identifies sensitive material in logs without revealing it.
+func fingerprint(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:6])
+}
+
+// maxOutputSnippet bounds the output excerpt carried in snctl errors.
+const maxOutputSnippet = 512
+
+var (
+	jwtPattern          = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	clientSecretPattern = regexp.MustCompile(`("?client_secret"?\s*[:=]\s*)"?[^"\s,}]*"?`)
+)
+
+// redactOutput masks tokens and client secrets that snctl may echo.
+func redactOutput(out string) string {
+	out = jwtPattern.ReplaceAllString(out, "[redacted]")
+	return clientSecretPattern.ReplaceAllString(out, "${1}[redacted]")
+}
+
+// outputSnippet returns a trimmed, redacted excerpt of snctl's output.
+func outputSnippet(out []byte) string {
+	snippet := redactOutput(strings.TrimSpace(string(out)))
+	if len(snippet) > maxOutputSnippet {
+		snippet = snippet[:maxOutputSnippet] + "..."
+	}
+	return snippet
+}
+
+// truncatedMarker ends output cut short at max_output_bytes.
+const truncatedMarker = "...(truncated)"
+
+// boundedBuffer captures up to limit bytes of output, discarding the rest, so
+// that runaway snctl output cannot exhaust memory. Writes always succeed, so
+// the process is not killed by a broken pipe. A limit of zero is unbounded.
+type boundedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *boundedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.limit > 0 {
+		if room := w.limit - w.buf.Len(); len(p) > room {
+			p = p[:room]
+			w.truncated = true
+		}
+	}
+	w.buf.Write(p)
+	return n, nil
+}
+
+// Bytes returns the captured output, ending in truncatedMarker if any was
+// discarded.
+func (w *boundedBuffer) Bytes() []byte {
+	if w.truncated {
+		return append(w.buf.Bytes(), truncatedMarker...)
+	}
+	return w.buf.Bytes()
+}
+
+// runSnctl runs snctl and returns its standard output. Each invocation is
+// bounded by the configured request timeout on top of ctx; name describes the
+// subcommand in errors. A non-nil stdin is fed to the process. On failure the
+// error carries a redacted excerpt of stderr. Each of stdout and stderr is
+// captured up to conf.MaxOutputBytes; a successful run whose stdout exceeded
+// it fails, as its output cannot be parsed.
+func (b *backend) runSnctl(ctx context.Context, conf *snctlConfig, home string, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmdCtx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+	defer cancel()
+	cmd := snctlCommand(cmdCtx, conf, home, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	stdout := &boundedBuffer{limit: conf.MaxOutputBytes}
+	stderr := &boundedBuffer{limit: conf.MaxOutputBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := b.runner(cmd)
+	out := stdout.Bytes()
+	if err != nil && cmdCtx.Err() != nil {
+		if ctx.Err() != nil {
+			return out, errwrap.Wrapf(fmt.Sprintf("snctl %s aborted: {{err}}", name), ctx.Err())
+		}
+		return out, classify(ErrTransient, fmt.Errorf("snctl %s timed out after %s", name, conf.RequestTimeout))
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return out, snctlNotFoundError(conf.BinaryPath)
+	}
+	if err != nil {
+		if snippet := outputSnippet(stderr.Bytes()); snippet != "" {
+			return out, classifySnctlError(fmt.Errorf("snctl %s failed: %w: %s", name, err, snippet))
+		}
+		return out, classifySnctlError(fmt.Errorf("snctl %s failed: %w", name, err))
+	}
+	if stdout.truncated {
+		return out, fmt.Errorf("snctl %s wrote more than max_output_bytes of %d", name, conf.MaxOutputBytes)
+	}
+	return out, nil
+}
+
+// retryBackoff is the delay before the first retry; it doubles on each retry.
+const retryBackoff = 500 * time.Millisecond
+
+// Patterns classifying a failed snctl invocation. Authentication rejections
+// are checked first, so they are never mistaken for transient failures, and
+// transient failures before missing resources, so that a failure is only
+// blamed on the request when nothing else explains it. Only a missing
+// organization or cluster is the request's fault; snctl reports its own missing
+// files and config as not found too.
+var (
+	transientPattern     = regexp.MustCompile(`(?i)\b5\d\d\b|timed out|timeout|connection (refused|reset)|no such host|temporar|unavailable|bad gateway|\bEOF\b`)
+	authRejectionPattern = regexp.MustCompile(`(?i)\b40[13]\b|unauthori[sz]ed|forbidden|invalid_client|invalid_grant|access_denied`)
+	notFoundPattern      = regexp.MustCompile(`(?i)\b(organization|pulsarcluster|cluster)s?\b.*\b(not found|does not exist)\b|no such (organization|cluster)`)
+)
+
+// runSnctlWithRetry runs snctl like runSnctl, retrying transient failures up
+// to conf.MaxRetries times with exponential backoff. Retries stop as soon as
+// ctx is done.
+func (b *backend) runSnctlWithRetry(ctx context.Context, conf *snctlConfig, home string, stdin []byte, name string, args ...string) ([]byte, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := b.runSnctl(ctx, conf, home, stdin, name, args...)
+		if err == nil || attempt > conf.MaxRetries || ctx.Err() != nil || !errors.Is(err, ErrTransient) {
+			return out, err
+		}
+		b.Logger().Warn("snctl failed transiently, retrying", "command", name, "attempt", attempt, "backoff", backoff, "error", err)
+		metrics.IncrCounter([]string{"streamnative", "snctl", "retry"}, 1)
+		select {
+		case <-ctx.Done():
+			return out, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// alreadyInitializedPattern matches `snctl config init` output refusing to
+// overwrite an existing config, which leaves a usable config behind.
+var alreadyInitializedPattern = regexp.MustCompile(`(?i)already (been )?initiali[sz]ed|already exists`)
+
+// initializeSnctlConfig runs `snctl config init` under home. A config found
+// to be initialized already counts as success; any other failure is returned
+// with the redacted output.
+func (b *backend) initializeSnctlConfig(ctx context.Context, conf *snctlConfig, home string) error {
+	b.Logger().Info("Initializing snctl config")
+	out, err := b.runSnctl(ctx, conf, home, nil, "config init", "config", "init")
+	output := outputSnippet(out)
+	switch {
+	case err == nil:
+		metrics.IncrCounterWithLabels([]string{"streamnative", "snctl", "config_init"}, 1, []metrics.Label{{Name: "outcome", Value: "initialized"}})
+		if output != "" {
+			b.Logger().Warn("`snctl config init` succeeded with output", "out", output)
+		}
+		return nil
+	case alreadyInitializedPattern.MatchString(err.Error() + "\n" + output):
+		metrics.IncrCounterWithLabels([]string{"streamnative", "snctl", "config_init"}, 1, []metrics.Label{{Name: "outcome", Value: "already_initialized"}})
+		b.Logger().Info("snctl config is already initialized", "out", output)
+		return nil
+	}
+	metrics.IncrCounterWithLabels([]string{"streamnative", "snctl", "config_init"}, 1, []metrics.Label{{Name: "outcome", Value: "failed"}})
+	b.Logger().Error("Failed to run `snctl config init`", "error", err, "out", output)
+	if output != "" {
+		return fmt.Errorf("%w; output: %s", err, output)
+	}
+	return err
+}
+
+// Initialize once if config dir does not exist.
+// snctl config init
+//
+// Returns the HOME snctl must run under. An empty home means the process HOME
+// is used. If the config directory cannot be written, a per-request temporary
+// HOME is initialized instead and temporary is set; the caller must remove it
+// when done.
+func (b *backend) requireSnctlConfig(ctx context.Context, conf *snctlConfig) (home string, temporary bool, err error) {
+	// childHome is the HOME snctl runs under, empty for the process's own.
+	childHome := conf.ConfigDir
+	home = childHome
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			// Containers often run without a HOME; keep the config in a
+			// directory managed by the plugin instead of failing every read.
+			childHome = b.managedHome(conf)
+			if !b.managedHomeLogged.Swap(true) {
+				b.Logger().Info("No user HOME directory, keeping the snctl config in a plugin-managed directory", "path", childHome, "error", err)
+			}
+			userHome = childHome
+		}
+		home = userHome
+	}
+	path := home + "/.snctl"
+	// The caller holds snctlLock.
+	if b.snctlReadyPath == path {
+		// Checked by an earlier request; skip the filesystem.
+		return childHome, false, nil
+	}
+	// unusable is set when the config directory itself cannot be created,
+	// read or written. Only then may a temporary HOME stand in for it; other
+	// failures, such as a missing snctl binary, are returned as they are.
+	unusable := false
+	if childHome != "" {
+		// A configured or managed directory may not have been created yet.
+		err = os.MkdirAll(childHome, 0700)
+		unusable = err != nil
+	}
+	if err == nil {
+		_, err = os.ReadDir(path)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			// Initialize only a missing config. Holding snctlLock ensures
+			// concurrent requests find the config initialized by the first.
+			err = b.initializeSnctlConfig(ctx, conf, childHome)
+		case os.IsPermission(err):
+			unusable = true
+			err = fmt.Errorf("snctl config directory %s is not readable by the plugin; fix its permissions or set config_dir", path)
+		default:
+			unusable = true
+			err = errwrap.Wrapf(fmt.Sprintf("Reading snctl config directory %s failed: {{err}}", path), err)
+		}
+	}
+	if err == nil {
+		err = probeWritable(path)
+		unusable = err != nil
+	}
+	if err == nil {
+		b.snctlReadyPath = path
+		return childHome, false, nil
+	}
+	if !conf.HomeFallback || !unusable {
+		// Return remaining error, if any.
+		return "", false, err
+	}
+
+	b.Logger().Warn("snctl config directory is unusable, falling back to a temporary HOME", "path", path, "error", err)
+	metrics.IncrCounter([]string{"streamnative", "snctl", "home_fallback"}, 1)
+	tmpHome, err := b.temporarySnctlHome(ctx, conf)
+	if err != nil {
+		return "", false, err
+	}
+	return tmpHome, true, nil
+}
+
+// managedHome is the HOME snctl runs under when neither config_dir nor a user
+// HOME directory is available. Each mount has its own.
+func (b *backend) managedHome(conf *snctlConfig) string {
+	return filepath.Join(conf.tempDir(), "vault-plugin-streamnative-"+b.mountID)
+}
+
+// eagerInit checks that snctl can be run and its config directory is usable,
+// so misconfiguration shows up in the server log when the mount starts. Any
+// problem is logged as a warning only.
+func (b *backend) eagerInit(ctx context.Context, conf *snctlConfig) {
+	if _, err := exec.LookPath(conf.BinaryPath); err != nil {
+		b.Logger().Warn("Token generation will fail", "error", snctlNotFoundError(conf.BinaryPath), "cause", err)
+		return
+	}
+	if conf.IsolateHome {
+		// Every read initializes its own HOME.
+		return
+	}
+	b.snctlLock.Lock()
+	defer b.snctlLock.Unlock()
+	home, temporary, err := b.requireSnctlConfig(ctx, conf)
+	if err != nil {
+		b.Logger().Warn("Initializing snctl config failed; token generation will fail", "error", err)
+		return
+	}
+	if temporary {
+		liveTempFiles.remove(home)
+	}
+	b.Logger().Info("snctl config initialized")
+}
+
+// temporarySnctlHome creates a private HOME with a freshly initialized snctl
+// config. The caller must remove it when done.
+func (b *backend) temporarySnctlHome(ctx context.Context, conf *snctlConfig) (string, error) {
+	tmpHome, err := os.MkdirTemp(conf.TempDir, "snio-home-*")
+	if err != nil {
+		return "", errwrap.Wrapf("Creating temporary HOME failed: {{err}}", err)
+	}
+	liveTempFiles.add(tmpHome)
+	if err := b.initializeSnctlConfig(ctx, conf, tmpHome); err != nil {
+		liveTempFiles.remove(tmpHome)
+		return "", err
+	}
+	return tmpHome, nil
+}
+
+// probeWritable verifies a file can be created in dir.
+func probeWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".snio-probe-*")
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("%s is not writable: {{err}}", dir), err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func (b *backend) activateServiceAccount(ctx context.Context, conf *snctlConfig, home string, secretKey string, stdin []byte) error {
+	// Set a dummy oauth key. The dummy key is overwritten with per-request data.
+	// snctl auth activate-service-account --key-file ~/service-account-key.json
+	out, err := b.runSnctl(ctx, conf, home, stdin, "auth activate-service-account", "auth", "activate-service-account", "--key-file", secretKey)
+	if err != nil {
+		// The output may echo the key file, so only describe it.
+		b.Logger().Error("Failed to run `snctl auth activate-service-account`", "error", err, "out_bytes", len(out), "out_fingerprint", fingerprint(out))
+	}
+	return err
+}
@@ -0,0 +1,864 @@
+package streamnative
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arctype-co/vault-plugin-streamnative/internal/snctltest"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// breakConfigDir makes the snctl config directory of tb unusable. A file
+// stands in for a read-only directory, which root could still write.
+func breakConfigDir(t *testing.T, tb *testBackend) string {
+	t.Helper()
+	dir := tb.config().ConfigDir
+	if err := os.WriteFile(filepath.Join(dir, ".snctl"), nil, 0400); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestHomeFallback(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"home_fallback": true})
+	dir := breakConfigDir(t, tb)
+	tb.writeRole(t, "fallback", nil)
+
+	resp := tb.mustRequest(t, logical.ReadOperation, "creds/fallback", nil)
+	if resp.Data["token"] == "" {
+		t.Fatalf("expected a token, got %v", resp.Data)
+	}
+	calls := tb.snctl.Calls("auth get-token")
+	if len(calls) != 1 {
+		t.Fatalf("expected one get-token, got %d", len(calls))
+	}
+	for _, kv := range calls[0].Env {
+		if kv == "HOME="+dir {
+			t.Fatal("get-token ran under the unusable config directory")
+		}
+	}
+	if !strings.Contains(tb.logs.String(), "[WARN]  snctl config directory is unusable, falling back to a temporary HOME") {
+		t.Fatalf("the fallback was not logged as a warning: %s", tb.logs.String())
+	}
+}
+
+func TestHomeFallbackOnlyForUnusableDir(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"home_fallback": true})
+	tb.snctl.On("config init", snctltest.Response{Stderr: "error: unknown command", ExitCode: 1})
+	tb.writeRole(t, "uninitialized", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/uninitialized", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "unknown command") {
+		t.Fatalf("expected the config init failure, got %q", msg)
+	}
+	if calls := tb.snctl.Calls("auth get-token"); len(calls) != 0 {
+		t.Fatalf("expected no get-token under a temporary HOME, got %d", len(calls))
+	}
+	if strings.Contains(tb.logs.String(), "falling back to a temporary HOME") {
+		t.Fatal("a config init failure fell back to a temporary HOME")
+	}
+}
+
+func TestManagedHome(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("HOME", "")
+	tb.setEnv(t, "SNCTL_CONFIG_DIR", "")
+	tb.writeRole(t, "homeless", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/homeless", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/homeless", map[string]interface{}{"no_cache": true})
+	dir := tb.managedHome(tb.config())
+	for _, call := range tb.snctl.Calls("auth get-token") {
+		if home := envValue(call.Env, "HOME"); home != dir {
+			t.Fatalf("expected get-token to run under %s, got HOME=%q", dir, home)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".snctl")); err != nil {
+		t.Fatalf("expected the snctl config in the managed HOME: %v", err)
+	}
+	if n := strings.Count(tb.logs.String(), "No user HOME directory"); n != 1 {
+		t.Fatalf("expected the fallback to be logged once, got %d", n)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	tb, other := getTestBackend(t), getTestBackend(t)
+	t.Setenv("HOME", "")
+	t.Setenv("SNCTL_CONFIG_DIR", "")
+	t.Setenv("SNCTL_TEMP_DIR", t.TempDir())
+	for _, b := range []*testBackend{tb, other} {
+		if err := b.loadConfig(context.Background(), b.storage); err != nil {
+			t.Fatal(err)
+		}
+		b.writeRole(t, "cleaned", nil)
+		b.mustRequest(t, logical.ReadOperation, "creds/cleaned", nil)
+	}
+	dir, otherDir := tb.managedHome(tb.config()), other.managedHome(other.config())
+	if dir == otherDir {
+		t.Fatalf("mounts share the managed HOME %s", dir)
+	}
+
+	tb.Cleanup(context.Background())
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the managed HOME to be removed, got %v", err)
+	}
+	tb.cache.mu.Lock()
+	cached := len(tb.cache.entries)
+	tb.cache.mu.Unlock()
+	if cached != 0 {
+		t.Fatalf("expected the cache to be flushed, got %d tokens", cached)
+	}
+
+	if _, err := os.Stat(filepath.Join(otherDir, ".snctl")); err != nil {
+		t.Fatalf("cleaning up one mount removed another's HOME: %v", err)
+	}
+	other.mustRequest(t, logical.ReadOperation, "creds/cleaned", map[string]interface{}{"no_cache": true})
+	if n := len(other.snctl.Calls("config init")); n != 1 {
+		t.Fatalf("expected the other mount to keep its config, got %d config init", n)
+	}
+}
+
+func TestHomeFallbackDisabled(t *testing.T) {
+	for name, value := range map[string]string{"unset": "", "false": "false", "invalid": "ture"} {
+		t.Run(name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			if value != "" {
+				t.Setenv("SNCTL_HOME_FALLBACK", value)
+			}
+			if err := tb.loadConfig(context.Background(), tb.storage); err != nil {
+				t.Fatal(err)
+			}
+			breakConfigDir(t, tb)
+			tb.writeRole(t, "fallback", nil)
+
+			resp, err := tb.request(t, logical.ReadOperation, "creds/fallback", nil)
+			if errorText(resp, err) == "" {
+				t.Fatal("expected the read to fail without the fallback")
+			}
+			if calls := tb.snctl.Calls("auth get-token"); len(calls) != 0 {
+				t.Fatalf("expected no get-token, got %d", len(calls))
+			}
+		})
+	}
+}
+
+func TestHomeFallbackConfig(t *testing.T) {
+	tb := getTestBackend(t)
+	if resp := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil); resp.Data["home_fallback"] != false {
+		t.Fatalf("expected home_fallback to default to false, got %v", resp.Data["home_fallback"])
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"home_fallback": true})
+	if resp := tb.mustRequest(t, logical.ReadOperation, "config/snctl", nil); resp.Data["home_fallback"] != true {
+		t.Fatalf("expected home_fallback to be true, got %v", resp.Data["home_fallback"])
+	}
+}
+
+// envValue returns the value of name in the command environment env, where
+// the last setting wins.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, name+"="); ok {
+			value = v
+		}
+	}
+	return value
+}
+
+// argAfter returns the argument following flag.
+func argAfter(args []string, flag string) string {
+	for i, arg := range args[:len(args)-1] {
+		if arg == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// activateInHome makes the fake snctl of tb keep the activated account in
+// its HOME, as snctl does, and mint tokens whose subject is that account's
+// client, so that reads sharing a HOME race without serialization.
+func activateInHome(t *testing.T, tb *testBackend) {
+	var mu sync.Mutex
+	activated := map[string]string{}
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		home := envValue(cmd.Env, "HOME")
+		switch args := strings.Join(cmd.Args, " "); {
+		case strings.Contains(args, "activate-service-account"):
+			var keyFile []byte
+			var err error
+			if cmd.Stdin != nil {
+				keyFile, err = io.ReadAll(cmd.Stdin)
+			} else {
+				keyFile, err = os.ReadFile(argAfter(cmd.Args, "--key-file"))
+			}
+			if err != nil {
+				return err
+			}
+			var key struct {
+				ClientID string `json:"client_id"`
+			}
+			if err := json.Unmarshal(keyFile, &key); err != nil {
+				return err
+			}
+			mu.Lock()
+			activated[home] = key.ClientID
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			return nil
+		case strings.Contains(args, "auth get-token"):
+			mu.Lock()
+			clientID := activated[home]
+			mu.Unlock()
+			_, err := io.WriteString(cmd.Stdout, testJWT(t, map[string]interface{}{"sub": clientID}))
+			return err
+		}
+		return run(cmd)
+	}
+}
+
+// readAccountsConcurrently reads roles a and b, for clients client-a and
+// client-b, in parallel and checks that each token is for its own client.
+func readAccountsConcurrently(t *testing.T, tb *testBackend) {
+	for _, name := range []string{"a", "b"} {
+		tb.writeRole(t, name, map[string]interface{}{"key-file": testKeyFileFor("client-" + name)})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		name := []string{"a", "b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := tb.request(t, logical.ReadOperation, "creds/"+name, map[string]interface{}{"no_cache": true})
+			if msg := errorText(resp, err); msg != "" {
+				errs <- fmt.Errorf("creds/%s: %s", name, msg)
+				return
+			}
+			claims, err := decodeJwtClaims(resp.Data["token"].(string))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if claims["sub"] != "client-"+name {
+				errs <- fmt.Errorf("creds/%s got a token for %v", name, claims["sub"])
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentAccounts(t *testing.T) {
+	tb := getTestBackend(t)
+	activateInHome(t, tb)
+	readAccountsConcurrently(t, tb)
+}
+
+func TestConcurrentAccountsIsolated(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"isolate_home": true})
+	activateInHome(t, tb)
+	readAccountsConcurrently(t, tb)
+
+	homes := map[string]bool{}
+	for _, call := range tb.snctl.Calls("config init") {
+		homes[envValue(call.Env, "HOME")] = true
+	}
+	if len(homes) != 20 {
+		t.Fatalf("expected every read to initialize its own HOME, got %d", len(homes))
+	}
+	if left, _ := filepath.Glob(filepath.Join(tb.config().TempDir, "snio-home-*")); len(left) != 0 {
+		t.Fatalf("temporary HOMEs remain: %v", left)
+	}
+}
+
+// stubSnctl makes tb run a shell script as snctl, without retries. The script
+// runs body, and succeeds for any other command, creating ~/.snctl on
+// `config init`.
+func stubSnctl(t *testing.T, tb *testBackend, body string) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "snctl")
+	stub := "#!/bin/sh\n" + body + "\ncase \"$*\" in\n*\"config init\"*) mkdir -p \"$HOME/.snctl\" ;;\nesac\n"
+	if err := os.WriteFile(script, []byte(stub), 0700); err != nil {
+		t.Fatal(err)
+	}
+	tb.runner = runCommand
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	tb.setEnv(t, "SNCTL_PATH", script)
+	return script
+}
+
+func TestSnctlTimeout(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_REQUEST_TIMEOUT", "200ms")
+	stubSnctl(t, tb, `case "$*" in *get-token*) exec sleep 10 ;; esac`)
+	tb.writeRole(t, "slow", nil)
+
+	start := time.Now()
+	resp, err := tb.request(t, logical.ReadOperation, "creds/slow", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "snctl auth get-token timed out after 200ms") {
+		t.Fatalf("expected the read to time out, got %q", msg)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the timeout took %s to fire", elapsed)
+	}
+}
+
+func TestRequestTimeoutPrecedence(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "inherited", nil)
+	tb.writeRole(t, "overriding", map[string]interface{}{"request_timeout": "5s"})
+	timeout := func(name string) time.Duration {
+		t.Helper()
+		role, err := tb.readRole(context.Background(), tb.storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tb.config().forAccount(role).RequestTimeout
+	}
+
+	if got := timeout("inherited"); got != defaultRequestTimeout {
+		t.Fatalf("expected the built-in default, got %s", got)
+	}
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"request_timeout": "20s"})
+	if got := timeout("inherited"); got != 20*time.Second {
+		t.Fatalf("expected the mount's timeout, got %s", got)
+	}
+	if got := timeout("overriding"); got != 5*time.Second {
+		t.Fatalf("expected the role's timeout to take precedence, got %s", got)
+	}
+
+	invalid := testAccount(testKeyFile)
+	invalid["request_timeout"] = "soon"
+	resp, err := tb.request(t, logical.UpdateOperation, "invalid", invalid)
+	if msg := errorText(resp, err); !strings.Contains(msg, "request_timeout") {
+		t.Fatalf("expected a request_timeout that is not a duration to be rejected, got %q", msg)
+	}
+
+	stubSnctl(t, tb, `case "$*" in *get-token*) exec sleep 10 ;; esac`)
+	tb.writeRole(t, "slow", map[string]interface{}{"request_timeout": "1s"})
+	resp, err = tb.request(t, logical.ReadOperation, "creds/slow", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "timed out after 1s") {
+		t.Fatalf("expected the role's timeout to bound the read, got %q", msg)
+	}
+}
+
+// keyFilesLeft returns the temporary key files remaining in dir.
+func keyFilesLeft(t *testing.T, dir string) []string {
+	t.Helper()
+	left, err := filepath.Glob(filepath.Join(dir, "snio-key-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return left
+}
+
+func TestKeyFileRemoved(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	tb.setEnv(t, "SNCTL_KEY_FILE_STDIN", "")
+	tb.writeRole(t, "temp", nil)
+	tempDir := tb.config().TempDir
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/temp", map[string]interface{}{"no_cache": true})
+	activations := tb.snctl.Calls("auth activate-service-account")
+	if len(activations) != 1 || argAfter(activations[0].Args, "--key-file") == stdinKeyFile {
+		t.Fatalf("expected the key file on disk by default, got %v", activations)
+	}
+	if left := keyFilesLeft(t, tempDir); len(left) != 0 {
+		t.Fatalf("key files remain after a read: %v", left)
+	}
+
+	tb.snctl.On("auth get-token", snctltest.Response{Stderr: "unauthorized", ExitCode: 1})
+	if resp, err := tb.request(t, logical.ReadOperation, "creds/temp", map[string]interface{}{"no_cache": true}); errorText(resp, err) == "" {
+		t.Fatal("expected the read to fail")
+	}
+	if left := keyFilesLeft(t, tempDir); len(left) != 0 {
+		t.Fatalf("key files remain after a failed read: %v", left)
+	}
+}
+
+func TestKeyFileWriteError(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "temp", nil)
+	// The temporary directory disappears after the config was written.
+	if err := os.RemoveAll(tb.config().TempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/temp", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "Creating temporary key file failed") {
+		t.Fatalf("expected the key file error to be surfaced, got %q", msg)
+	}
+}
+
+func TestKeyFileReadOnlyTempDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root writes to any directory")
+	}
+	tb := getTestBackend(t)
+	tb.writeRole(t, "temp", nil)
+	tempDir := tb.config().TempDir
+	if err := os.Chmod(tempDir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(tempDir, 0700) })
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/temp", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "temporary key file failed") {
+		t.Fatalf("expected the key file error to be surfaced, got %q", msg)
+	}
+	if calls := tb.snctl.Calls("auth activate-service-account"); len(calls) != 0 {
+		t.Fatalf("snctl ran without a key file: %v", calls)
+	}
+}
+
+func TestKeyFileComplete(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "temp", nil)
+	var keyFile []byte
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if path := argAfter(cmd.Args, "--key-file"); path != "" {
+			var err error
+			if keyFile, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		return run(cmd)
+	}
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/temp", nil)
+	if string(keyFile) != testKeyFile {
+		t.Fatalf("snctl read an incomplete key file: %q", keyFile)
+	}
+}
+
+func TestKeyFileStdin(t *testing.T) {
+	if !stdinSupported() {
+		t.Skip("no /dev/stdin")
+	}
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"key_file_stdin": true})
+	tb.writeRole(t, "stdin", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/stdin", nil)
+	activations := tb.snctl.Calls("auth activate-service-account")
+	if len(activations) != 1 {
+		t.Fatalf("expected one activation, got %d", len(activations))
+	}
+	if path := argAfter(activations[0].Args, "--key-file"); path != stdinKeyFile {
+		t.Fatalf("expected the key file on %s, got %s", stdinKeyFile, path)
+	}
+	if string(activations[0].Stdin) != testKeyFile {
+		t.Fatal("the key file was not piped to snctl")
+	}
+	if left := keyFilesLeft(t, tb.config().TempDir); len(left) != 0 {
+		t.Fatalf("key files were written in stdin mode: %v", left)
+	}
+}
+
+func TestActivationFailureRedacted(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_MAX_RETRIES", "0")
+	tb.setEnv(t, "SNCTL_KEY_FILE_STDIN", "false")
+	// snctl echoes the key file it could not use.
+	tb.snctl.On("auth activate-service-account", snctltest.Response{
+		Stdout:   testKeyFile,
+		Stderr:   "error: invalid key file " + testKeyFile,
+		ExitCode: 1,
+	})
+	tb.writeRole(t, "redacted", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/redacted", nil)
+	msg := errorText(resp, err)
+	if msg == "" {
+		t.Fatal("expected the activation to fail")
+	}
+	if strings.Contains(tb.logs.String(), "test-client-secret") {
+		t.Fatalf("the client secret was logged: %s", tb.logs.String())
+	}
+	if strings.Contains(msg, "test-client-secret") {
+		t.Fatalf("the client secret was returned: %s", msg)
+	}
+}
+
+func TestTokenNotLogged(t *testing.T) {
+	tb := getTestBackend(t)
+	token := testJWT(t, nil)
+	tb.snctl.On("auth get-token", snctltest.Response{Stdout: "Refreshing " + token + "\n" + token, Stderr: token})
+	tb.writeRole(t, "quiet", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/quiet", nil)
+	if logs := tb.logs.String(); strings.Contains(logs, token) || strings.Contains(logs, "test-client-secret") {
+		t.Fatalf("secret material was logged: %s", logs)
+	}
+}
+
+func TestSnctlStderr(t *testing.T) {
+	tb := getTestBackend(t)
+	stubSnctl(t, tb, `case "$*" in *get-token*) echo "progress on stdout"; echo "error: cluster test-cluster not found in organization" >&2; exit 1 ;; esac`)
+	tb.writeRole(t, "stderr", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/stderr", nil)
+	msg := errorText(resp, err)
+	if !strings.Contains(msg, "error: cluster test-cluster not found in organization") {
+		t.Fatalf("expected the stderr message in the error, got %q", msg)
+	}
+	if strings.Contains(msg, "progress on stdout") {
+		t.Fatalf("stdout was mixed into the error: %q", msg)
+	}
+}
+
+// failGetToken makes the first n get-token invocations of tb fail with
+// stderr.
+func failGetToken(tb *testBackend, n int, stderr string) {
+	var mu sync.Mutex
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if strings.Contains(strings.Join(cmd.Args, " "), "auth get-token") {
+			mu.Lock()
+			fail := n > 0
+			n--
+			mu.Unlock()
+			if fail {
+				io.WriteString(cmd.Stderr, stderr)
+				return errors.New("exit status 1")
+			}
+		}
+		return run(cmd)
+	}
+}
+
+func TestGetTokenRetried(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "2")
+	tb.writeRole(t, "retried", nil)
+	failGetToken(tb, 1, "error: 503 Service Unavailable")
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/retried", nil)
+	if n := len(tb.snctl.Calls("auth get-token")); n != 1 {
+		t.Fatalf("expected the retry to succeed, got %d successful get-token calls", n)
+	}
+}
+
+func TestGetTokenRejectionNotRetried(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "2")
+	tb.writeRole(t, "rejected", nil)
+	failGetToken(tb, 1, "error: 401 Unauthorized: invalid_client")
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/rejected", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "invalid_client") {
+		t.Fatalf("expected the rejection without a retry, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != 0 {
+		t.Fatalf("the rejected get-token was retried %d times", n)
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	tb := getTestBackend(t)
+	dir := filepath.Join(t.TempDir(), "custom")
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"config_dir": dir})
+	tb.writeRole(t, "custom", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/custom", nil)
+	for _, subcommand := range []string{"config init", "auth activate-service-account", "auth get-token"} {
+		calls := tb.snctl.Calls(subcommand)
+		if len(calls) != 1 {
+			t.Fatalf("expected one %s, got %d", subcommand, len(calls))
+		}
+		if home := envValue(calls[0].Env, "HOME"); home != dir {
+			t.Fatalf("%s ran with HOME %q, expected %q", subcommand, home, dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".snctl")); err != nil {
+		t.Fatalf("the config was not initialized in config_dir: %v", err)
+	}
+}
+
+func TestConfigInitOnce(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.writeRole(t, "init", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tb.request(t, logical.ReadOperation, "creds/init", map[string]interface{}{"no_cache": true})
+		}()
+	}
+	wg.Wait()
+	if n := len(tb.snctl.Calls("config init")); n != 1 {
+		t.Fatalf("expected the missing config to be initialized once, got %d", n)
+	}
+}
+
+func TestConfigAlreadyInitialized(t *testing.T) {
+	tb := getTestBackend(t)
+	if err := os.Mkdir(filepath.Join(tb.config().ConfigDir, ".snctl"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	tb.writeRole(t, "init", nil)
+
+	tb.mustRequest(t, logical.ReadOperation, "creds/init", nil)
+	if n := len(tb.snctl.Calls("config init")); n != 0 {
+		t.Fatalf("expected an existing config to be used, got %d config init", n)
+	}
+}
+
+func TestConfigInitOutcomes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		init    string
+		log     string
+		failure string
+	}{
+		{"success", `echo "warning: using the default context"`, "`snctl config init` succeeded with output", ""},
+		{"already initialized", `mkdir -p "$HOME/.snctl"; echo "error: config already initialized" >&2; exit 1`, "snctl config is already initialized", ""},
+		{"hard failure", `echo "error: cannot write config: disk full" >&2; exit 3`, "Failed to run `snctl config init`", "error: cannot write config: disk full"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			stubSnctl(t, tb, fmt.Sprintf(`case "$*" in *"config init"*) %s ;; *get-token*) echo '%s' ;; esac`, tc.init, testJWT(t, nil)))
+			tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"home_fallback": false})
+			tb.writeRole(t, "init", nil)
+
+			resp, err := tb.request(t, logical.ReadOperation, "creds/init", nil)
+			msg := errorText(resp, err)
+			if tc.failure == "" && msg != "" {
+				t.Fatalf("expected the read to succeed, got %q", msg)
+			}
+			if !strings.Contains(msg, tc.failure) {
+				t.Fatalf("expected the init output in the error, got %q", msg)
+			}
+			if !strings.Contains(tb.logs.String(), tc.log) {
+				t.Fatalf("expected %q to be logged:\n%s", tc.log, tb.logs.String())
+			}
+		})
+	}
+}
+
+func TestConfigPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads any directory")
+	}
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"home_fallback": false})
+	dir := filepath.Join(tb.config().ConfigDir, ".snctl")
+	if err := os.Mkdir(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0700) })
+	tb.writeRole(t, "init", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/init", nil)
+	if msg := errorText(resp, err); !strings.Contains(msg, "is not readable by the plugin") {
+		t.Fatalf("expected a permission error, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("config init")); n != 0 {
+		t.Fatalf("expected no config init for an unreadable config, got %d", n)
+	}
+}
+
+func TestEagerInitMissingSnctl(t *testing.T) {
+	tb := getTestBackend(t)
+	t.Setenv("SNCTL_PATH", filepath.Join(t.TempDir(), "missing-snctl"))
+	initialize := func() {
+		t.Helper()
+		if err := tb.Initialize(context.Background(), &logical.InitializationRequest{Storage: tb.storage}); err != nil {
+			t.Fatalf("expected the mount to start despite the missing snctl, got %v", err)
+		}
+	}
+
+	initialize()
+	if logs := tb.logs.String(); strings.Contains(logs, "Token generation will fail") {
+		t.Fatalf("snctl was checked without eager_init:\n%s", logs)
+	}
+
+	t.Setenv("SNCTL_EAGER_INIT", "true")
+	initialize()
+	if logs := tb.logs.String(); !strings.Contains(logs, "[WARN]  Token generation will fail") || !strings.Contains(logs, "missing-snctl") {
+		t.Fatalf("expected a warning naming the missing snctl, got:\n%s", logs)
+	}
+}
+
+func TestConfigReinitialized(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	tb.writeRole(t, "init", nil)
+	configPath := filepath.Join(tb.config().ConfigDir, ".snctl")
+	run := tb.runner
+	tb.runner = func(cmd *exec.Cmd) error {
+		if strings.Contains(strings.Join(cmd.Args, " "), "activate-service-account") {
+			if _, err := os.Stat(filepath.Join(envValue(cmd.Env, "HOME"), ".snctl")); err != nil {
+				io.WriteString(cmd.Stderr, "error: snctl config not found")
+				return errors.New("exit status 1")
+			}
+		}
+		return run(cmd)
+	}
+	read := func() string {
+		resp, err := tb.request(t, logical.ReadOperation, "creds/init", map[string]interface{}{"no_cache": true})
+		return errorText(resp, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if msg := read(); msg != "" {
+			t.Fatal(msg)
+		}
+	}
+	if n := len(tb.snctl.Calls("config init")); n != 1 {
+		t.Fatalf("expected the config to be initialized once, got %d", n)
+	}
+
+	if err := os.RemoveAll(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); !strings.Contains(msg, "config not found") {
+		t.Fatalf("expected the read after the config disappeared to fail, got %q", msg)
+	}
+	if msg := read(); msg != "" {
+		t.Fatalf("expected the config to be initialized again, got %q", msg)
+	}
+	if n := len(tb.snctl.Calls("config init")); n != 2 {
+		t.Fatalf("expected the missing config to be initialized again, got %d config init", n)
+	}
+}
+
+func BenchmarkRequireSnctlConfig(b *testing.B) {
+	for _, cached := range []bool{true, false} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			tb := getTestBackend(b)
+			conf := tb.config()
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				tb.snctlLock.Lock()
+				if !cached {
+					tb.snctlReadyPath = ""
+				}
+				_, _, err := tb.requireSnctlConfig(ctx, conf)
+				tb.snctlLock.Unlock()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBoundedBuffer(t *testing.T) {
+	w := &boundedBuffer{limit: 8}
+	for _, chunk := range []string{"12345", "6789", "0"} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("expected writes past the limit to succeed, got %d, %v", n, err)
+		}
+	}
+	if got := string(w.Bytes()); got != "12345678"+truncatedMarker {
+		t.Fatalf("expected the output cut at the limit, got %q", got)
+	}
+
+	unbounded := &boundedBuffer{}
+	unbounded.Write(bytes.Repeat([]byte("x"), 1<<16))
+	if unbounded.truncated || len(unbounded.Bytes()) != 1<<16 {
+		t.Fatal("expected a zero limit to capture everything")
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	tb := getTestBackend(t)
+	mode := filepath.Join(t.TempDir(), "mode")
+	// The stub floods stdout or stderr with 1MiB, or prints a token.
+	stubSnctl(t, tb, fmt.Sprintf(`case "$*" in *get-token*)
+	case "$(cat %[1]q 2>/dev/null)" in
+	stdout) head -c 1048576 /dev/zero | tr '\0' x; exit 0 ;;
+	stderr) echo "error: something broke" >&2; head -c 1048576 /dev/zero | tr '\0' x >&2; exit 1 ;;
+	*) echo %[2]s; exit 0 ;;
+	esac ;;
+esac`, mode, testJWT(t, nil)))
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{"max_output_bytes": 4096})
+	tb.writeRole(t, "flooding", nil)
+	read := func(flood string) (*logical.Response, error) {
+		if err := os.WriteFile(mode, []byte(flood), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return tb.request(t, logical.ReadOperation, "creds/flooding", map[string]interface{}{"no_cache": true})
+	}
+
+	msg := errorText(read("stdout"))
+	if !strings.Contains(msg, "wrote more than max_output_bytes of 4096") {
+		t.Fatalf("expected the flooded stdout to be refused, got %q", msg)
+	}
+	msg = errorText(read("stderr"))
+	if !strings.Contains(msg, "error: something broke") {
+		t.Fatalf("expected the start of stderr in the error, got %q", msg)
+	}
+	if len(msg) > 4096 {
+		t.Fatalf("expected the error to stay bounded, got %d bytes", len(msg))
+	}
+	// The plugin is unaffected once snctl behaves again.
+	if msg := errorText(read("")); msg != "" {
+		t.Fatalf("expected a normal read to succeed, got %q", msg)
+	}
+}
+
+func TestEmptyToken(t *testing.T) {
+	for name, output := range map[string]string{
+		"empty":      ":",
+		"whitespace": `printf ' \n\t\n'`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tb := getTestBackend(t)
+			healthy := filepath.Join(t.TempDir(), "healthy")
+			stubSnctl(t, tb, fmt.Sprintf(`case "$*" in *get-token*)
+	if [ -f %[1]q ]; then echo %[2]s; else %[3]s; fi; exit 0 ;;
+esac`, healthy, testJWT(t, nil), output))
+			tb.writeRole(t, "empty", nil)
+
+			resp, err := tb.request(t, logical.ReadOperation, "creds/empty", nil)
+			if msg := errorText(resp, err); !strings.Contains(msg, "snctl returned an empty token") {
+				t.Fatalf("expected the empty token to be refused, got %q", msg)
+			}
+			var coded logical.HTTPCodedError
+			if !errors.As(err, &coded) || coded.Code() != http.StatusServiceUnavailable {
+				t.Fatalf("expected status 503, got %v", err)
+			}
+			tb.cache.mu.Lock()
+			cached := len(tb.cache.entries)
+			tb.cache.mu.Unlock()
+			if cached != 0 {
+				t.Fatalf("expected nothing to be cached, got %d entries", cached)
+			}
+
+			if err := os.WriteFile(healthy, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			resp = tb.mustRequest(t, logical.ReadOperation, "creds/empty", nil)
+			if token, _ := resp.Data["token"].(string); token == "" {
+				t.Fatal("expected a token once snctl prints one")
+			}
+		})
+	}
+}
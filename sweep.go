@@ -0,0 +1,119 @@
+package streamnative
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tempPrefixes name the per-request files and directories the plugin creates
+// in its temporary directory.
+var tempPrefixes = []string{"snio-key-", "snio-home-"}
+
+// liveTempFiles records the temporary files and directories this process has
+// created and not yet removed. The sweep never removes them, however old.
+var liveTempFiles = &tempRegistry{paths: make(map[string]struct{})}
+
+// tempRegistry is a set of temporary paths in use.
+type tempRegistry struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func (r *tempRegistry) add(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths[path] = struct{}{}
+}
+
+func (r *tempRegistry) contains(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.paths[path]
+	return ok
+}
+
+// remove deletes path and everything under it, and forgets it.
+func (r *tempRegistry) remove(path string) error {
+	err := os.RemoveAll(path)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.paths, path)
+	return err
+}
+
+// periodic runs Vault's periodic maintenance for the mount. Vault calls it
+// about once a minute; each task runs at its own interval.
+func (b *backend) periodic(ctx context.Context, req *logical.Request) error {
+	if b.closed.Load() {
+		// The mount is being torn down.
+		return nil
+	}
+	conf := b.config()
+	if time.Since(b.lastSweep) >= conf.SweepInterval {
+		b.lastSweep = time.Now()
+		b.sweep(conf)
+	}
+	if time.Since(b.lastRotationCheck) >= rotationCheckInterval {
+		b.lastRotationCheck = time.Now()
+		if err := b.warnOverdueRotations(ctx, req.Storage); err != nil {
+			b.Logger().Error("Checking key rotation schedules failed", "error", err)
+		}
+	}
+	return nil
+}
+
+// sweep drops expired tokens from the cache and removes temporary key files
+// and HOMEs left behind by requests that never cleaned up, such as those
+// interrupted by a crash.
+func (b *backend) sweep(conf *snctlConfig) {
+	if pruned := b.cache.prune(); pruned > 0 {
+		b.Logger().Debug("Pruned expired cached tokens", "count", pruned)
+	}
+	b.removeStaleTempFiles(conf)
+}
+
+// removeStaleTempFiles removes the plugin's temporary files and directories
+// older than the configured maximum age, except those still in use by this
+// process. Younger ones may belong to requests in flight in another process
+// sharing the directory.
+func (b *backend) removeStaleTempFiles(conf *snctlConfig) {
+	dir := conf.tempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		b.Logger().Warn("Listing the temporary directory failed", "path", dir, "error", err)
+		return
+	}
+	for _, entry := range entries {
+		if !hasTempPrefix(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < conf.TempFileMaxAge {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if liveTempFiles.contains(path) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			b.Logger().Warn("Removing stale temporary file failed", "path", path, "error", err)
+			continue
+		}
+		b.Logger().Info("Removed stale temporary file", "path", path)
+	}
+}
+
+func hasTempPrefix(name string) bool {
+	for _, prefix := range tempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package streamnative
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPeriodicSweep(t *testing.T) {
+	tb := getTestBackend(t)
+	tempDir := tb.config().TempDir
+	tb.cache.put("account", "expired", testJWT(t, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}))
+	tb.cache.put("account", "live", testJWT(t, nil))
+
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]bool{
+		"snio-key-old":   false,
+		"snio-home-old":  false,
+		"snio-key-young": true,
+		"unrelated-old":  true,
+	}
+	for name := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if name != "snio-key-young" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	live := filepath.Join(tempDir, "snio-key-in-use")
+	if err := os.WriteFile(live, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(live, old, old)
+	liveTempFiles.add(live)
+	defer liveTempFiles.remove(live)
+	files["snio-key-in-use"] = true
+
+	if err := tb.periodic(context.Background(), &logical.Request{Storage: tb.storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	tb.cache.mu.Lock()
+	_, expired := tb.cache.entries["expired"]
+	_, kept := tb.cache.entries["live"]
+	tb.cache.mu.Unlock()
+	if expired || !kept {
+		t.Errorf("expected only the expired token to be pruned, got expired=%v live=%v", expired, kept)
+	}
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(tempDir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s: expected kept=%v, exists=%v", name, kept, exists)
+		}
+	}
+}
+
+func TestStartupSweep(t *testing.T) {
+	tb := getTestBackend(t)
+	tempDir := tb.config().TempDir
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]bool{
+		"snio-key-crashed.json":  false,
+		"snio-key-inflight.json": true,
+	}
+	for name := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte(testKeyFile), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(tempDir, "snio-key-crashed.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.Initialize(context.Background(), &logical.InitializationRequest{Storage: tb.storage}); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(tempDir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s: expected kept=%v, exists=%v", name, kept, exists)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package streamnative
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// newHTTPClient builds the client for OAuth2 token requests. caCert, a PEM
+// bundle, replaces the system roots when set; clientCert and clientKey, both
+// PEM, present a client certificate for mutual TLS. With none of them set
+// the default client is used.
+func newHTTPClient(caCert, clientCert, clientKey string) (*http.Client, error) {
+	if caCert == "" && clientCert == "" && clientKey == "" {
+		return http.DefaultClient, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, fmt.Errorf("ca_cert contains no valid PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (clientCert == "") != (clientKey == "") {
+		return nil, fmt.Errorf("client_cert and client_key must be set together")
+	}
+	if clientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+		if err != nil {
+			return nil, errwrap.Wrapf("client_cert and client_key are not a valid PEM key pair: {{err}}", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,42 @@
+package streamnative
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records spans through the global OpenTelemetry tracer provider,
+// which is a no-op unless one has been registered.
+var tracer = otel.Tracer("github.com/arctype-co/vault-plugin-streamnative")
+
+// accountAttributes tags a span with the account's organization and cluster.
+// Nothing secret may be added here.
+func accountAttributes(data map[string]interface{}) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("streamnative.organization", fmt.Sprint(data["organization"])),
+		attribute.String("streamnative.cluster", fmt.Sprint(data["cluster"])),
+	)
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// responseError returns the error a handler's result represents, whether
+// returned directly or as an error response.
+func responseError(resp *logical.Response, err error) error {
+	if err == nil && resp != nil && resp.IsError() {
+		return resp.Error()
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package streamnative
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	registerExporter sync.Once
+)
+
+// recordSpans registers a tracer provider exporting to spanExporter, which
+// the package tracer delegates to once registered, and clears its spans.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	registerExporter.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	spanExporter.Reset()
+	t.Cleanup(spanExporter.Reset)
+	return spanExporter
+}
+
+func TestTracingSpans(t *testing.T) {
+	spans := recordSpans(t)
+	tb := getTestBackend(t)
+	tb.writeRole(t, "traced", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/traced", nil)
+
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans.GetSpans() {
+		byName[span.Name] = span
+	}
+	root, ok := byName["streamnative.issue_token"]
+	if !ok {
+		t.Fatalf("no issue_token span among %v", byName)
+	}
+	for _, name := range []string{"snctl.require_config", "snctl.activate_service_account", "snctl.get_token"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of issue_token", name)
+		}
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		if attrs["streamnative.organization"] != "test-org" || attrs["streamnative.cluster"] != "test-cluster" {
+			t.Errorf("%s has attributes %v", name, attrs)
+		}
+		if !span.EndTime.After(span.StartTime) {
+			t.Errorf("%s has no duration", name)
+		}
+	}
+	for _, span := range spans.GetSpans() {
+		for _, attr := range span.Attributes {
+			if strings.Contains(attr.Value.Emit(), "test-client-secret") {
+				t.Fatalf("%s carries the client secret", span.Name)
+			}
+		}
+	}
+}
+
+func TestTracingRecordsErrors(t *testing.T) {
+	spans := recordSpans(t)
+	tb := getTestBackend(t)
+	tb.setEnv(t, "SNCTL_MAX_RETRIES", "0")
+	tb.writeRole(t, "failing", nil)
+	failGetToken(tb, 1, "error: 401 unauthorized")
+	tb.request(t, logical.ReadOperation, "creds/failing", nil)
+
+	failed := 0
+	for _, span := range spans.GetSpans() {
+		switch span.Name {
+		case "streamnative.issue_token", "snctl.get_token":
+			failed++
+			if span.Status.Code != codes.Error || len(span.Events) == 0 {
+				t.Errorf("%s did not record the failure: %+v", span.Name, span.Status)
+			}
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("expected the issue_token and get_token spans, got %v", spans.GetSpans())
+	}
+}
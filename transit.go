@@ -0,0 +1,157 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// keyEncryptionField marks an account whose key files are stored encrypted,
+// naming the encryption used.
+const keyEncryptionField = "key_encryption"
+
+// keyEncryptionTransit is the keyEncryptionField value of key files
+// encrypted with a transit key.
+const keyEncryptionTransit = "transit"
+
+// keyCipher encrypts key files before they are stored, and decrypts them for
+// use.
+type keyCipher interface {
+	Encrypt(ctx context.Context, plaintext string) (string, error)
+	Decrypt(ctx context.Context, ciphertext string) (string, error)
+}
+
+// transitCipher encrypts with a key of a transit secrets engine, reached
+// through the Vault API with its own token.
+type transitCipher struct {
+	client *api.Client
+	mount  string
+	key    string
+}
+
+// newTransitCipher returns a cipher using the transit key at mount. An empty
+// address falls back to VAULT_ADDR.
+func newTransitCipher(address string, token string, mount string, key string) (*transitCipher, error) {
+	apiConf := api.DefaultConfig()
+	if apiConf.Error != nil {
+		return nil, errwrap.Wrapf("configuring the transit client failed: {{err}}", apiConf.Error)
+	}
+	if address != "" {
+		apiConf.Address = address
+	}
+	client, err := api.NewClient(apiConf)
+	if err != nil {
+		return nil, errwrap.Wrapf("creating the transit client failed: {{err}}", err)
+	}
+	client.SetToken(token)
+	return &transitCipher{client: client, mount: strings.Trim(mount, "/"), key: key}, nil
+}
+
+func (c *transitCipher) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	secret, err := c.client.Logical().WriteWithContext(ctx, c.mount+"/encrypt/"+c.key, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString([]byte(plaintext)),
+	})
+	if err != nil {
+		return "", errwrap.Wrapf("transit encryption failed: {{err}}", err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("transit encryption returned no data")
+	}
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok {
+		return "", fmt.Errorf("transit encryption returned no ciphertext")
+	}
+	return ciphertext, nil
+}
+
+func (c *transitCipher) Decrypt(ctx context.Context, ciphertext string) (string, error) {
+	secret, err := c.client.Logical().WriteWithContext(ctx, c.mount+"/decrypt/"+c.key, map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return "", errwrap.Wrapf("transit decryption failed: {{err}}", err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("transit decryption returned no data")
+	}
+	encoded, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return "", fmt.Errorf("transit decryption returned no plaintext")
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errwrap.Wrapf("transit plaintext is not base64: {{err}}", err)
+	}
+	return string(plaintext), nil
+}
+
+// encryptKeyFiles replaces the plaintext key files of account with their
+// encryption, when a key cipher is configured.
+func encryptKeyFiles(ctx context.Context, conf *snctlConfig, account map[string]interface{}) error {
+	delete(account, keyEncryptionField)
+	if conf.KeyCipher == nil {
+		return nil
+	}
+	return transformKeyFiles(account, func(keyFile string) (string, error) {
+		ciphertext, err := conf.KeyCipher.Encrypt(ctx, keyFile)
+		if err != nil {
+			return "", errwrap.Wrapf("Encrypting the key file failed: {{err}}", err)
+		}
+		return ciphertext, nil
+	}, keyEncryptionTransit)
+}
+
+// decryptKeyFiles replaces the encrypted key files of a stored account with
+// their plaintext.
+func decryptKeyFiles(ctx context.Context, conf *snctlConfig, account map[string]interface{}) error {
+	encryption, ok := account[keyEncryptionField]
+	if !ok {
+		return nil
+	}
+	if encryption != keyEncryptionTransit {
+		return fmt.Errorf("account key files use unknown encryption '%v'", encryption)
+	}
+	if conf.KeyCipher == nil {
+		return fmt.Errorf("account key files are encrypted with transit, but transit_mount and transit_key are not configured")
+	}
+	return transformKeyFiles(account, func(keyFile string) (string, error) {
+		plaintext, err := conf.KeyCipher.Decrypt(ctx, keyFile)
+		if err != nil {
+			return "", errwrap.Wrapf("Decrypting the key file failed: {{err}}", err)
+		}
+		return plaintext, nil
+	}, "")
+}
+
+// transformKeyFiles applies fn to the key-file and key_files of account, and
+// sets keyEncryptionField to encryption, or removes it when empty.
+func transformKeyFiles(account map[string]interface{}, fn func(string) (string, error), encryption string) error {
+	if keyFile, ok := account["key-file"].(string); ok {
+		transformed, err := fn(keyFile)
+		if err != nil {
+			return err
+		}
+		account["key-file"] = transformed
+	}
+	if _, ok := account["key_files"]; ok {
+		keyFiles := accountKeyFiles(account)
+		transformed := make([]interface{}, len(keyFiles))
+		for i, keyFile := range keyFiles {
+			var err error
+			if transformed[i], err = fn(keyFile); err != nil {
+				return err
+			}
+		}
+		account["key_files"] = transformed
+	}
+	if encryption == "" {
+		delete(account, keyEncryptionField)
+	} else {
+		account[keyEncryptionField] = encryption
+	}
+	return nil
+}
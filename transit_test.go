@@ -0,0 +1,196 @@
+package streamnative
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeTransit is a transit secrets engine mounted at transit/ with the key
+// snio. Its ciphertexts are opaque handles to the plaintexts it was given.
+type fakeTransit struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	plaintexts []string
+	decrypts   int
+}
+
+func newFakeTransit(t *testing.T) *fakeTransit {
+	t.Helper()
+	f := &fakeTransit{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTransit) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "transit-token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"errors":["invalid body"]}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var data map[string]string
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/snio":
+		plaintext, err := base64.StdEncoding.DecodeString(body["plaintext"])
+		if err != nil {
+			http.Error(w, `{"errors":["plaintext is not base64"]}`, http.StatusBadRequest)
+			return
+		}
+		f.plaintexts = append(f.plaintexts, string(plaintext))
+		data = map[string]string{"ciphertext": fmt.Sprintf("vault:v1:%d", len(f.plaintexts)-1)}
+	case "/v1/transit/decrypt/snio":
+		var i int
+		if _, err := fmt.Sscanf(body["ciphertext"], "vault:v1:%d", &i); err != nil || i >= len(f.plaintexts) {
+			http.Error(w, `{"errors":["invalid ciphertext"]}`, http.StatusBadRequest)
+			return
+		}
+		f.decrypts++
+		data = map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(f.plaintexts[i]))}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// decryptCount returns how many ciphertexts were decrypted.
+func (f *fakeTransit) decryptCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.decrypts
+}
+
+// configureTransit points the backend at the fake transit engine.
+func (tb *testBackend) configureTransit(t *testing.T, transit *fakeTransit) {
+	t.Helper()
+	tb.mustRequest(t, logical.UpdateOperation, "config/snctl", map[string]interface{}{
+		"key_file_stdin":  true,
+		"transit_mount":   "transit",
+		"transit_key":     "snio",
+		"transit_token":   "transit-token",
+		"transit_address": transit.URL,
+	})
+}
+
+// assertNoPlaintextKeys fails if any storage entry holds a key file's
+// client secret.
+func (tb *testBackend) assertNoPlaintextKeys(t *testing.T) {
+	t.Helper()
+	keys, err := logical.CollectKeys(context.Background(), tb.storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		entry, err := tb.storage.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry != nil && strings.Contains(string(entry.Value), "client_secret") {
+			t.Errorf("a plaintext key file was stored at %s", key)
+		}
+	}
+}
+
+func TestTransitRoundTrip(t *testing.T) {
+	tb := getTestBackend(t)
+	transit := newFakeTransit(t)
+	tb.configureTransit(t, transit)
+
+	tb.writeRole(t, "encrypted", nil)
+	tb.writeRole(t, "failover", map[string]interface{}{
+		"key-file":  nil,
+		"key_files": []interface{}{testKeyFileFor("first-client"), testKeyFileFor("second-client")},
+	})
+	tb.mustRequest(t, logical.UpdateOperation, "config/account/test-org", map[string]interface{}{
+		"key-file": testKeyFileFor("org-client"),
+	})
+	tb.mustRequest(t, logical.UpdateOperation, "roles/defaulted", map[string]interface{}{
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	tb.assertNoPlaintextKeys(t)
+	if account := tb.storedAccount(t, "roles/encrypted"); account[keyEncryptionField] != keyEncryptionTransit {
+		t.Fatalf("expected the role to be marked as transit encrypted, got %v", account)
+	}
+
+	// Reading a role decrypts its key to describe it.
+	resp := tb.mustRequest(t, logical.ReadOperation, "roles/encrypted", nil)
+	if resp.Data["key_fingerprint"] != fingerprint([]byte(testKeyFile)) {
+		t.Fatalf("expected the plaintext key's fingerprint, got %v", resp.Data["key_fingerprint"])
+	}
+
+	for name, client := range map[string]string{
+		"encrypted": "test-client",
+		"failover":  "first-client",
+		"defaulted": "org-client",
+	} {
+		tb.mustRequest(t, logical.ReadOperation, "creds/"+name, nil)
+		if key := tb.lastActivatedKey(t); !strings.Contains(key, client) {
+			t.Errorf("%s: expected the decrypted key of %s, got %s", name, client, key)
+		}
+	}
+
+	// Cache hits are served without decrypting the key files.
+	decrypts, minted := transit.decryptCount(), len(tb.snctl.Calls("auth get-token"))
+	for _, name := range []string{"encrypted", "failover", "defaulted"} {
+		tb.mustRequest(t, logical.ReadOperation, "creds/"+name, nil)
+	}
+	if n := transit.decryptCount(); n != decrypts {
+		t.Fatalf("expected cached reads not to decrypt, got %d decryptions", n-decrypts)
+	}
+	if n := len(tb.snctl.Calls("auth get-token")); n != minted {
+		t.Fatalf("expected cached reads not to mint, got %d mints", n-minted)
+	}
+	tb.mustRequest(t, logical.ReadOperation, "creds/encrypted", map[string]interface{}{"no_cache": true})
+	if n := transit.decryptCount(); n != decrypts+1 {
+		t.Fatalf("expected a cache miss to decrypt once, got %d decryptions", n-decrypts)
+	}
+	tb.assertNoPlaintextKeys(t)
+}
+
+func TestTransitUnavailable(t *testing.T) {
+	// The Vault client would otherwise retry the closed server with backoff.
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	tb := getTestBackend(t)
+	transit := newFakeTransit(t)
+	tb.configureTransit(t, transit)
+	tb.writeRole(t, "encrypted", nil)
+	tb.mustRequest(t, logical.ReadOperation, "creds/encrypted", nil)
+	transit.Close()
+
+	// A cached token needs no transit.
+	tb.mustRequest(t, logical.ReadOperation, "creds/encrypted", nil)
+
+	resp, err := tb.request(t, logical.ReadOperation, "creds/encrypted", map[string]interface{}{"no_cache": true})
+	if msg := errorText(resp, err); !strings.Contains(msg, "Decrypting the key file failed") {
+		t.Fatalf("expected a decryption failure, got %q", msg)
+	}
+	resp, err = tb.request(t, logical.UpdateOperation, "roles/unstored", map[string]interface{}{
+		"key-file":        testKeyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	})
+	if msg := errorText(resp, err); !strings.Contains(msg, "Encrypting the key file failed") {
+		t.Fatalf("expected an encryption failure, got %q", msg)
+	}
+	if entry, err := tb.storage.Get(context.Background(), "roles/unstored"); err != nil || entry != nil {
+		t.Fatalf("expected the role not to be stored, got %v, %v", entry, err)
+	}
+	tb.assertNoPlaintextKeys(t)
+}
@@ -0,0 +1,31 @@
+package streamnative
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Version is the plugin's version, set at build time with
+// -ldflags "-X github.com/arctype-co/vault-plugin-streamnative.Version=v1.2.3".
+var Version = "v0.0.0-dev"
+
+var versionPattern = regexp.MustCompile(`v?\d+\.\d+\.\d+[0-9A-Za-z.+-]*`)
+
+// parseSnctlVersion finds the version in `snctl version` output, falling back
+// to the trimmed output when none is recognized.
+func parseSnctlVersion(out []byte) string {
+	if version := versionPattern.Find(out); version != nil {
+		return string(version)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// snctlVersion runs `snctl version`.
+func (b *backend) snctlVersion(ctx context.Context, conf *snctlConfig) (string, error) {
+	out, err := b.runSnctl(ctx, conf, conf.ConfigDir, nil, "version", "version")
+	if err != nil {
+		return "", err
+	}
+	return parseSnctlVersion(out), nil
+}
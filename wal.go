@@ -0,0 +1,95 @@
+package streamnative
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// walKindAccountWrite marks a WAL entry guarding an account write.
+const walKindAccountWrite = "account_write"
+
+// walRollbackMinAge is how old an uncommitted account write must be before it
+// is rolled back, leaving in-flight writes alone.
+const walRollbackMinAge = 5 * time.Minute
+
+// accountWAL records an account as it was before a write, so that an
+// interrupted write can be undone.
+type accountWAL struct {
+	Path string `json:"path"`
+	// Previous is the entry's prior value; empty if it did not exist.
+	Previous string `json:"previous,omitempty"`
+	// Written is the fingerprint of the value being written.
+	Written string `json:"written"`
+}
+
+// putAccount stores an account entry under a WAL entry holding its previous
+// value. The WAL entry is removed once the write completes; if the write is
+// interrupted, walRollback restores the previous value unless the entry has
+// been written again since.
+func (b *backend) putAccount(ctx context.Context, s logical.Storage, path string, value []byte) error {
+	prev, err := s.Get(ctx, path)
+	if err != nil {
+		return errwrap.Wrapf("Reading from storage failed: {{err}}", err)
+	}
+	wal := &accountWAL{Path: path, Written: fingerprint(value)}
+	if prev != nil {
+		wal.Previous = string(prev.Value)
+	}
+	walID, err := framework.PutWAL(ctx, s, walKindAccountWrite, wal)
+	if err != nil {
+		return errwrap.Wrapf("Writing WAL entry failed: {{err}}", err)
+	}
+
+	// The entry holds the key file; seal wrap it where the seal supports it.
+	if err := s.Put(ctx, &logical.StorageEntry{Key: path, Value: value, SealWrap: true}); err != nil {
+		return err
+	}
+
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		// The write succeeded, but the next rollback will revert it unless
+		// the account is written again first.
+		b.Logger().Error("Removing WAL entry failed; the write will be rolled back", "path", path, "error", err)
+	}
+	return nil
+}
+
+// walRollback undoes an account write that never completed, restoring the
+// entry's previous value or removing an entry that did not exist before. An
+// entry that no longer holds the value the write stored has been written
+// again since, or was never written, and is left alone.
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	if kind != walKindAccountWrite {
+		return fmt.Errorf("unknown WAL entry type '%s'", kind)
+	}
+	raw, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("malformed WAL entry")
+	}
+	path, _ := raw["path"].(string)
+	if path == "" {
+		return fmt.Errorf("WAL entry has no path")
+	}
+	previous, _ := raw["previous"].(string)
+	written, _ := raw["written"].(string)
+
+	current, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if current == nil || fingerprint(current.Value) != written {
+		b.Logger().Debug("Interrupted account write left nothing to roll back", "path", path)
+		return nil
+	}
+
+	b.Logger().Warn("Rolling back interrupted account write", "path", path)
+	b.cache.invalidate(path)
+	if previous == "" {
+		return req.Storage.Delete(ctx, path)
+	}
+	return req.Storage.Put(ctx, &logical.StorageEntry{Key: path, Value: []byte(previous), SealWrap: true})
+}
@@ -0,0 +1,106 @@
+package streamnative
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// interruptingStorage stores account entries but then fails, as a write
+// interrupted after reaching storage does, leaving its WAL entry behind.
+type interruptingStorage struct {
+	logical.Storage
+	path string
+}
+
+func (s *interruptingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if err := s.Storage.Put(ctx, entry); err != nil {
+		return err
+	}
+	if entry.Key == s.path {
+		return errors.New("interrupted")
+	}
+	return nil
+}
+
+// rollback runs the WAL rollback regardless of the entries' age.
+func (tb *testBackend) rollback(t *testing.T) {
+	t.Helper()
+	resp, err := tb.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   tb.storage,
+		Data:      map[string]interface{}{"immediate": true},
+	})
+	if msg := errorText(resp, err); msg != "" {
+		t.Fatalf("rollback failed: %s", msg)
+	}
+	if keys, err := framework.ListWAL(context.Background(), tb.storage); err != nil || len(keys) != 0 {
+		t.Fatalf("expected the rollback to remove the WAL entries, got %v, %v", keys, err)
+	}
+}
+
+// interruptedWrite writes an account at path through storage that fails once
+// the entry is stored.
+func (tb *testBackend) interruptedWrite(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	storage := tb.storage
+	tb.storage = &interruptingStorage{Storage: storage, path: path}
+	defer func() { tb.storage = storage }()
+	if resp, err := tb.request(t, logical.UpdateOperation, path, data); errorText(resp, err) == "" {
+		t.Fatal("expected the interrupted write to fail")
+	}
+}
+
+// testAccount returns the fields of an account using keyFile.
+func testAccount(keyFile string) map[string]interface{} {
+	return map[string]interface{}{
+		"key-file":        keyFile,
+		"organization":    "test-org",
+		"default_cluster": "test-cluster",
+	}
+}
+
+func TestInterruptedWriteRolledBack(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.mustRequest(t, logical.UpdateOperation, "existing", testAccount(testKeyFile))
+
+	tb.interruptedWrite(t, "existing", testAccount(testKeyFileFor("new-client")))
+	tb.interruptedWrite(t, "created", testAccount(testKeyFile))
+	tb.rollback(t)
+
+	if got := tb.storedAccount(t, "existing")["key-file"]; got != testKeyFile {
+		t.Fatalf("expected the previous key to be restored, got %v", got)
+	}
+	if entry, err := tb.storage.Get(context.Background(), "created"); err != nil || entry != nil {
+		t.Fatalf("expected the interrupted create to be removed, got %v, %v", entry, err)
+	}
+}
+
+func TestRollbackKeepsLaterWrite(t *testing.T) {
+	tb := getTestBackend(t)
+	tb.interruptedWrite(t, "rewritten", testAccount(testKeyFile))
+	tb.mustRequest(t, logical.UpdateOperation, "rewritten", testAccount(testKeyFileFor("new-client")))
+	tb.rollback(t)
+
+	if got := tb.storedAccount(t, "rewritten")["key-file"]; got != testKeyFileFor("new-client") {
+		t.Fatalf("the rollback reverted a later write: %v", got)
+	}
+}
+
+func TestWALPathReserved(t *testing.T) {
+	tb := getTestBackend(t)
+	for _, op := range []logical.Operation{logical.UpdateOperation, logical.ReadOperation, logical.DeleteOperation} {
+		var data map[string]interface{}
+		if op == logical.UpdateOperation {
+			data = testAccount(testKeyFile)
+		}
+		resp, err := tb.request(t, op, framework.WALPrefix+"entry", data)
+		if msg := errorText(resp, err); !strings.Contains(msg, "reserved") {
+			t.Errorf("expected %s of a WAL path to be refused, got %q", op, msg)
+		}
+	}
+}